@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// runKeys implements the "keys" subcommand:
+//
+//	server keys rename [-from-prefix old] [-from-tenant old] [-dry-run] [-overwrite]
+//
+// rename moves every key of the server's key families from the old keyspace
+// into the configured one (REDIS_KEY_PREFIX and REDIS_TENANT_ID), rewriting
+// keys in the formats of servers before keyspaces (see keyRenamer.legacy).
+// With the old keyspace the configured one, it rewrites just those. Stop the
+// servers first: keys written during the rename may be left behind.
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		keysUsage()
+		return errors.New("missing keys command")
+	}
+	if args[0] != "rename" {
+		keysUsage()
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet("keys rename", flag.ExitOnError)
+	fromPrefix := fs.String("from-prefix", "", "key prefix to rename from (default: none)")
+	fromTenant := fs.String("from-tenant", "", "tenant ID to rename from (default: none)")
+	dryRun := fs.Bool("dry-run", false, "print the renames without making them")
+	overwrite := fs.Bool("overwrite", false, "replace keys that already exist in the new keyspace")
+	fs.Parse(args[1:])
+
+	from, err := storage.NewKeyspace(*fromPrefix, *fromTenant)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	to, err := storage.NewKeyspace(cfg.Redis.KeyPrefix, cfg.Redis.TenantID)
+	if err != nil {
+		return err
+	}
+
+	client, err := connectKeys(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	defer client.Close()
+
+	renamer := &keyRenamer{
+		client:    client,
+		from:      from,
+		to:        to,
+		dryRun:    *dryRun,
+		overwrite: *overwrite,
+		out:       keysOut,
+	}
+	stats, err := renamer.rename(context.Background())
+	if err != nil {
+		return err
+	}
+
+	verb := "renamed"
+	if *dryRun {
+		verb = "would rename"
+	}
+	fmt.Fprintf(keysOut, "%s %d keys, skipped %d, dropped %d\n", verb, stats.renamed, stats.skipped, stats.dropped)
+	return nil
+}
+
+// connectKeys and keysOut are the keys subcommand's Redis and output. Tests
+// replace them.
+var (
+	connectKeys           = storage.NewRedisClient
+	keysOut     io.Writer = os.Stdout
+)
+
+// keyRenamer moves the keys of every key family from one keyspace into
+// another, printing what it skips and drops and, on a dry run, what it would
+// rename.
+type keyRenamer struct {
+	client    storage.RedisClient
+	from, to  storage.Keyspace
+	dryRun    bool
+	overwrite bool
+	out       io.Writer
+}
+
+// renameStats counts the keys renamed, or that would be on a dry run, those
+// skipped and those dropped.
+type renameStats struct {
+	renamed, skipped, dropped int
+}
+
+// rename moves the key families, then drops the legacy geohash sets.
+func (r *keyRenamer) rename(ctx context.Context) (renameStats, error) {
+	var stats renameStats
+	for _, family := range storage.KeyFamilies {
+		keys, err := scanKeys(ctx, r.client, r.from.Key(family, "*"))
+		if err != nil {
+			return stats, err
+		}
+
+		for _, key := range keys {
+			// When the new keyspace is nested in the old one, its keys
+			// match the old pattern too
+			if len(r.to.Root()) > len(r.from.Root()) && strings.HasPrefix(key, r.to.Root()) {
+				continue
+			}
+			name := strings.TrimPrefix(key, r.from.Root())
+
+			newKey, legacy, err := r.legacy(name)
+			if err != nil {
+				return stats, err
+			}
+			if !legacy {
+				newKey = r.to.Root() + name
+			}
+			if newKey == "" {
+				stats.skipped++
+				continue
+			}
+			if newKey == key {
+				continue
+			}
+
+			if !r.overwrite {
+				exists, err := r.client.Exists(ctx, newKey)
+				if err != nil {
+					return stats, err
+				}
+				if exists > 0 {
+					fmt.Fprintf(r.out, "skip %s: %s exists\n", key, newKey)
+					stats.skipped++
+					continue
+				}
+			}
+
+			if r.dryRun {
+				fmt.Fprintf(r.out, "%s -> %s\n", key, newKey)
+				stats.renamed++
+				continue
+			}
+			if err := r.client.Rename(ctx, key, newKey); err != nil {
+				return stats, fmt.Errorf("failed to rename %s: %w", key, err)
+			}
+			if legacy && strings.HasPrefix(name, "location:") {
+				if err := r.indexLocation(ctx, newKey); err != nil {
+					return stats, err
+				}
+			}
+			stats.renamed++
+		}
+	}
+
+	// The cell indexes rebuilt from the locations replace these
+	sets, err := scanKeys(ctx, r.client, r.from.Key("geohash", "*"))
+	if err != nil {
+		return stats, err
+	}
+	for _, key := range sets {
+		fmt.Fprintf(r.out, "drop %s: cells are indexed from the locations\n", key)
+		stats.dropped++
+		if r.dryRun {
+			continue
+		}
+		if err := r.client.Del(ctx, key); err != nil {
+			return stats, fmt.Errorf("failed to drop %s: %w", key, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// legacy returns the new key for a key named in a format of servers before
+// keyspaces, and whether it was one. The new key is empty for keys that are
+// not moved. Those servers wrote:
+//
+//   - location:<id>, now location:{<id>}, tagged so a session's keys share a
+//     slot. The session joins its cell's index as it is renamed (see
+//     indexLocation).
+//   - geohash:<cell>, sets replaced by the cell indexes; rename drops them.
+//   - spam:msg:<id>:<hash>, duplicate checks replaced by spam:recent:<id>.
+//     They are left to expire, which they do within the duplicate window.
+func (r *keyRenamer) legacy(name string) (string, bool, error) {
+	if id, ok := strings.CutPrefix(name, "location:"); ok && !strings.HasPrefix(id, "{") {
+		return r.to.Key("location", storage.HashTag(id)), true, nil
+	}
+	if strings.HasPrefix(name, "spam:msg:") {
+		fmt.Fprintf(r.out, "skip %s: old duplicate check, expires on its own\n", r.from.Root()+name)
+		return "", true, nil
+	}
+	return "", false, nil
+}
+
+// indexLocation adds a renamed legacy location to its cell's index, scored
+// by its last update as location.Service does. The index is left without
+// an expiry; the stale location reaper trims it. With LOCATION_BACKEND=geo,
+// the session rejoins the index at its next location update instead.
+func (r *keyRenamer) indexLocation(ctx context.Context, key string) error {
+	data, err := r.client.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	var loc location.Location
+	if err := json.Unmarshal([]byte(data), &loc); err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	cell := r.to.Key("cell", storage.CellTag(loc.Geohash), loc.Geohash)
+	err = r.client.ZAdd(ctx, cell, &redis.Z{Score: float64(loc.UpdatedAt.UnixMilli()), Member: loc.SessionID})
+	if err != nil {
+		return fmt.Errorf("failed to index %s: %w", key, err)
+	}
+	return nil
+}
+
+// scanKeys collects every key matching pattern before any is renamed, so the
+// scan does not meet renamed keys again.
+func scanKeys(ctx context.Context, client storage.RedisClient, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", pattern, err)
+	}
+	return keys, nil
+}
+
+func keysUsage() {
+	fmt.Fprintln(os.Stderr, "usage: server keys <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  rename   move keys from -from-prefix/-from-tenant into the configured keyspace")
+}
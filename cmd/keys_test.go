@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRunKeysErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no command", nil, "missing keys command"},
+		{"unknown command", []string{"move"}, `unknown keys command "move"`},
+		{"bad prefix", []string{"rename", "-from-prefix", "a:b"}, "prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REDIS_KEY_PREFIX", "")
+			t.Setenv("REDIS_TENANT_ID", "")
+			if err := runKeys(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runKeys(%q) error %v, want one containing %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
+
+// unclosed keeps a test's client open when runKeys closes it.
+type unclosed struct {
+	storage.RedisClient
+}
+
+func (unclosed) Close() error {
+	return nil
+}
+
+// useKeysClient points the keys subcommand at client and returns its
+// output.
+func useKeysClient(t *testing.T, client storage.RedisClient) *bytes.Buffer {
+	t.Helper()
+
+	connect, out := connectKeys, keysOut
+	t.Cleanup(func() { connectKeys, keysOut = connect, out })
+
+	var buf bytes.Buffer
+	connectKeys = func(*config.Config) (storage.RedisClient, error) { return unclosed{client}, nil }
+	keysOut = &buf
+	return &buf
+}
+
+// keyValues returns every key and its string value, empty for other types.
+func keyValues(t *testing.T, client storage.RedisClient) map[string]string {
+	t.Helper()
+
+	ctx := context.Background()
+	keys, err := scanKeys(ctx, client, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		values[key], _ = client.Get(ctx, key)
+	}
+	return values
+}
+
+func TestRunKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// prefix and tenant are the configured keyspace
+		prefix, tenant string
+		before         map[string]string
+		want           map[string]string
+		// wantSummary is the last line printed
+		wantSummary string
+	}{
+		{
+			name:   "into a prefix",
+			args:   []string{"rename"},
+			prefix: "app",
+			before: map[string]string{
+				"session:s1":       "a",
+				"ratelimit:msg:s1": "b",
+				"unrelated:key":    "c",
+			},
+			want: map[string]string{
+				"app:session:s1":       "a",
+				"app:ratelimit:msg:s1": "b",
+				"unrelated:key":        "c",
+			},
+			wantSummary: "renamed 2 keys, skipped 0, dropped 0",
+		},
+		{
+			name:   "existing keys are kept",
+			args:   []string{"rename"},
+			prefix: "app",
+			before: map[string]string{
+				"session:s1":     "old",
+				"app:session:s1": "new",
+				"session:s2":     "old",
+			},
+			want: map[string]string{
+				"session:s1":     "old",
+				"app:session:s1": "new",
+				"app:session:s2": "old",
+			},
+			wantSummary: "renamed 1 keys, skipped 1, dropped 0",
+		},
+		{
+			name:   "overwrite",
+			args:   []string{"rename", "-overwrite"},
+			prefix: "app",
+			before: map[string]string{
+				"session:s1":     "old",
+				"app:session:s1": "new",
+			},
+			want: map[string]string{
+				"app:session:s1": "old",
+			},
+			wantSummary: "renamed 1 keys, skipped 0, dropped 0",
+		},
+		{
+			name:   "dry run",
+			args:   []string{"rename", "-dry-run"},
+			prefix: "app",
+			before: map[string]string{
+				"session:s1":     "old",
+				"session:s2":     "old",
+				"app:session:s2": "new",
+			},
+			want: map[string]string{
+				"session:s1":     "old",
+				"session:s2":     "old",
+				"app:session:s2": "new",
+			},
+			wantSummary: "would rename 1 keys, skipped 1, dropped 0",
+		},
+		{
+			// The new keyspace app:ws: sits inside the old one's ws family,
+			// so keys under it are taken to be new and left alone
+			name:   "nested keyspace",
+			args:   []string{"rename", "-from-prefix", "app"},
+			prefix: "app",
+			tenant: "ws",
+			before: map[string]string{
+				"app:ws:active":     "old",
+				"app:ws:session:s1": "new",
+				"app:session:s2":    "old",
+			},
+			want: map[string]string{
+				"app:ws:active":     "old",
+				"app:ws:session:s1": "new",
+				"app:ws:session:s2": "old",
+			},
+			wantSummary: "renamed 1 keys, skipped 0, dropped 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REDIS_KEY_PREFIX", tt.prefix)
+			t.Setenv("REDIS_TENANT_ID", tt.tenant)
+
+			ctx := context.Background()
+			client := storagetest.NewClient(t)
+			for key, value := range tt.before {
+				if err := client.Set(ctx, key, value, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := useKeysClient(t, client)
+
+			if err := runKeys(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := keyValues(t, client); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys after rename:\n%v\nwant\n%v", sorted(got), sorted(tt.want))
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if got := lines[len(lines)-1]; got != tt.wantSummary {
+				t.Errorf("summary %q, want %q", got, tt.wantSummary)
+			}
+		})
+	}
+}
+
+// TestRunKeysLegacy checks keys written before keyspaces are rewritten,
+// moved into a prefix or in place.
+func TestRunKeysLegacy(t *testing.T) {
+	updated := time.UnixMilli(1700000000000).UTC()
+	data, err := json.Marshal(location.Location{SessionID: "s1", Geohash: "9q8yyk", UpdatedAt: updated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"app", ""} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			t.Setenv("REDIS_KEY_PREFIX", prefix)
+			t.Setenv("REDIS_TENANT_ID", "")
+			to := mustKeyspace(t, prefix, "")
+
+			ctx := context.Background()
+			client := storagetest.NewClient(t)
+			client.Set(ctx, "location:s1", string(data), time.Minute)
+			client.SAdd(ctx, "geohash:9q8yyk", "s1")
+			client.Set(ctx, "spam:msg:s1:abc", "1", time.Minute)
+			out := useKeysClient(t, client)
+
+			if err := runKeys([]string{"rename"}); err != nil {
+				t.Fatal(err)
+			}
+
+			cell := to.Key("cell", "{cell:9q8yy}", "9q8yyk")
+			want := map[string]string{
+				to.Key("location", "{s1}"): string(data),
+				cell:                       "",
+				// Left to expire
+				"spam:msg:s1:abc": "1",
+			}
+			if got := keyValues(t, client); !reflect.DeepEqual(got, want) {
+				t.Errorf("keys after rename:\n%v\nwant\n%v", sorted(got), sorted(want))
+			}
+
+			members, err := client.ZRangeByScore(ctx, cell, &redis.ZRangeBy{
+				Min: strconv.FormatInt(updated.UnixMilli(), 10),
+				Max: strconv.FormatInt(updated.UnixMilli(), 10),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, []string{"s1"}) {
+				t.Errorf("%s scored by the update has %v, want [s1]", cell, members)
+			}
+			if !strings.HasSuffix(out.String(), "renamed 1 keys, skipped 1, dropped 1\n") {
+				t.Errorf("output:\n%s\nwant 1 renamed, 1 skipped and 1 dropped", out)
+			}
+		})
+	}
+}
+
+func mustKeyspace(t *testing.T, prefix, tenant string) storage.Keyspace {
+	t.Helper()
+
+	keys, err := storage.NewKeyspace(prefix, tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func sorted(values map[string]string) []string {
+	var lines []string
+	for key, value := range values {
+		lines = append(lines, key+"="+value)
+	}
+	sort.Strings(lines)
+	return lines
+}
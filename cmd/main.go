@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,11 +15,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"github.com/askwhyharsh/neartalk/internal/analytics"
 	"github.com/askwhyharsh/neartalk/internal/api"
+	"github.com/askwhyharsh/neartalk/internal/clientip"
 	"github.com/askwhyharsh/neartalk/internal/config"
 	"github.com/askwhyharsh/neartalk/internal/location"
 	"github.com/askwhyharsh/neartalk/internal/message"
 	"github.com/askwhyharsh/neartalk/internal/ratelimit"
+	"github.com/askwhyharsh/neartalk/internal/reputation"
 	"github.com/askwhyharsh/neartalk/internal/session"
 	"github.com/askwhyharsh/neartalk/internal/spam"
 	"github.com/askwhyharsh/neartalk/internal/storage"
@@ -32,6 +37,22 @@ func main() {
 		log.Println("No .env file found, using environment variables")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "keys:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize logger
 	appLogger := logger.NewLogger(os.Getenv("LOG_LEVEL"))
 	appLogger.Info("Starting PeopleAroundMe server...")
@@ -39,18 +60,43 @@ func main() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		// TODO: make it FATAL err later
-		appLogger.Error("Failed to load configuration", "error", err)
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			for _, problem := range invalid.Problems {
+				appLogger.Error("Invalid configuration", "problem", problem)
+			}
+		} else {
+			appLogger.Error("Failed to load configuration", "error", err)
+		}
+		os.Exit(1)
 	}
 
 	// Initialize Redis
-	redisClient, err := storage.NewRedisClient(cfg)
+	redisClient, err := storage.NewClient(cfg)
 	if err != nil {
-		// TODO: make it FATAL err later
 		appLogger.Error("Failed to connect to Redis", "error", err)
+		os.Exit(1)
 	}
 	defer redisClient.Close()
-	appLogger.Info("Connected to Redis", "address", cfg.RedisAddr())
+	if cfg.Redis.Storage == "memory" {
+		appLogger.Warn("Using in-memory storage; state is lost on restart and not shared between servers")
+	} else {
+		appLogger.Info("Connected to Redis", "mode", cfg.Redis.Mode, "addresses", cfg.RedisAddrs())
+	}
+
+	// Initialize Postgres, which persists bans when enabled
+	var banStore spam.BanStore
+	var analyticsStore analytics.Store
+	if cfg.Postgres.Enabled {
+		pg, err := connectPostgres(cfg.Postgres, appLogger)
+		if err != nil {
+			appLogger.Error("Failed to initialize Postgres", "error", err)
+			os.Exit(1)
+		}
+		defer pg.Close()
+		banStore = pg
+		analyticsStore = pg
+	}
 
 	// Context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -61,25 +107,69 @@ func main() {
 
 	sessionManager := session.NewManager(sessionService, appLogger)
 
-	locationService := location.NewService(
-		redisClient,
-		cfg.Location.GeohashPrecision,
-		cfg.Location.MinRadiusMeters,
-		cfg.Location.MaxRadiusMeters,
-	)
+	var locationService location.LocationService
+	switch cfg.Location.Backend {
+	case "geohash":
+		locationService = location.NewService(
+			redisClient,
+			cfg.Location.GeohashPrecision,
+			cfg.Location.MinRadiusMeters,
+			cfg.Location.MaxRadiusMeters,
+		)
+	case "geo":
+		locationService = location.NewGeoService(
+			redisClient,
+			cfg.Location.GeohashPrecision,
+			cfg.Location.MinRadiusMeters,
+			cfg.Location.MaxRadiusMeters,
+		)
+	default:
+		appLogger.Error("Unknown location backend", "backend", cfg.Location.Backend)
+		os.Exit(1)
+	}
+
+	locationReaper := location.NewReaper(locationService, cfg.Location.CleanupInterval)
 
 	messageStore := message.NewStore(redisClient, cfg.Session.MessageTTL)
 	// messageRouter := message.NewRouter(redisClient, messageStore)
 	ttlManager := message.NewTTLManager(messageStore, appLogger)
 
-	spamDetector := spam.NewDetector(
+	analyticsService := analytics.NewService(redisClient, cfg.Location.GeohashPrecision, cfg.Analytics)
+	analyticsAggregator := analytics.NewAggregator(analyticsService, analyticsStore, cfg.Analytics)
+
+	reputationService := reputation.NewService(redisClient, sessionService, cfg.Reputation, cfg.RateLimit.IPv6PrefixBits)
+
+	spamDetector, err := spam.NewDetector(redisClient, cfg.Spam, reputationService)
+	if err != nil {
+		appLogger.Error("Failed to initialize spam detector", "error", err)
+		os.Exit(1)
+	}
+
+	redactor, err := spam.NewRedactor(cfg.Spam.PIIRedaction)
+	if err != nil {
+		appLogger.Error("Failed to initialize PII redaction", "error", err)
+		os.Exit(1)
+	}
+
+	banManager := spam.NewBanManager(
 		redisClient,
-		cfg.Spam.ProfanityEnabled,
-		cfg.Spam.DuplicateWindowSeconds,
-		cfg.Spam.MaxURLsPerMessage,
+		banStore,
+		cfg.Spam.BanBaseDuration,
+		cfg.Spam.BanMaxDuration,
+		cfg.Spam.ShadowBanDuration,
 	)
 
-	rateLimiter := ratelimit.NewLimiter(redisClient, cfg.RateLimit)
+	ipResolver, err := clientip.NewResolver(cfg.Server.TrustedProxies, cfg.Server.ClientIPHeaders)
+	if err != nil {
+		appLogger.Error("Failed to configure client IP resolution", "error", err)
+		os.Exit(1)
+	}
+
+	rateLimiter, err := ratelimit.NewLimiter(redisClient, cfg.RateLimit, reputationService)
+	if err != nil {
+		appLogger.Error("Failed to initialize rate limiter", "error", err)
+		os.Exit(1)
+	}
 	rateLimitMiddleware := ratelimit.NewMiddleware(rateLimiter)
 
 	// Initialize validator
@@ -97,7 +187,11 @@ func main() {
 		sessionService,
 		locationService,
 		spamDetector,
+		banManager,
+		redactor,
 		rateLimiter,
+		reputationService,
+		analyticsService,
 		cfg.Session.MessageTTL,
 	)
 
@@ -106,22 +200,42 @@ func main() {
 		sessionService,
 		locationService,
 		rateLimiter,
+		banManager,
+		reputationService,
 		val,
+		analyticsService,
 		wsHandler,
 	)
 
+	adminHandler := api.NewAdminHandler(cfg.Admin.Token, sessionService, banManager, spamDetector.Classifier(), analyticsAggregator)
+
 	// Start background services
 	go sessionManager.Start(ctx)
 	go ttlManager.Start(ctx)
+	go locationReaper.Start(ctx, appLogger)
+	go spamDetector.WatchProfanityLists(ctx, appLogger)
+	go rateLimiter.Start(ctx, appLogger)
+	go analyticsAggregator.Start(ctx, appLogger)
+	if classifier := spamDetector.Classifier(); classifier != nil {
+		go classifier.Start(ctx, appLogger)
+	}
 
 	// Setup Gin router
-	if cfg.Env == "production" {
+	if cfg.Server.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	router := gin.New()
 	router.Use(gin.Recovery())
 
+	// Resolve client IPs from trusted proxies only; Gin's own resolution
+	// trusts forwarding headers from anyone
+	if err := router.SetTrustedProxies(nil); err != nil {
+		appLogger.Error("Failed to configure trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	router.Use(ipResolver.Middleware())
+
 	// Add logging middleware
 	router.Use(func(c *gin.Context) {
 		start := time.Now()
@@ -132,12 +246,17 @@ func main() {
 			"path", c.Request.URL.Path,
 			"status", c.Writer.Status(),
 			"duration", duration,
-			"ip", c.ClientIP(),
+			"ip", clientip.FromContext(c),
 		)
 	})
 
 	// Setup routes
-	api.SetupRoutes(router, apiHandler, wsHandler, rateLimitMiddleware)
+	api.SetupRoutes(router, apiHandler, adminHandler, wsHandler, rateLimitMiddleware)
+
+	// Runtime metrics, including rate limit fallback counters
+	if cfg.Monitoring.EnableMetrics {
+		router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -150,7 +269,7 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		appLogger.Info("Server starting", "address", srv.Addr, "env", cfg.Env)
+		appLogger.Info("Server starting", "address", srv.Addr, "env", cfg.Server.Env)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			appLogger.Error("Failed to start server", "error", err)
 		}
@@ -176,3 +295,49 @@ func main() {
 
 	appLogger.Info("Server stopped")
 }
+
+// connectPostgres connects and brings the schema up to date, or refuses to
+// start on pending migrations when auto-migrate is off.
+func connectPostgres(cfg config.PostgresConfig, appLogger logger.Logger) (*storage.PostgresClient, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("DATABASE_URL is not set")
+	}
+
+	pg, err := storage.NewPostgresClient(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := pg.Migrator()
+	if err != nil {
+		pg.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if cfg.AutoMigrate {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			pg.Close()
+			return nil, err
+		}
+		for _, m := range applied {
+			appLogger.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+	} else {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			pg.Close()
+			return nil, err
+		}
+		if len(pending) > 0 {
+			pg.Close()
+			return nil, fmt.Errorf("%d pending migrations; run \"migrate up\"", len(pending))
+		}
+	}
+
+	appLogger.Info("Connected to Postgres")
+	return pg, nil
+}
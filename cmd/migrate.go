@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+)
+
+// runMigrate implements the "migrate" subcommand:
+//
+//	server migrate up
+//	server migrate down [-steps 1]
+//	server migrate status
+func runMigrate(args []string) error {
+	if len(args) < 1 {
+		migrateUsage()
+		return errors.New("missing migrate command")
+	}
+
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		migrateUsage()
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert (down only)")
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.Postgres.URL == "" {
+		return errors.New("DATABASE_URL is not set")
+	}
+
+	pg, err := storage.NewPostgresClient(cfg.Postgres.URL)
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+
+	migrator, err := pg.Migrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("nothing to apply")
+		}
+		return err
+	case "down":
+		if *steps < 1 {
+			return errors.New("-steps must be at least 1")
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+		return err
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		if s.Modified {
+			state = "modified"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
+
+func migrateUsage() {
+	fmt.Fprintln(os.Stderr, "usage: server migrate <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  up       apply all pending migrations")
+	fmt.Fprintln(os.Stderr, "  down     revert the last -steps migrations (default 1)")
+	fmt.Fprintln(os.Stderr, "  status   list migrations and whether they are applied")
+}
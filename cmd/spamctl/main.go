@@ -0,0 +1,310 @@
+// Command spamctl runs offline checks against the spam filters.
+//
+// Usage:
+//
+//	spamctl corpus -file internal/spam/testdata/evasion_corpus.jsonl
+//	spamctl train -file labeled.jsonl [-model model.json]
+//	spamctl eval -file labeled.jsonl [-model model.json] [-split 0.2]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/spam"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "corpus":
+		err = runCorpus(os.Args[2:])
+	case "train":
+		err = runTrain(os.Args[2:])
+	case "eval":
+		err = runEval(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "spamctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: spamctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  corpus   check the profanity filter against a labeled evasion corpus")
+	fmt.Fprintln(os.Stderr, "  train    train the spam classifier from a labeled JSONL file")
+	fmt.Fprintln(os.Stderr, "  eval     measure the spam classifier against a labeled JSONL file")
+}
+
+type corpusEntry struct {
+	Text  string `json:"text"`
+	Label string `json:"label"`
+	Note  string `json:"note"`
+}
+
+// runCorpus checks every corpus entry labeled "profanity" is caught and every
+// entry labeled "clean" is not, using the configured word lists. It is for
+// trying custom lists; go test runs the corpus against the embedded ones.
+func runCorpus(args []string) error {
+	fs := flag.NewFlagSet("corpus", flag.ExitOnError)
+	file := fs.String("file", "internal/spam/testdata/evasion_corpus.jsonl", "JSONL corpus of {text, label, note}")
+	verbose := fs.Bool("v", false, "print every entry, not just failures")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	// Only the word lists are used, so no Redis connection is needed
+	detector, err := spam.NewDetector(nil, cfg.Spam, nil)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readCorpus(*file)
+	if err != nil {
+		return err
+	}
+
+	total, failed := 0, 0
+	for i, entry := range entries {
+		line := i + 1
+		want := entry.Label == "profanity"
+		got := detector.ContainsProfanity(entry.Text)
+		total++
+
+		status := "ok  "
+		if got != want {
+			status = "FAIL"
+			failed++
+		}
+		if got != want || *verbose {
+			fmt.Printf("%s %s:%d %q (%s): want %s, canonical %q\n",
+				status, *file, line, entry.Text, entry.Note, entry.Label, spam.Normalize(entry.Text).Canonical)
+		}
+	}
+
+	fmt.Printf("%d/%d corpus entries passed\n", total-failed, total)
+	if failed > 0 {
+		return fmt.Errorf("%d corpus entries failed", failed)
+	}
+	return nil
+}
+
+// readCorpus reads a JSONL file of corpus entries, skipping blank lines.
+func readCorpus(path string) ([]corpusEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []corpusEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry corpusEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+type labeledText struct {
+	label spam.Label
+	text  string
+}
+
+func readLabeled(path string) ([]labeledText, error) {
+	entries, err := readCorpus(path)
+	if err != nil {
+		return nil, err
+	}
+
+	examples := make([]labeledText, 0, len(entries))
+	for i, entry := range entries {
+		label, err := spam.ParseLabel(entry.Label)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+		}
+		examples = append(examples, labeledText{label: label, text: entry.Text})
+	}
+	return examples, nil
+}
+
+// openModelStore returns the file store at model if set, and the configured
+// classifier store otherwise.
+func openModelStore(cfg *config.Config, model string) (spam.ModelStore, error) {
+	if model != "" {
+		return spam.NewFileModelStore(model), nil
+	}
+
+	var redisClient storage.RedisClient
+	if cfg.Spam.ClassifierStore == "redis" {
+		client, err := storage.NewRedisClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		redisClient = client
+	}
+	return spam.NewModelStore(cfg.Spam.ClassifierStore, redisClient, cfg.Spam.ClassifierModelFile)
+}
+
+// runTrain adds every labeled entry to the classifier model.
+func runTrain(args []string) error {
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	file := fs.String("file", "", "JSONL file of {text, label} with label spam or ham")
+	model := fs.String("model", "", "model file to train (default: the configured classifier store)")
+	fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	examples, err := readLabeled(*file)
+	if err != nil {
+		return err
+	}
+
+	store, err := openModelStore(cfg, *model)
+	if err != nil {
+		return err
+	}
+
+	delta := spam.NewBayesModel()
+	for _, example := range examples {
+		delta.Add(example.label, spam.ClassifierTokens(example.text))
+	}
+
+	if err := store.Add(context.Background(), delta); err != nil {
+		return err
+	}
+
+	fmt.Printf("trained on %d spam and %d ham messages\n", delta.Docs[spam.LabelSpam], delta.Docs[spam.LabelHam])
+	return nil
+}
+
+// runEval scores every labeled entry and reports precision and recall. With
+// -split it trains a fresh model on part of the file and evaluates on the
+// rest instead of using the stored model.
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	file := fs.String("file", "", "JSONL file of {text, label} with label spam or ham")
+	model := fs.String("model", "", "model file to evaluate (default: the configured classifier store)")
+	split := fs.Float64("split", 0, "hold out this fraction of the file for evaluation and train on the rest")
+	threshold := fs.Float64("threshold", 0.5, "spam probability above which a message counts as spam")
+	seed := fs.Int64("seed", 1, "shuffle seed for -split")
+	verbose := fs.Bool("v", false, "print every misclassified entry")
+	fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *split < 0 || *split >= 1 {
+		return errors.New("-split must be in [0, 1)")
+	}
+
+	examples, err := readLabeled(*file)
+	if err != nil {
+		return err
+	}
+
+	var bayes *spam.BayesModel
+	test := examples
+	if *split > 0 {
+		rand.New(rand.NewSource(*seed)).Shuffle(len(examples), func(i, j int) {
+			examples[i], examples[j] = examples[j], examples[i]
+		})
+		n := int(float64(len(examples)) * *split)
+		if n == 0 {
+			n = 1
+		}
+		test = examples[:n]
+
+		bayes = spam.NewBayesModel()
+		for _, example := range examples[n:] {
+			bayes.Add(example.label, spam.ClassifierTokens(example.text))
+		}
+	} else {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		store, err := openModelStore(cfg, *model)
+		if err != nil {
+			return err
+		}
+		if bayes, err = store.Load(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	var tp, fp, tn, fn int
+	for _, example := range test {
+		probability := bayes.SpamProbability(spam.ClassifierTokens(example.text))
+		predicted := probability > *threshold
+		actual := example.label == spam.LabelSpam
+
+		switch {
+		case predicted && actual:
+			tp++
+		case predicted && !actual:
+			fp++
+		case !predicted && !actual:
+			tn++
+		default:
+			fn++
+		}
+		if predicted != actual && *verbose {
+			fmt.Printf("MISS %s p=%.2f %q\n", example.label, probability, example.text)
+		}
+	}
+
+	fmt.Printf("model: %d spam, %d ham; evaluated %d messages\n",
+		bayes.Docs[spam.LabelSpam], bayes.Docs[spam.LabelHam], len(test))
+	fmt.Printf("          predicted spam  predicted ham\n")
+	fmt.Printf("spam      %14d  %13d\n", tp, fn)
+	fmt.Printf("ham       %14d  %13d\n", fp, tn)
+	fmt.Printf("accuracy  %.3f\n", ratio(tp+tn, len(test)))
+	fmt.Printf("precision %.3f\n", ratio(tp, tp+fp))
+	fmt.Printf("recall    %.3f\n", ratio(tp, tp+fn))
+	return nil
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
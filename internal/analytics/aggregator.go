@@ -0,0 +1,139 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+)
+
+// Store persists daily stats. storage.PostgresClient implements it.
+type Store interface {
+	RecordDailyStats(ctx context.Context, date time.Time, sessions, messages, locations int, avgRadius float64) error
+	GetAnalytics(ctx context.Context, startDate, endDate time.Time) ([]storage.AnalyticsRecord, error)
+}
+
+// Aggregator flushes the Redis counters into the store and answers range
+// queries.
+type Aggregator struct {
+	service       AnalyticsService
+	store         Store
+	flushInterval time.Duration
+	retention     time.Duration
+}
+
+// NewAggregator creates an aggregator. store is optional and may be nil, in
+// which case nothing is flushed and only days still in Redis can be queried.
+func NewAggregator(service AnalyticsService, store Store, cfg config.AnalyticsConfig) *Aggregator {
+	return &Aggregator{
+		service:       service,
+		store:         store,
+		flushInterval: cfg.FlushInterval,
+		retention:     cfg.Retention,
+	}
+}
+
+// Start flushes today's and yesterday's counters at the flush interval, so
+// the end of each day is written once it is over, and once more on
+// shutdown. It returns immediately when there is no store.
+func (a *Aggregator) Start(ctx context.Context, log logger.Logger) {
+	if a.store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	log.Info("Analytics aggregator started", "interval", a.flushInterval)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil {
+				log.Error("Failed to flush analytics", "error", err)
+			}
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := a.Flush(flushCtx); err != nil {
+				log.Error("Failed to flush analytics", "error", err)
+			}
+			cancel()
+			log.Info("Analytics aggregator stopped")
+			return
+		}
+	}
+}
+
+// Flush writes today's and yesterday's counters to the store. Counters are
+// written as totals, so flushing again, or from several servers, is safe.
+func (a *Aggregator) Flush(ctx context.Context) error {
+	now := time.Now().UTC()
+	for _, date := range []time.Time{now.AddDate(0, 0, -1), now} {
+		stats, err := a.service.Daily(ctx, date)
+		if err != nil {
+			return err
+		}
+		if stats.Sessions == 0 && stats.Messages == 0 && stats.UniqueCells == 0 {
+			continue
+		}
+		if err := a.store.RecordDailyStats(ctx, midnight(date), stats.Sessions, stats.Messages, stats.UniqueCells, stats.AvgRadius); err != nil {
+			return fmt.Errorf("failed to record stats for %s: %w", stats.Date, err)
+		}
+	}
+	return nil
+}
+
+// Range returns the stats for each day from start to end, inclusive, newest
+// first. Days still kept in Redis come from the live counters, so today is
+// current; older days come from the store.
+func (a *Aggregator) Range(ctx context.Context, start, end time.Time) ([]DailyStats, error) {
+	start, end = midnight(start), midnight(end)
+	if end.Before(start) {
+		return nil, fmt.Errorf("end date is before start date")
+	}
+
+	byDate := make(map[string]DailyStats)
+	if a.store != nil {
+		records, err := a.store.GetAnalytics(ctx, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get analytics: %w", err)
+		}
+		for _, record := range records {
+			byDate[day(record.Date)] = DailyStats{
+				Date:        day(record.Date),
+				Sessions:    record.TotalSessions,
+				Messages:    record.TotalMessages,
+				UniqueCells: record.UniqueLocations,
+				AvgRadius:   record.AvgRadius,
+			}
+		}
+	}
+
+	oldestLive := midnight(time.Now().Add(-a.retention)).AddDate(0, 0, 1)
+	for date := end; !date.Before(start) && !date.Before(oldestLive); date = date.AddDate(0, 0, -1) {
+		stats, err := a.service.Daily(ctx, date)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Sessions > 0 || stats.Messages > 0 || stats.UniqueCells > 0 {
+			byDate[stats.Date] = *stats
+		}
+	}
+
+	var days []DailyStats
+	for date := end; !date.Before(start); date = date.AddDate(0, 0, -1) {
+		if stats, ok := byDate[day(date)]; ok {
+			days = append(days, stats)
+		}
+	}
+	return days, nil
+}
+
+// midnight returns the start of the UTC day containing t.
+func midnight(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,137 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+)
+
+// dateLayout formats the UTC day counters are kept under.
+const dateLayout = "2006-01-02"
+
+// Counter hash fields
+const (
+	fieldSessions    = "sessions"
+	fieldMessages    = "messages"
+	fieldRadiusSum   = "radius_sum"
+	fieldRadiusCount = "radius_count"
+)
+
+// DailyStats are the counters for one UTC day.
+type DailyStats struct {
+	Date        string  `json:"date"`
+	Sessions    int     `json:"sessions"`
+	Messages    int     `json:"messages"`
+	UniqueCells int     `json:"unique_cells"`
+	AvgRadius   float64 `json:"avg_radius_meters"`
+}
+
+type AnalyticsService interface {
+	// RecordSession counts a created session.
+	RecordSession(ctx context.Context) error
+
+	// RecordMessage counts a message delivered to an area.
+	RecordMessage(ctx context.Context) error
+
+	// RecordLocation counts a location update towards the day's distinct
+	// cells and average radius.
+	RecordLocation(ctx context.Context, lat, lon float64, radius int) error
+
+	// Daily returns the counters kept in Redis for the day containing date.
+	Daily(ctx context.Context, date time.Time) (*DailyStats, error)
+}
+
+type Service struct {
+	redis            storage.RedisClient
+	geohashPrecision int
+	retention        time.Duration
+}
+
+// NewService creates the analytics service. Distinct cells are counted at
+// geohashPrecision.
+func NewService(redisClient storage.RedisClient, geohashPrecision int, cfg config.AnalyticsConfig) *Service {
+	return &Service{
+		redis:            redisClient,
+		geohashPrecision: geohashPrecision,
+		retention:        cfg.Retention,
+	}
+}
+
+func (s *Service) RecordSession(ctx context.Context) error {
+	return s.increment(ctx, time.Now(), fieldSessions, 1)
+}
+
+func (s *Service) RecordMessage(ctx context.Context) error {
+	return s.increment(ctx, time.Now(), fieldMessages, 1)
+}
+
+func (s *Service) RecordLocation(ctx context.Context, lat, lon float64, radius int) error {
+	now := time.Now()
+	if err := s.increment(ctx, now, fieldRadiusSum, int64(radius)); err != nil {
+		return err
+	}
+	if err := s.increment(ctx, now, fieldRadiusCount, 1); err != nil {
+		return err
+	}
+
+	// Distinct cells are estimated with a HyperLogLog, which stays at 12KB
+	// however many cells are seen
+	key := s.cellsKey(now)
+	if err := s.redis.PFAdd(ctx, key, location.Encode(lat, lon, s.geohashPrecision)); err != nil {
+		return fmt.Errorf("failed to count cell: %w", err)
+	}
+	return s.redis.Expire(ctx, key, s.retention)
+}
+
+func (s *Service) Daily(ctx context.Context, date time.Time) (*DailyStats, error) {
+	counters, err := s.redis.HGetAll(ctx, s.countersKey(date))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get counters: %w", err)
+	}
+	cells, err := s.redis.PFCount(ctx, s.cellsKey(date))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count cells: %w", err)
+	}
+
+	stats := &DailyStats{
+		Date:        day(date),
+		Sessions:    atoi(counters[fieldSessions]),
+		Messages:    atoi(counters[fieldMessages]),
+		UniqueCells: int(cells),
+	}
+	if count := atoi(counters[fieldRadiusCount]); count > 0 {
+		stats.AvgRadius = float64(atoi(counters[fieldRadiusSum])) / float64(count)
+	}
+	return stats, nil
+}
+
+func (s *Service) increment(ctx context.Context, now time.Time, field string, by int64) error {
+	key := s.countersKey(now)
+	if _, err := s.redis.HIncrBy(ctx, key, field, by); err != nil {
+		return fmt.Errorf("failed to count %s: %w", field, err)
+	}
+	return s.redis.Expire(ctx, key, s.retention)
+}
+
+func (s *Service) countersKey(date time.Time) string {
+	return s.redis.Keys().Key("analytics", day(date))
+}
+
+func (s *Service) cellsKey(date time.Time) string {
+	return s.redis.Keys().Key("analytics", day(date), "cells")
+}
+
+// day returns the UTC day containing t.
+func day(t time.Time) string {
+	return t.UTC().Format(dateLayout)
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,196 @@
+package analytics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+var testConfig = config.AnalyticsConfig{FlushInterval: time.Minute, Retention: 3 * 24 * time.Hour}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	return NewService(storagetest.NewClient(t), 6, testConfig)
+}
+
+func TestServiceCounters(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+
+	for i := 0; i < 2; i++ {
+		if err := service.RecordSession(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := service.RecordMessage(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first two are in the same cell, so two distinct cells
+	for _, update := range []struct {
+		lat, lon float64
+		radius   int
+	}{
+		{37.7749, -122.4194, 100},
+		{37.7749, -122.4194, 200},
+		{51.5074, -0.1278, 600},
+	} {
+		if err := service.RecordLocation(ctx, update.lat, update.lon, update.radius); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	stats, err := service.Daily(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DailyStats{Date: day(now), Sessions: 2, Messages: 3, UniqueCells: 2, AvgRadius: 300}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Daily = %+v, want %+v", stats, want)
+	}
+
+	// Each day has its own counters
+	yesterday := now.AddDate(0, 0, -1)
+	stats, err = service.Daily(ctx, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&DailyStats{Date: day(yesterday)}); !reflect.DeepEqual(stats, want) {
+		t.Errorf("Daily for yesterday = %+v, want %+v", stats, want)
+	}
+}
+
+// fakeStore keeps daily stats by day, as the analytics table does.
+type fakeStore struct {
+	records map[string]storage.AnalyticsRecord
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{records: make(map[string]storage.AnalyticsRecord)}
+}
+
+func (f *fakeStore) RecordDailyStats(ctx context.Context, date time.Time, sessions, messages, locations int, avgRadius float64) error {
+	f.records[day(date)] = storage.AnalyticsRecord{
+		Date:            date,
+		TotalSessions:   sessions,
+		TotalMessages:   messages,
+		UniqueLocations: locations,
+		AvgRadius:       avgRadius,
+	}
+	return nil
+}
+
+func (f *fakeStore) GetAnalytics(ctx context.Context, startDate, endDate time.Time) ([]storage.AnalyticsRecord, error) {
+	var records []storage.AnalyticsRecord
+	for _, record := range f.records {
+		if !record.Date.Before(startDate) && !record.Date.After(endDate) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func dates(days []DailyStats) []string {
+	var got []string
+	for _, stats := range days {
+		got = append(got, stats.Date)
+	}
+	return got
+}
+
+func TestAggregatorRange(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+
+	// With a three day retention, today and the two days before are live
+	today := midnight(time.Now())
+	daysAgo := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+
+	if err := service.increment(ctx, today, fieldMessages, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.increment(ctx, daysAgo(1), fieldSessions, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore()
+	// Stale: yesterday's live counters win
+	store.RecordDailyStats(ctx, daysAgo(1), 5, 0, 0, 0)
+	// No live counters left, so the stored row is kept
+	store.RecordDailyStats(ctx, daysAgo(2), 3, 9, 1, 50)
+	// Older than the retention, only in the store
+	store.RecordDailyStats(ctx, daysAgo(5), 1, 2, 1, 100)
+	// Outside the range asked for
+	store.RecordDailyStats(ctx, daysAgo(9), 1, 1, 1, 1)
+
+	t.Run("store", func(t *testing.T) {
+		aggregator := NewAggregator(service, store, testConfig)
+		days, err := aggregator.Range(ctx, daysAgo(6), today)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []DailyStats{
+			{Date: day(today), Messages: 4},
+			{Date: day(daysAgo(1)), Sessions: 7},
+			{Date: day(daysAgo(2)), Sessions: 3, Messages: 9, UniqueCells: 1, AvgRadius: 50},
+			{Date: day(daysAgo(5)), Sessions: 1, Messages: 2, UniqueCells: 1, AvgRadius: 100},
+		}
+		if !reflect.DeepEqual(days, want) {
+			t.Errorf("Range = %+v, want %+v", days, want)
+		}
+	})
+
+	t.Run("no store", func(t *testing.T) {
+		aggregator := NewAggregator(service, nil, testConfig)
+		days, err := aggregator.Range(ctx, daysAgo(6), today)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{day(today), day(daysAgo(1))}; !reflect.DeepEqual(dates(days), want) {
+			t.Errorf("Range without a store = %v, want only the live days %v", dates(days), want)
+		}
+	})
+
+	t.Run("reversed", func(t *testing.T) {
+		aggregator := NewAggregator(service, nil, testConfig)
+		if _, err := aggregator.Range(ctx, today, daysAgo(1)); err == nil {
+			t.Error("Range accepted an end date before the start date")
+		}
+	})
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+	store := newFakeStore()
+	aggregator := NewAggregator(service, store, testConfig)
+
+	service.RecordSession(ctx)
+	service.RecordMessage(ctx)
+	if err := aggregator.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Flushing again writes the same totals
+	service.RecordMessage(ctx)
+	if err := aggregator.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	today := midnight(time.Now())
+	want := map[string]storage.AnalyticsRecord{
+		day(today): {Date: today, TotalSessions: 1, TotalMessages: 2},
+	}
+	// Yesterday had no counters, so it is not written
+	if !reflect.DeepEqual(store.records, want) {
+		t.Errorf("stored %+v, want %+v", store.records, want)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/analytics"
+	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/spam"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler serves moderation endpoints under /api/admin.
+type AdminHandler struct {
+	token          string
+	sessionService session.SessionService
+	bans           spam.BanService
+	classifier     *spam.Classifier
+	analytics      *analytics.Aggregator
+}
+
+// NewAdminHandler creates the admin handler. classifier may be nil when the
+// spam classifier is disabled.
+func NewAdminHandler(token string, sessionService session.SessionService, bans spam.BanService, classifier *spam.Classifier, analytics *analytics.Aggregator) *AdminHandler {
+	return &AdminHandler{
+		token:          token,
+		sessionService: sessionService,
+		bans:           bans,
+		classifier:     classifier,
+		analytics:      analytics,
+	}
+}
+
+// POST /api/admin/shadowban
+func (h *AdminHandler) SetShadowBan(c *gin.Context) {
+	var req struct {
+		SessionID       string `json:"session_id" binding:"required"`
+		Enabled         *bool  `json:"enabled" binding:"required"`
+		DurationMinutes int    `json:"duration_minutes"`
+		Reason          string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("Invalid request", "INVALID_REQUEST"))
+		return
+	}
+
+	// Shadow-ban the session's IP too while the session is still around.
+	// Lifting also clears the IP the ban was stored with, even once the
+	// session has expired.
+	ip := ""
+	if sess, err := h.sessionService.Get(c, req.SessionID); err == nil {
+		ip = sess.IPAddress
+	}
+
+	if !*req.Enabled {
+		if err := h.bans.LiftShadowBan(c, req.SessionID, ip); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to lift shadow ban", "INTERNAL_ERROR"))
+			return
+		}
+		c.JSON(http.StatusOK, SuccessResponse(gin.H{
+			"session_id":    req.SessionID,
+			"shadow_banned": false,
+		}))
+		return
+	}
+
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	if duration <= 0 {
+		duration = 24 * time.Hour
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "moderator"
+	}
+
+	if err := h.bans.ShadowBan(c, req.SessionID, ip, reason, duration); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to shadow-ban session", "INTERNAL_ERROR"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse(gin.H{
+		"session_id":    req.SessionID,
+		"shadow_banned": true,
+		"expires_at":    time.Now().Add(duration),
+	}))
+}
+
+// POST /api/admin/moderation/label
+func (h *AdminHandler) LabelMessage(c *gin.Context) {
+	if h.classifier == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse("Spam classifier is disabled", "CLASSIFIER_DISABLED"))
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+		Label   string `json:"label" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("Invalid request", "INVALID_REQUEST"))
+		return
+	}
+
+	label, err := spam.ParseLabel(req.Label)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(err.Error(), "INVALID_LABEL"))
+		return
+	}
+
+	if err := h.classifier.Train(c, label, req.Content); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to train classifier", "INTERNAL_ERROR"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse(gin.H{
+		"label":    label,
+		"examples": h.classifier.Stats(),
+	}))
+}
+
+// GET /api/admin/analytics?from=2006-01-02&to=2006-01-02
+//
+// Both dates are UTC days and optional: the range defaults to the last 30
+// days up to today.
+func (h *AdminHandler) GetAnalytics(c *gin.Context) {
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse("to must be a date like 2006-01-02", "INVALID_DATE"))
+			return
+		}
+		to = date
+	}
+
+	from := to.AddDate(0, 0, -29)
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse("from must be a date like 2006-01-02", "INVALID_DATE"))
+			return
+		}
+		from = date
+	}
+
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		c.JSON(http.StatusBadRequest, ErrorResponse("Date range must run forwards and span at most a year", "INVALID_DATE"))
+		return
+	}
+
+	days, err := h.analytics.Range(c, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to get analytics", "INTERNAL_ERROR"))
+		return
+	}
+
+	var sessions, messages int
+	for _, stats := range days {
+		sessions += stats.Sessions
+		messages += stats.Messages
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse(gin.H{
+		"from":           from.Format("2006-01-02"),
+		"to":             to.Format("2006-01-02"),
+		"days":           days,
+		"total_sessions": sessions,
+		"total_messages": messages,
+	}))
+}
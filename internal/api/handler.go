@@ -1,13 +1,19 @@
 package api
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 
+	"github.com/askwhyharsh/neartalk/internal/analytics"
+	"github.com/askwhyharsh/neartalk/internal/clientip"
 	"github.com/askwhyharsh/neartalk/internal/location"
 	"github.com/askwhyharsh/neartalk/internal/ratelimit"
+	"github.com/askwhyharsh/neartalk/internal/reputation"
 	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/spam"
 	"github.com/askwhyharsh/neartalk/internal/websocket"
+	apperrors "github.com/askwhyharsh/neartalk/pkg/errors"
 	"github.com/askwhyharsh/neartalk/pkg/validator"
 	"github.com/gin-gonic/gin"
 )
@@ -16,7 +22,10 @@ type Handler struct {
 	sessionService  session.SessionService
 	locationService location.LocationService
 	rateLimiter     ratelimit.RateLimiter
+	bans            spam.BanService
+	reputation      reputation.ReputationService
 	validator       validator.Validator
+	analytics       analytics.AnalyticsService
 	wsHandler 		*websocket.Handler
 }
 
@@ -33,23 +42,30 @@ type NearbyUser struct {
 	Distance string `json:"distance"`
 }
 
-func NewHandler(sessionService session.SessionService, locationService location.LocationService, rateLimiter ratelimit.RateLimiter, validator validator.Validator, wsHandler *websocket.Handler) *Handler {
+func NewHandler(sessionService session.SessionService, locationService location.LocationService, rateLimiter ratelimit.RateLimiter, bans spam.BanService, reputation reputation.ReputationService, validator validator.Validator, analytics analytics.AnalyticsService, wsHandler *websocket.Handler) *Handler {
 	return &Handler{
 		sessionService:  sessionService,
 		locationService: locationService,
 		rateLimiter:     rateLimiter,
+		bans:            bans,
+		reputation:      reputation,
 		validator:       validator,
+		analytics:       analytics,
 		wsHandler: 		 wsHandler,
 	}
 }
 
 // POST /api/session/create
 func (h *Handler) CreateSession(c *gin.Context) {
-	ip := c.ClientIP()
-	fmt.Println("client ip",ip)
+	ip := clientip.FromContext(c)
+
+	// Refuse banned IPs
+	if h.rejectBanned(c, "", ip) {
+		return
+	}
+
 	// Check rate limit
-	allowed, err := h.rateLimiter.AllowSessionCreation(c, ip)
-	if err != nil || !allowed {
+	if !h.allow(c, ratelimit.ActionSession, ip) {
 		c.JSON(http.StatusTooManyRequests, ErrorResponse("Rate limit exceeded", "RATE_LIMIT"))
 		return
 	}
@@ -60,6 +76,9 @@ func (h *Handler) CreateSession(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to create session", "INTERNAL_ERROR"))
 		return
 	}
+	if err := h.analytics.RecordSession(c); err != nil {
+		log.Printf("Failed to record session analytics: %v", err)
+	}
 
 	c.JSON(http.StatusCreated, SuccessResponse(session))
 }
@@ -83,8 +102,7 @@ func (h *Handler) UpdateUsername(c *gin.Context) {
 	}
 
 	// Check rate limit
-	allowed, _, err := h.rateLimiter.AllowUsernameChange(c, req.SessionID)
-	if err != nil || !allowed {
+	if !h.allow(c, ratelimit.ActionUsername, req.SessionID) {
 		c.JSON(http.StatusTooManyRequests, ErrorResponse("Username change limit reached", "RATE_LIMIT"))
 		return
 	}
@@ -130,9 +148,13 @@ func (h *Handler) UpdateLocation(c *gin.Context) {
 		return
 	}
 
+	// Refuse banned sessions and IPs
+	if h.rejectBanned(c, req.SessionID, clientip.FromContext(c)) {
+		return
+	}
+
 	// Check rate limit
-	allowed, err := h.rateLimiter.AllowLocationUpdate(c, req.SessionID)
-	if err != nil || !allowed {
+	if !h.allow(c, ratelimit.ActionLocation, req.SessionID) {
 		c.JSON(http.StatusTooManyRequests, ErrorResponse("Location update rate limit exceeded", "RATE_LIMIT"))
 		return
 	}
@@ -142,6 +164,9 @@ func (h *Handler) UpdateLocation(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to update location", "INTERNAL_ERROR"))
 		return
 	}
+	if err := h.analytics.RecordLocation(c, req.Latitude, req.Longitude, req.Radius); err != nil {
+		log.Printf("Failed to record location analytics: %v", err)
+	}
 
 	c.JSON(http.StatusOK, SuccessResponse(gin.H{
 		"message": "Location updated successfully",
@@ -158,12 +183,16 @@ func (h *Handler) GetNearbyUsers(c *gin.Context) {
 	}
 
 	// Get nearby users
-	users, err := h.locationService.GetNearbyUsers(ctx, sessionID, func(sid string) string {
-		session, err := h.sessionService.Get(ctx, sid)
-		if err != nil {
-			return "Unknown"
+	users, err := h.locationService.GetNearbyUsers(ctx, sessionID, func(sids []string) map[string]string {
+		usernames := make(map[string]string, len(sids))
+		sessions, err := h.sessionService.GetMany(ctx, sids)
+		for _, sid := range sids {
+			usernames[sid] = "Unknown"
+			if session, ok := sessions[sid]; ok && err == nil {
+				usernames[sid] = session.Username
+			}
 		}
-		return session.Username
+		return usernames
 	})
 
 	if err != nil {
@@ -195,7 +224,7 @@ func (h *Handler) GetRecentMessages(c *gin.Context) {
 	}
 
 	// get recent messages from geo hash
-	messages, err := h.wsHandler.GetRecentMessages(ctx, geohash, 400)
+	messages, err := h.wsHandler.GetRecentMessages(ctx, sessionID, geohash, 400)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to get nearby users", "INTERNAL_ERROR"))
@@ -208,6 +237,83 @@ func (h *Handler) GetRecentMessages(c *gin.Context) {
 	}))
 }
 
+// POST /api/report
+func (h *Handler) ReportUser(c *gin.Context) {
+	var req struct {
+		SessionID  string `json:"session_id" binding:"required"`
+		ReportedID string `json:"reported_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse("Invalid request", "INVALID_REQUEST"))
+		return
+	}
+
+	if req.SessionID == req.ReportedID {
+		c.JSON(http.StatusBadRequest, ErrorResponse("Cannot report yourself", "INVALID_REQUEST"))
+		return
+	}
+
+	// Reports are limited per IP, so sessions minted for the purpose do not
+	// add up
+	ip := clientip.FromContext(c)
+	if !h.allow(c, ratelimit.ActionReport, ip) {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse("Too many reports", "RATE_LIMIT"))
+		return
+	}
+
+	// Only live sessions may report, so reports cannot be forged freely
+	exists, err := h.sessionService.Exists(c, req.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to report user", "INTERNAL_ERROR"))
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, ErrorResponse("Session not found", "SESSION_NOT_FOUND"))
+		return
+	}
+
+	counted, err := h.reputation.RecordReport(c, ip, req.ReportedID)
+	if errors.Is(err, apperrors.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse("Reported session not found", "SESSION_NOT_FOUND"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to report user", "INTERNAL_ERROR"))
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse(gin.H{
+		"reported": counted,
+	}))
+}
+
+// rejectBanned writes a 403 and returns true if the session or IP is banned.
+func (h *Handler) rejectBanned(c *gin.Context, sessionID, ip string) bool {
+	banned, reason, err := h.bans.IsBanned(c, sessionID, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse("Failed to check ban status", "INTERNAL_ERROR"))
+		return true
+	}
+	if banned {
+		c.JSON(http.StatusForbidden, ErrorResponse("Banned: "+reason, "BANNED"))
+		return true
+	}
+	return false
+}
+
+// allow checks a rate limit, describing it in the response headers unless
+// the IP limit already described there is more restrictive. Errors count as
+// limited, so the caller responds with a 429.
+func (h *Handler) allow(c *gin.Context, action ratelimit.Action, id string) bool {
+	result, err := h.rateLimiter.Allow(c, action, id)
+	if err != nil {
+		return false
+	}
+	ratelimit.SetHeaders(c.Writer.Header(), result)
+	return result.Allowed
+}
+
 // GET /api/health
 func (h *Handler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
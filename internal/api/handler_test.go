@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/analytics"
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/ratelimit"
+	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/spam"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/askwhyharsh/neartalk/pkg/validator"
+	"github.com/gin-gonic/gin"
+)
+
+// handlerTest serves the session and location routes with everything kept
+// in memory.
+type handlerTest struct {
+	sessions *session.Service
+	bans     *spam.BanManager
+	router   *gin.Engine
+}
+
+func newHandlerTest(t *testing.T) *handlerTest {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	limiter, err := ratelimit.NewLimiter(redisClient, *ratelimit.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ht := &handlerTest{
+		sessions: session.NewService(redisClient, time.Hour, 3),
+		bans:     spam.NewBanManager(redisClient, nil, time.Minute, time.Hour, 0),
+	}
+	handler := NewHandler(
+		ht.sessions,
+		location.NewService(redisClient, 6, 100, 2000),
+		limiter,
+		ht.bans,
+		nil,
+		validator.NewValidator(),
+		analytics.NewService(redisClient, 6, config.AnalyticsConfig{Retention: time.Hour}),
+		nil,
+	)
+
+	gin.SetMode(gin.TestMode)
+	ht.router = gin.New()
+	ht.router.POST("/api/session/create", handler.CreateSession)
+	ht.router.POST("/api/location/update", handler.UpdateLocation)
+	return ht
+}
+
+// do sends a request from ip and returns the status and error code.
+func (ht *handlerTest) do(t *testing.T, method, path, ip, body string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":40000"
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ht.router.ServeHTTP(w, req)
+
+	var resp Response
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error == nil {
+		return w.Code, ""
+	}
+	return w.Code, resp.Error.Code
+}
+
+func TestCreateSessionRefusesBannedIP(t *testing.T) {
+	ht := newHandlerTest(t)
+	if _, err := ht.bans.Ban(context.Background(), "earlier", "192.0.2.1", "spamming"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, code := ht.do(t, http.MethodPost, "/api/session/create", "192.0.2.1", ""); status != http.StatusForbidden || code != "BANNED" {
+		t.Errorf("banned IP got %d %q, want 403 BANNED", status, code)
+	}
+	if status, code := ht.do(t, http.MethodPost, "/api/session/create", "192.0.2.2", ""); status != http.StatusCreated {
+		t.Errorf("other IP got %d %q, want 201", status, code)
+	}
+}
+
+func TestUpdateLocationRefusesBanned(t *testing.T) {
+	ctx := context.Background()
+	ht := newHandlerTest(t)
+
+	newSession := func() string {
+		s, err := ht.sessions.Create(ctx, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ID
+	}
+	update := func(sessionID, ip string) (int, string) {
+		body := `{"session_id": "` + sessionID + `", "latitude": 37.7749, "longitude": -122.4194, "radius": 500}`
+		return ht.do(t, http.MethodPost, "/api/location/update", ip, body)
+	}
+
+	banned, clean := newSession(), newSession()
+	if _, err := ht.bans.Ban(ctx, banned, "", "spamming"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ht.bans.Ban(ctx, "earlier", "192.0.2.9", "spamming"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		ip        string
+		want      int
+		wantCode  string
+	}{
+		{"banned session", banned, "192.0.2.1", http.StatusForbidden, "BANNED"},
+		{"banned IP", clean, "192.0.2.9", http.StatusForbidden, "BANNED"},
+		{"neither", clean, "192.0.2.1", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		if status, code := update(tt.sessionID, tt.ip); status != tt.want || code != tt.wantCode {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, code, tt.want, tt.wantCode)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/subtle"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -39,4 +41,23 @@ func RecoveryMiddleware() gin.HandlerFunc {
 		}()
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// AdminAuthMiddleware requires the X-Admin-Token header to match token. The
+// admin API is disabled entirely when no token is configured.
+func AdminAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse("Admin API disabled", "ADMIN_DISABLED"))
+			return
+		}
+
+		provided := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse("Invalid admin token", "UNAUTHORIZED"))
+			return
+		}
+
+		c.Next()
+	}
+}
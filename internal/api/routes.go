@@ -6,7 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, handler *Handler, wsHandler WebSocketHandler, rlMiddleware *ratelimit.Middleware) {
+func SetupRoutes(r *gin.Engine, handler *Handler, adminHandler *AdminHandler, wsHandler WebSocketHandler, rlMiddleware *ratelimit.Middleware) {
 	// Apply global middleware
 	r.Use(CORSMiddleware())
 	r.Use(RequestTimeMiddleware())
@@ -32,11 +32,22 @@ func SetupRoutes(r *gin.Engine, handler *Handler, wsHandler WebSocketHandler, rl
 		// Nearby users
 		api.GET("/nearby", rlMiddleware.SessionRateLimit(), handler.GetNearbyUsers)
 
+		// Reports against other users
+		api.POST("/report", rlMiddleware.SessionRateLimit(), handler.ReportUser)
+
 		// Nearby users
 		api.GET("/recent-messages", rlMiddleware.SessionRateLimit(), handler.GetRecentMessages)
 
 		// Health check (no rate limit)
 		api.GET("/health", handler.Health)
+
+		// Admin routes
+		admin := api.Group("/admin", AdminAuthMiddleware(adminHandler.token))
+		{
+			admin.POST("/shadowban", adminHandler.SetShadowBan)
+			admin.POST("/moderation/label", adminHandler.LabelMessage)
+			admin.GET("/analytics", adminHandler.GetAnalytics)
+		}
 	}
 
 	// WebSocket route
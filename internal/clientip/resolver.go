@@ -0,0 +1,223 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers a resolver can read the client address from.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// contextKey is where Middleware stores the resolved address.
+const contextKey = "client_ip"
+
+// Resolver finds the client address of a request. Forwarding headers are only
+// believed when the request comes from a trusted proxy, and only as far back
+// as the chain of trusted proxies goes, so clients cannot spoof them.
+type Resolver struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// NewResolver creates a resolver trusting proxies in the given CIDRs (bare
+// addresses trust just that address) and reading the given headers in order.
+// With no headers, X-Forwarded-For is read. Only configure the headers your
+// proxies set: a header they pass through untouched is client-controlled.
+func NewResolver(trustedProxies, headers []string) (*Resolver, error) {
+	r := &Resolver{}
+
+	for _, cidr := range trustedProxies {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+
+	if len(headers) == 0 {
+		headers = []string{HeaderXForwardedFor}
+	}
+	for _, header := range headers {
+		switch http.CanonicalHeaderKey(header) {
+		case HeaderForwarded:
+			r.headers = append(r.headers, HeaderForwarded)
+		case http.CanonicalHeaderKey(HeaderXForwardedFor):
+			r.headers = append(r.headers, HeaderXForwardedFor)
+		case http.CanonicalHeaderKey(HeaderXRealIP):
+			r.headers = append(r.headers, HeaderXRealIP)
+		default:
+			return nil, fmt.Errorf("unsupported client IP header %q (want Forwarded, X-Forwarded-For or X-Real-IP)", header)
+		}
+	}
+
+	return r, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Resolve returns the client address of a request. Starting from the peer
+// address, it walks the first configured header that is present from right
+// to left for as long as each hop is a trusted proxy; the first untrusted
+// hop is the client. Unparseable hops end the walk at the last good one.
+func (r *Resolver) Resolve(req *http.Request) string {
+	client, ok := parseHost(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(client) {
+		return client.String()
+	}
+
+	for _, header := range r.headers {
+		hops := r.hops(req.Header, header)
+		if len(hops) == 0 {
+			continue
+		}
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHost(hops[i])
+			if !ok {
+				break
+			}
+			client = addr
+			if !r.isTrusted(addr) {
+				break
+			}
+		}
+		break
+	}
+
+	return client.String()
+}
+
+// hops returns the addresses listed in a header, client first.
+func (r *Resolver) hops(header http.Header, name string) []string {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return nil
+	}
+
+	switch name {
+	case HeaderXRealIP:
+		return []string{strings.TrimSpace(values[len(values)-1])}
+	case HeaderForwarded:
+		return forwardedFor(values)
+	}
+
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the "for" parameter of every element of RFC 7239
+// Forwarded headers, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"`.
+// Elements without one yield an empty hop, which ends the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHost parses an address with an optional port, as in RemoteAddr or
+// Forwarded ("[2001:db8::1]:4711"). IPv4-mapped IPv6 addresses are unmapped.
+func parseHost(s string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware resolves each request's client address for FromContext.
+func (r *Resolver) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, r.Resolve(c.Request))
+		c.Next()
+	}
+}
+
+// FromContext returns the client address resolved by Middleware, falling
+// back to Gin's own resolution if it did not run.
+func FromContext(c *gin.Context) string {
+	if ip := c.GetString(contextKey); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+// Aggregate returns the address rate limits should count an IP against:
+// IPv4 addresses as they are, IPv6 addresses as their prefix of the given
+// length, since one subscriber usually gets a whole /64. Unparseable input is
+// returned unchanged.
+func Aggregate(ip string, ipv6PrefixBits int) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() || ipv6PrefixBits >= 128 {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(ipv6PrefixBits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
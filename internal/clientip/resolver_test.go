@@ -0,0 +1,166 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var trustedProxies = []string{"10.0.0.0/8", "fd00::/8"}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    []string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		// X-Forwarded-For
+		{"no header", nil, "10.0.0.1:80", nil, "10.0.0.1"},
+		{"one proxy", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"proxy chain", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.3, 10.0.0.2"}}, "198.51.100.1"},
+		{"spoofed leftmost hop", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"repeated header", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.9", "198.51.100.1"}}, "198.51.100.1"},
+		{"only trusted hops", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"IPv6 hops", nil, "[fd00::1]:80",
+			http.Header{"X-Forwarded-For": {"2001:db8::17, fd00::2"}}, "2001:db8::17"},
+		{"IPv4-mapped peer", nil, "[::ffff:10.0.0.1]:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+
+		// Untrusted peers
+		{"untrusted peer", nil, "203.0.113.5:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.5"},
+		{"untrusted peer spoofing a proxy", nil, "203.0.113.5:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2"}}, "203.0.113.5"},
+		{"untrusted peer with Forwarded", []string{HeaderForwarded}, "203.0.113.5:1234",
+			http.Header{"Forwarded": {"for=198.51.100.1"}}, "203.0.113.5"},
+		{"unconfigured header", nil, "10.0.0.1:80",
+			http.Header{"X-Real-Ip": {"198.51.100.1"}}, "10.0.0.1"},
+
+		// Malformed entries end the walk at the last good hop
+		{"garbage hop", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"empty hop", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1,,10.0.0.2"}}, "10.0.0.2"},
+		{"garbage only", nil, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"garbage"}}, "10.0.0.1"},
+		{"unparseable peer", nil, "pipe", nil, "pipe"},
+
+		// Forwarded
+		{"Forwarded", []string{HeaderForwarded}, "10.0.0.1:80",
+			http.Header{"Forwarded": {`for=198.51.100.1;proto=http, for="[fd00::2]:4711"`}}, "198.51.100.1"},
+		{"Forwarded IPv6 with port", []string{HeaderForwarded}, "10.0.0.1:80",
+			http.Header{"Forwarded": {`for="[2001:db8::17]:4711", for=10.0.0.2`}}, "2001:db8::17"},
+		{"Forwarded spoofed leftmost hop", []string{HeaderForwarded}, "10.0.0.1:80",
+			http.Header{"Forwarded": {"for=203.0.113.9", "For=198.51.100.1;by=10.0.0.1"}}, "198.51.100.1"},
+		{"Forwarded element without for", []string{HeaderForwarded}, "10.0.0.1:80",
+			http.Header{"Forwarded": {"for=198.51.100.1, by=10.0.0.2"}}, "10.0.0.1"},
+		{"Forwarded obfuscated", []string{HeaderForwarded}, "10.0.0.1:80",
+			http.Header{"Forwarded": {"for=unknown"}}, "10.0.0.1"},
+
+		// X-Real-IP and header order
+		{"X-Real-IP", []string{HeaderXRealIP}, "10.0.0.1:80",
+			http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"first header present", []string{HeaderForwarded, HeaderXForwardedFor}, "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"first header wins", []string{HeaderForwarded, HeaderXForwardedFor}, "10.0.0.1:80",
+			http.Header{"Forwarded": {"for=198.51.100.2"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(trustedProxies, tt.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+
+			if got := r.Resolve(req); got != tt.want {
+				t.Errorf("Resolve = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		headers []string
+		wantErr bool
+	}{
+		{"defaults", nil, nil, false},
+		{"bare address", []string{"10.0.0.1", "fd00::1"}, nil, false},
+		{"lowercase headers", trustedProxies, []string{"forwarded", "x-forwarded-for", "x-real-ip"}, false},
+		{"invalid CIDR", []string{"10.0.0.0/33"}, nil, true},
+		{"invalid address", []string{"proxy.internal"}, nil, true},
+		{"unsupported header", trustedProxies, []string{"X-Client-IP"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewResolver(tt.trusted, tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestBareAddressTrusted checks a bare trusted address trusts only itself.
+func TestBareAddressTrusted(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for remoteAddr, want := range map[string]string{
+		"10.0.0.1:80": "198.51.100.1",
+		"10.0.0.2:80": "10.0.0.2",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		if got := r.Resolve(req); got != want {
+			t.Errorf("from %s: Resolve = %s, want %s", remoteAddr, got, want)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		ip   string
+		bits int
+		want string
+	}{
+		{"192.0.2.1", 64, "192.0.2.1"},
+		{"::ffff:192.0.2.1", 64, "192.0.2.1"},
+		{"2001:db8::1", 64, "2001:db8::/64"},
+		{"2001:db8::ffff:1", 64, "2001:db8::/64"},
+		{"2001:db8:0:1::1", 64, "2001:db8:0:1::/64"},
+		{"2001:db8:1:2:3::1", 56, "2001:db8:1::/56"},
+		{"2001:db8:1:2:3::1", 48, "2001:db8:1::/48"},
+		{"2001:db8::1", 128, "2001:db8::1"},
+		{"2001:db8::1", -1, "2001:db8::1"},
+		{"garbage", 64, "garbage"},
+		{"", 64, ""},
+	}
+
+	for _, tt := range tests {
+		if got := Aggregate(tt.ip, tt.bits); got != tt.want {
+			t.Errorf("Aggregate(%q, %d) = %q, want %q", tt.ip, tt.bits, got, tt.want)
+		}
+	}
+}
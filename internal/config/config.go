@@ -3,41 +3,113 @@ package config
 import (
 	"fmt"
 	"os"
-	"strconv"
 	"time"
 )
 
 type Config struct {
-	Env          string
-	Server      ServerConfig
-	Redis       RedisConfig
-	RateLimit   RateLimitConfig
-	Session     SessionConfig
-	Spam        SpamConfig
-	Location    LocationConfig
-	Monitoring  MonitoringConfig
+	Server     ServerConfig
+	Redis      RedisConfig
+	Postgres   PostgresConfig
+	RateLimit  RateLimitConfig
+	Session    SessionConfig
+	Spam       SpamConfig
+	Location   LocationConfig
+	Monitoring MonitoringConfig
+	Admin      AdminConfig
+	Reputation ReputationConfig
+	Analytics  AnalyticsConfig
 }
 
 type ServerConfig struct {
 	Port string
 	Host string
 	Env  string
+	// TrustedProxies are the CIDRs of proxies whose forwarding headers are
+	// believed
+	TrustedProxies []string
+	// ClientIPHeaders are the forwarding headers read, in order
+	ClientIPHeaders []string
 }
 
 type RedisConfig struct {
-	Host     string
-	Port     string
-	Password string
-	DB       int
+	// Storage is "redis" or "memory", which keeps everything in process
+	// for a single node without Redis
+	Storage string
+	// Mode is "standalone", "sentinel" or "cluster"
+	Mode string
+	Host string
+	Port string
+	// Addrs are the Sentinel addresses in sentinel mode and the seed nodes
+	// in cluster mode; Host and Port are used when empty
+	Addrs []string
+	// MasterName is the Sentinel master set
+	MasterName string
+	// Username selects an ACL user; Password alone uses the default user
+	Username         string
+	Password         string
+	SentinelUsername string
+	SentinelPassword string
+	DB               int
+	TLS              RedisTLSConfig
+	// KeyPrefix and TenantID namespace every key and channel, as
+	// "<prefix>:<tenant>:session:...", so deployments can share a Redis
+	KeyPrefix string
+	TenantID  string
+}
+
+type RedisTLSConfig struct {
+	Enabled bool
+	// CAFile verifies the server with these CAs instead of the system's
+	CAFile string
+	// CertFile and KeyFile are a client certificate, for mutual TLS
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// PostgresConfig configures the optional Postgres store for bans and
+// analytics.
+type PostgresConfig struct {
+	Enabled bool
+	URL     string
+	// AutoMigrate applies pending migrations at startup; otherwise the
+	// server refuses to start until "migrate up" has been run
+	AutoMigrate bool
 }
 
 type RateLimitConfig struct {
-	MessagesPerMin         int
-	LocationUpdatesPerMin  int
-	MaxUsernameChanges     int
-	SessionsPerIPPerHour   int
-	RequestsPerMinute      int
-	ConcurrentConnections  int
+	MessagesPerMin        int
+	LocationUpdatesPerMin int
+	MaxUsernameChanges    int
+	SessionsPerIPPerHour  int
+	ReportsPerIPPerHour   int
+	RequestsPerMinute     int
+	ConcurrentConnections int
+	// Algorithms picks the algorithm per action ("message", "location" or
+	// "request"): "sliding_window" (the default) or "token_bucket"
+	Algorithms map[string]string
+	// Token bucket sizes; buckets refill at the per-minute limits above
+	MessageBurst  int
+	LocationBurst int
+	RequestBurst  int
+	// FailureModes picks what each action does while Redis is unavailable:
+	// "local" (the default), "open" or "closed"
+	FailureModes map[string]string
+	// HealthCheckInterval is how often Redis is checked while unavailable
+	HealthCheckInterval time.Duration
+	// AreaMessagesPerMin caps messages reaching any one geohash cell; 0
+	// disables area limits
+	AreaMessagesPerMin int
+	// Slow mode starts once a cell gets SlowModeThreshold messages a
+	// minute, spacing each sender's messages by an interval that grows
+	// from the min at the threshold to the max at the area ceiling
+	SlowModeThreshold   int
+	SlowModeMinInterval time.Duration
+	SlowModeMaxInterval time.Duration
+	// IPv6PrefixBits is the prefix IPv6 clients are grouped by for IP rate
+	// limits; 128 limits each address separately
+	IPv6PrefixBits int
 }
 
 type SessionConfig struct {
@@ -47,14 +119,45 @@ type SessionConfig struct {
 
 type SpamConfig struct {
 	ProfanityEnabled       bool
+	ProfanityFiles         map[string]string
+	ProfanityAllowlistFile string
 	DuplicateWindowSeconds int
+	SimilarityDistance     int
+	SimilarityMinLength    int
+	FloodWindowSeconds     int
+	FloodMinSenders        int
+	FloodAreaPrecision     int
+	FloodMaxTracked        int
 	MaxURLsPerMessage      int
+	LinkPolicy             string
+	AreaLinkPolicies       map[string]string
+	LinkAllowlist          []string
+	LinkBlocklist          []string
+	PIIRedaction           string
+	BanBaseDuration        time.Duration
+	BanMaxDuration         time.Duration
+	// ShadowBanDuration is how long first offenders are shadow-banned
+	// instead of banned; 0 disables shadow bans
+	ShadowBanDuration         time.Duration
+	FlagScore                 int
+	RejectScore               int
+	RuleWeights               map[string]float64
+	ClassifierEnabled         bool
+	ClassifierStore           string
+	ClassifierModelFile       string
+	ClassifierMinDocs         int
+	ClassifierRefreshInterval time.Duration
 }
 
 type LocationConfig struct {
+	// Backend indexes locations for nearby queries: "geohash" for per-cell
+	// sets or "geo" for a Redis GEO index
+	Backend          string
 	GeohashPrecision int
 	MinRadiusMeters  int
 	MaxRadiusMeters  int
+	// CleanupInterval is how often stale sessions are trimmed from the index
+	CleanupInterval time.Duration
 }
 
 type MonitoringConfig struct {
@@ -62,47 +165,154 @@ type MonitoringConfig struct {
 	LogLevel      string
 }
 
+type AdminConfig struct {
+	Token string
+}
+
+type ReputationConfig struct {
+	Enabled       bool
+	MinMultiplier float64
+	MaxMultiplier float64
+	TTL           time.Duration
+}
+
+type AnalyticsConfig struct {
+	// FlushInterval is how often the day's counters are written to Postgres
+	FlushInterval time.Duration
+	// Retention is how long daily counters are kept in Redis
+	Retention time.Duration
+}
+
+// Load reads the configuration from the environment, falling back to the
+// YAML or TOML file named by CONFIG_FILE and then to defaults, and validates
+// it. Every problem found is reported in one *ValidationError.
 func Load() (*Config, error) {
+	l := &loader{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := l.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
 	cfg := &Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
-			Host: getEnv("HOST", "0.0.0.0"),
-			Env:  getEnv("ENV", "development"),
+			Port:            l.string("PORT", "8080"),
+			Host:            l.string("HOST", "0.0.0.0"),
+			Env:             l.string("ENV", "development"),
+			TrustedProxies:  l.list("TRUSTED_PROXIES"),
+			ClientIPHeaders: l.list("CLIENT_IP_HEADERS"),
 		},
 		Redis: RedisConfig{
-			Host:     getEnv("REDIS_HOST", "localhost"),
-			Port:     getEnv("REDIS_PORT", "6379"),
-			Password: getEnv("REDIS_PASSWORD", ""),
-			DB:       getEnvInt("REDIS_DB", 0),
+			Storage:          l.string("STORAGE", "redis"),
+			Mode:             l.string("REDIS_MODE", "standalone"),
+			Host:             l.string("REDIS_HOST", "localhost"),
+			Port:             l.string("REDIS_PORT", "6379"),
+			Addrs:            l.list("REDIS_ADDRS"),
+			MasterName:       l.string("REDIS_MASTER_NAME", ""),
+			Username:         l.string("REDIS_USERNAME", ""),
+			Password:         l.string("REDIS_PASSWORD", ""),
+			SentinelUsername: l.string("REDIS_SENTINEL_USERNAME", ""),
+			SentinelPassword: l.string("REDIS_SENTINEL_PASSWORD", ""),
+			DB:               l.int("REDIS_DB", 0),
+			KeyPrefix:        l.string("REDIS_KEY_PREFIX", ""),
+			TenantID:         l.string("REDIS_TENANT_ID", ""),
+			TLS: RedisTLSConfig{
+				Enabled:            l.bool("REDIS_TLS", false),
+				CAFile:             l.string("REDIS_TLS_CA_FILE", ""),
+				CertFile:           l.string("REDIS_TLS_CERT_FILE", ""),
+				KeyFile:            l.string("REDIS_TLS_KEY_FILE", ""),
+				ServerName:         l.string("REDIS_TLS_SERVER_NAME", ""),
+				InsecureSkipVerify: l.bool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+			},
+		},
+		Postgres: PostgresConfig{
+			Enabled:     l.bool("POSTGRES_ENABLED", false),
+			URL:         l.string("DATABASE_URL", ""),
+			AutoMigrate: l.bool("POSTGRES_AUTO_MIGRATE", true),
 		},
 		RateLimit: RateLimitConfig{
-			MessagesPerMin:       getEnvInt("RATE_LIMIT_MESSAGES_PER_MIN", 10),
-			LocationUpdatesPerMin:       getEnvInt("RATE_LIMIT_LOCATION_PER_MIN", 6),
-			MaxUsernameChanges:   getEnvInt("RATE_LIMIT_MAX_USERNAME_CHANGES", 3),
-			SessionsPerIPPerHour: getEnvInt("RATE_LIMIT_SESSIONS_PER_IP_PER_HOUR", 10),
-			RequestsPerMinute: getEnvInt("REQUESTS_PER_MINUTE", 100),
-			ConcurrentConnections: getEnvInt("CONCURRENT_CONNECTIONS", 100),
+			MessagesPerMin:        l.int("RATE_LIMIT_MESSAGES_PER_MIN", 10),
+			LocationUpdatesPerMin: l.int("RATE_LIMIT_LOCATION_PER_MIN", 6),
+			MaxUsernameChanges:    l.int("RATE_LIMIT_MAX_USERNAME_CHANGES", 3),
+			SessionsPerIPPerHour:  l.int("RATE_LIMIT_SESSIONS_PER_IP_PER_HOUR", 10),
+			ReportsPerIPPerHour:   l.int("RATE_LIMIT_REPORTS_PER_IP_PER_HOUR", 10),
+			RequestsPerMinute:     l.int("REQUESTS_PER_MINUTE", 100),
+			ConcurrentConnections: l.int("CONCURRENT_CONNECTIONS", 100),
+			Algorithms:            l.stringMap("RATE_LIMIT_ALGORITHMS"),
+			MessageBurst:          l.int("RATE_LIMIT_MESSAGE_BURST", 5),
+			LocationBurst:         l.int("RATE_LIMIT_LOCATION_BURST", 3),
+			RequestBurst:          l.int("REQUESTS_BURST", 20),
+			FailureModes:          l.stringMap("RATE_LIMIT_FAILURE_MODES"),
+			HealthCheckInterval:   time.Duration(l.int("RATE_LIMIT_HEALTH_CHECK_SECONDS", 5)) * time.Second,
+			AreaMessagesPerMin:    l.int("RATE_LIMIT_AREA_MESSAGES_PER_MIN", 120),
+			SlowModeThreshold:     l.int("RATE_LIMIT_SLOW_MODE_THRESHOLD", 60),
+			SlowModeMinInterval:   time.Duration(l.int("RATE_LIMIT_SLOW_MODE_MIN_SECONDS", 5)) * time.Second,
+			SlowModeMaxInterval:   time.Duration(l.int("RATE_LIMIT_SLOW_MODE_MAX_SECONDS", 30)) * time.Second,
+			IPv6PrefixBits:        l.int("RATE_LIMIT_IPV6_PREFIX", 64),
 		},
 		Session: SessionConfig{
-			TTL:        time.Duration(getEnvInt("SESSION_TTL_MINUTES", 30)) * time.Minute,
-			MessageTTL: time.Duration(getEnvInt("MESSAGE_TTL_MINUTES", 30)) * time.Minute,
+			TTL:        time.Duration(l.int("SESSION_TTL_MINUTES", 30)) * time.Minute,
+			MessageTTL: time.Duration(l.int("MESSAGE_TTL_MINUTES", 30)) * time.Minute,
 		},
 		Spam: SpamConfig{
-			ProfanityEnabled:       getEnvBool("SPAM_PROFANITY_ENABLED", true),
-			DuplicateWindowSeconds: getEnvInt("SPAM_DUPLICATE_WINDOW_SECONDS", 30),
-			MaxURLsPerMessage:      getEnvInt("SPAM_MAX_URLS_PER_MESSAGE", 2),
+			ProfanityEnabled:          l.bool("SPAM_PROFANITY_ENABLED", true),
+			ProfanityFiles:            l.stringMap("SPAM_PROFANITY_FILES"),
+			ProfanityAllowlistFile:    l.string("SPAM_PROFANITY_ALLOWLIST", ""),
+			DuplicateWindowSeconds:    l.int("SPAM_DUPLICATE_WINDOW_SECONDS", 30),
+			SimilarityDistance:        l.int("SPAM_SIMILARITY_DISTANCE", 10),
+			SimilarityMinLength:       l.int("SPAM_SIMILARITY_MIN_LENGTH", 20),
+			FloodWindowSeconds:        l.int("SPAM_FLOOD_WINDOW_SECONDS", 60),
+			FloodMinSenders:           l.int("SPAM_FLOOD_MIN_SENDERS", 3),
+			FloodAreaPrecision:        l.int("SPAM_FLOOD_AREA_PRECISION", 4),
+			FloodMaxTracked:           l.int("SPAM_FLOOD_MAX_TRACKED", 200),
+			MaxURLsPerMessage:         l.int("SPAM_MAX_URLS_PER_MESSAGE", 2),
+			LinkPolicy:                l.string("SPAM_LINK_POLICY", "any"),
+			AreaLinkPolicies:          l.stringMap("SPAM_AREA_LINK_POLICIES"),
+			LinkAllowlist:             l.list("SPAM_LINK_ALLOWLIST"),
+			LinkBlocklist:             l.list("SPAM_LINK_BLOCKLIST"),
+			PIIRedaction:              l.string("SPAM_PII_REDACTION", "placeholder"),
+			BanBaseDuration:           time.Duration(l.int("SPAM_BAN_BASE_MINUTES", 10)) * time.Minute,
+			BanMaxDuration:            time.Duration(l.int("SPAM_BAN_MAX_MINUTES", 7*24*60)) * time.Minute,
+			ShadowBanDuration:         time.Duration(l.int("SPAM_SHADOW_BAN_MINUTES", 60)) * time.Minute,
+			FlagScore:                 l.int("SPAM_FLAG_SCORE", 40),
+			RejectScore:               l.int("SPAM_REJECT_SCORE", 70),
+			RuleWeights:               l.floatMap("SPAM_RULE_WEIGHTS"),
+			ClassifierEnabled:         l.bool("SPAM_CLASSIFIER_ENABLED", false),
+			ClassifierStore:           l.string("SPAM_CLASSIFIER_STORE", "redis"),
+			ClassifierModelFile:       l.string("SPAM_CLASSIFIER_MODEL_FILE", ""),
+			ClassifierMinDocs:         l.int("SPAM_CLASSIFIER_MIN_DOCS", 20),
+			ClassifierRefreshInterval: time.Duration(l.int("SPAM_CLASSIFIER_REFRESH_SECONDS", 60)) * time.Second,
 		},
 		Location: LocationConfig{
-			GeohashPrecision: getEnvInt("GEOHASH_PRECISION", 7),
-			MinRadiusMeters:  getEnvInt("MIN_RADIUS_METERS", 100),
-			MaxRadiusMeters:  getEnvInt("MAX_RADIUS_METERS", 2000),
+			Backend:          l.string("LOCATION_BACKEND", "geohash"),
+			GeohashPrecision: l.int("GEOHASH_PRECISION", 7),
+			MinRadiusMeters:  l.int("MIN_RADIUS_METERS", 100),
+			MaxRadiusMeters:  l.int("MAX_RADIUS_METERS", 2000),
+			CleanupInterval:  time.Duration(l.int("LOCATION_CLEANUP_SECONDS", 60)) * time.Second,
 		},
 		Monitoring: MonitoringConfig{
-			EnableMetrics: getEnvBool("ENABLE_METRICS", true),
-			LogLevel:      getEnv("LOG_LEVEL", "info"),
+			EnableMetrics: l.bool("ENABLE_METRICS", true),
+			LogLevel:      l.string("LOG_LEVEL", "info"),
+		},
+		Admin: AdminConfig{
+			Token: l.string("ADMIN_TOKEN", ""),
+		},
+		Reputation: ReputationConfig{
+			Enabled:       l.bool("REPUTATION_ENABLED", true),
+			MinMultiplier: l.float("REPUTATION_MIN_MULTIPLIER", 0.25),
+			MaxMultiplier: l.float("REPUTATION_MAX_MULTIPLIER", 2),
+			TTL:           time.Duration(l.int("REPUTATION_TTL_HOURS", 7*24)) * time.Hour,
+		},
+		Analytics: AnalyticsConfig{
+			FlushInterval: time.Duration(l.int("ANALYTICS_FLUSH_MINUTES", 15)) * time.Minute,
+			Retention:     time.Duration(l.int("ANALYTICS_RETENTION_DAYS", 3)) * 24 * time.Hour,
 		},
 	}
 
+	problems := append(l.problems, cfg.problems()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
 	return cfg, nil
 }
 
@@ -110,31 +320,15 @@ func (c *Config) RedisAddr() string {
 	return fmt.Sprintf("%s:%s", c.Redis.Host, c.Redis.Port)
 }
 
-func (c *Config) ServerAddr() string {
-	return fmt.Sprintf("%s:%s", c.Server.Host, c.Server.Port)
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// RedisAddrs returns the Sentinel or cluster seed addresses, defaulting to
+// RedisAddr.
+func (c *Config) RedisAddrs() []string {
+	if len(c.Redis.Addrs) > 0 {
+		return c.Redis.Addrs
 	}
-	return defaultValue
+	return []string{c.RedisAddr()}
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
-		}
-	}
-	return defaultValue
+func (c *Config) ServerAddr() string {
+	return fmt.Sprintf("%s:%s", c.Server.Host, c.Server.Port)
 }
-
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolVal, err := strconv.ParseBool(value); err == nil {
-			return boolVal
-		}
-	}
-	return defaultValue
-}
\ No newline at end of file
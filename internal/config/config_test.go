@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testYAML = `
+port: 9090
+trusted_proxies: [10.0.0.0/8, 192.168.0.0/16]
+redis:
+  host: redis.internal
+  password: secret
+  tls_ca_file: /etc/ca.pem
+rate_limit:
+  messages_per_min: 20
+  algorithms:
+    message: token_bucket
+spam:
+  shadow_ban_minutes: 0
+  rule_weights:
+    urls: 1.5
+    caps: 0
+`
+
+const testTOML = `
+port = 9090
+trusted_proxies = ["10.0.0.0/8", "192.168.0.0/16"]
+
+[redis]
+host = "redis.internal"
+password = "secret"
+tls_ca_file = "/etc/ca.pem"
+
+[rate_limit]
+messages_per_min = 20
+
+[rate_limit.algorithms]
+message = "token_bucket"
+
+[spam]
+shadow_ban_minutes = 0
+
+[spam.rule_weights]
+urls = 1.5
+caps = 0
+`
+
+// writeConfig writes a config file and points CONFIG_FILE at it.
+func writeConfig(t *testing.T, name, contents string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func TestLoadFile(t *testing.T) {
+	for _, file := range []struct{ name, contents string }{
+		{"config.yaml", testYAML},
+		{"config.toml", testTOML},
+	} {
+		t.Run(file.name, func(t *testing.T) {
+			writeConfig(t, file.name, file.contents)
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Server.Port != "9090" {
+				t.Errorf("Port = %q, want 9090", cfg.Server.Port)
+			}
+			if want := []string{"10.0.0.0/8", "192.168.0.0/16"}; !reflect.DeepEqual(cfg.Server.TrustedProxies, want) {
+				t.Errorf("TrustedProxies = %v, want %v", cfg.Server.TrustedProxies, want)
+			}
+			if cfg.Redis.Host != "redis.internal" || cfg.Redis.Password != "secret" {
+				t.Errorf("Redis host %q and password %q, want redis.internal and secret", cfg.Redis.Host, cfg.Redis.Password)
+			}
+			if cfg.Redis.TLS.CAFile != "/etc/ca.pem" {
+				t.Errorf("TLS.CAFile = %q, want /etc/ca.pem", cfg.Redis.TLS.CAFile)
+			}
+			if cfg.RateLimit.MessagesPerMin != 20 {
+				t.Errorf("MessagesPerMin = %d, want 20", cfg.RateLimit.MessagesPerMin)
+			}
+			if want := map[string]string{"message": "token_bucket"}; !reflect.DeepEqual(cfg.RateLimit.Algorithms, want) {
+				t.Errorf("Algorithms = %v, want %v", cfg.RateLimit.Algorithms, want)
+			}
+			if want := map[string]float64{"urls": 1.5, "caps": 0}; !reflect.DeepEqual(cfg.Spam.RuleWeights, want) {
+				t.Errorf("RuleWeights = %v, want %v", cfg.Spam.RuleWeights, want)
+			}
+			if cfg.Spam.ShadowBanDuration != 0 {
+				t.Errorf("ShadowBanDuration = %s, want 0", cfg.Spam.ShadowBanDuration)
+			}
+
+			// Settings the file leaves out keep their defaults
+			if cfg.Redis.Port != "6379" || cfg.Location.GeohashPrecision != 7 {
+				t.Errorf("Redis port %q and precision %d, want the defaults", cfg.Redis.Port, cfg.Location.GeohashPrecision)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name, contents, want string
+	}{
+		{"config.json", `{}`, "unsupported format"},
+		{"config.yaml", "redis: [unclosed", "failed to parse"},
+		{"config.toml", "port = ", "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, tt.name, tt.contents)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+		if _, err := Load(); err == nil {
+			t.Error("Load succeeded with a missing config file")
+		}
+	})
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	writeConfig(t, "config.yaml", testYAML)
+	t.Setenv("PORT", "7070")
+	t.Setenv("REDIS_HOST", "redis.env")
+	// Set but empty: clears the file's value rather than falling back to it
+	t.Setenv("REDIS_PASSWORD", "")
+	t.Setenv("SPAM_RULE_WEIGHTS", "")
+	// Set but empty for a parsed setting: the default, not the file's 20
+	t.Setenv("RATE_LIMIT_MESSAGES_PER_MIN", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != "7070" {
+		t.Errorf("Port = %q, want the environment's 7070", cfg.Server.Port)
+	}
+	if cfg.Redis.Host != "redis.env" {
+		t.Errorf("Redis host = %q, want the environment's redis.env", cfg.Redis.Host)
+	}
+	if cfg.Redis.Password != "" {
+		t.Errorf("Redis password = %q, want it cleared by the empty variable", cfg.Redis.Password)
+	}
+	if len(cfg.Spam.RuleWeights) != 0 {
+		t.Errorf("RuleWeights = %v, want them cleared by the empty variable", cfg.Spam.RuleWeights)
+	}
+	if cfg.RateLimit.MessagesPerMin != 10 {
+		t.Errorf("MessagesPerMin = %d, want the default 10", cfg.RateLimit.MessagesPerMin)
+	}
+	// Settings the environment leaves alone still come from the file
+	if cfg.Redis.TLS.CAFile != "/etc/ca.pem" {
+		t.Errorf("TLS.CAFile = %q, want the file's /etc/ca.pem", cfg.Redis.TLS.CAFile)
+	}
+}
+
+func TestValidationErrorListsEveryProblem(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("RATE_LIMIT_MESSAGES_PER_MIN", "ten")
+	t.Setenv("GEOHASH_PRECISION", "13")
+	t.Setenv("SPAM_RULE_WEIGHTS", "urls=1,bogus=2")
+	t.Setenv("SPAM_FLAG_SCORE", "80")
+
+	_, err := Load()
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("Load error %v, want a *ValidationError", err)
+	}
+
+	want := []string{
+		`RATE_LIMIT_MESSAGES_PER_MIN: "ten" is not an integer`,
+		"PORT must be a port number",
+		"GEOHASH_PRECISION must be from 1 to 12, got 13",
+		"SPAM_FLAG_SCORE (80) and SPAM_REJECT_SCORE (70)",
+		`unknown rule "bogus"`,
+	}
+	if len(validation.Problems) != len(want) {
+		t.Errorf("problems %q, want %d", validation.Problems, len(want))
+	}
+	for _, problem := range want {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error does not mention %q:\n%s", problem, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		// want are the problems expected, by a part of each
+		want []string
+	}{
+		{"defaults", func(*Config) {}, nil},
+		{"precision 1", func(c *Config) {
+			c.Location.GeohashPrecision = 1
+			c.Spam.FloodAreaPrecision = 1
+		}, nil},
+		{"precision 12", func(c *Config) { c.Location.GeohashPrecision = 12 }, nil},
+		{"precision 0", func(c *Config) {
+			c.Location.GeohashPrecision = 0
+			c.Spam.FloodAreaPrecision = 0
+		}, []string{"GEOHASH_PRECISION", "SPAM_FLOOD_AREA_PRECISION"}},
+		{"flood area finer than cells", func(c *Config) {
+			c.Location.GeohashPrecision = 4
+			c.Spam.FloodAreaPrecision = 5
+		}, []string{"SPAM_FLOOD_AREA_PRECISION must be from 1 to GEOHASH_PRECISION (4), got 5"}},
+		{"ipv6 prefix 1", func(c *Config) { c.RateLimit.IPv6PrefixBits = 1 }, nil},
+		{"ipv6 prefix 128", func(c *Config) { c.RateLimit.IPv6PrefixBits = 128 }, nil},
+		{"ipv6 prefix 0", func(c *Config) { c.RateLimit.IPv6PrefixBits = 0 },
+			[]string{"RATE_LIMIT_IPV6_PREFIX must be from 1 to 128, got 0"}},
+		{"ipv6 prefix 129", func(c *Config) { c.RateLimit.IPv6PrefixBits = 129 },
+			[]string{"RATE_LIMIT_IPV6_PREFIX must be from 1 to 128, got 129"}},
+		{"database in cluster mode", func(c *Config) {
+			c.Redis.Mode = "cluster"
+			c.Redis.DB = 2
+		}, []string{"REDIS_DB must be 0 in cluster mode"}},
+		{"several problems", func(c *Config) {
+			c.Redis.Mode = "sentinel"
+			c.Postgres.Enabled = true
+			c.RateLimit.SlowModeMinInterval = time.Minute
+			c.Location.MinRadiusMeters = c.Location.MaxRadiusMeters
+		}, []string{"REDIS_MASTER_NAME", "DATABASE_URL", "RATE_LIMIT_SLOW_MODE_MIN_SECONDS", "MIN_RADIUS_METERS"}},
+		{"memory storage skips Redis settings", func(c *Config) {
+			c.Redis.Storage = "memory"
+			c.Redis.Mode = "bogus"
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := *cfg
+			tt.change(&changed)
+
+			err := changed.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("Validate error %v, want a *ValidationError", err)
+			}
+			if len(validation.Problems) != len(tt.want) {
+				t.Errorf("problems %q, want %d", validation.Problems, len(tt.want))
+			}
+			for _, problem := range tt.want {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error does not mention %q:\n%s", problem, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// loader looks settings up by their environment variable names: in the
+// environment first, then in the config file. Values that do not parse are
+// recorded as problems rather than silently replaced by the default.
+type loader struct {
+	// file holds the config file's values by environment variable name
+	file map[string]string
+	// fileMaps holds the file's tables by environment variable name, for
+	// settings that are maps
+	fileMaps map[string]map[string]string
+	problems []string
+}
+
+// readFile loads a YAML (.yaml, .yml) or TOML (.toml) config file. Its
+// sections nest the environment variable names, so
+//
+//	redis:
+//	  host: redis.internal
+//	  tls_ca_file: /etc/ca.pem
+//
+// sets REDIS_HOST and REDIS_TLS_CA_FILE. Lists set comma-separated
+// settings, and tables set "key=value" ones such as SPAM_RULE_WEIGHTS.
+func (l *loader) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	l.file = make(map[string]string)
+	l.fileMaps = make(map[string]map[string]string)
+	l.flatten("", values)
+	return nil
+}
+
+func (l *loader) flatten(name string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		table := make(map[string]string)
+		for key, child := range v {
+			childName := strings.ToUpper(key)
+			if name != "" {
+				childName = name + "_" + childName
+			}
+			l.flatten(childName, child)
+			if _, nested := child.(map[string]interface{}); !nested && child != nil {
+				table[key] = fmt.Sprint(child)
+			}
+		}
+		if name != "" {
+			l.fileMaps[name] = table
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		l.file[name] = strings.Join(items, ",")
+	default:
+		l.file[name] = fmt.Sprint(v)
+	}
+}
+
+// lookup returns the setting's value from the environment or the file. An
+// environment variable that is set wins even when empty, so it can clear a
+// file's value; empty values in the file count as unset.
+func (l *loader) lookup(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true
+	}
+	value, ok := l.file[key]
+	return value, ok && value != ""
+}
+
+// lookupParsed is lookup for settings that parse a value, where an empty
+// one means the default.
+func (l *loader) lookupParsed(key string) (string, bool) {
+	value, ok := l.lookup(key)
+	return value, ok && strings.TrimSpace(value) != ""
+}
+
+func (l *loader) invalid(key, value, want string) {
+	l.problems = append(l.problems, fmt.Sprintf("%s: %q is not %s", key, value, want))
+}
+
+func (l *loader) string(key, defaultValue string) string {
+	if value, ok := l.lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (l *loader) int(key string, defaultValue int) int {
+	value, ok := l.lookupParsed(key)
+	if !ok {
+		return defaultValue
+	}
+	intVal, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.invalid(key, value, "an integer")
+		return defaultValue
+	}
+	return intVal
+}
+
+func (l *loader) bool(key string, defaultValue bool) bool {
+	value, ok := l.lookupParsed(key)
+	if !ok {
+		return defaultValue
+	}
+	boolVal, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.invalid(key, value, "a boolean")
+		return defaultValue
+	}
+	return boolVal
+}
+
+func (l *loader) float(key string, defaultValue float64) float64 {
+	value, ok := l.lookupParsed(key)
+	if !ok {
+		return defaultValue
+	}
+	floatVal, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		l.invalid(key, value, "a number")
+		return defaultValue
+	}
+	return floatVal
+}
+
+// list parses a comma-separated list, skipping empty items.
+func (l *loader) list(key string) []string {
+	value, _ := l.lookup(key)
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// stringMap parses "key=value,key=value" pairs from the environment, or a
+// table from the file.
+func (l *loader) stringMap(key string) map[string]string {
+	result := make(map[string]string)
+	if _, set := os.LookupEnv(key); !set {
+		if table, ok := l.fileMaps[key]; ok {
+			for name, value := range table {
+				result[name] = value
+			}
+			return result
+		}
+	}
+
+	value, _ := l.lookup(key)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			l.invalid(key, pair, "a key=value pair")
+			continue
+		}
+		result[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+// floatMap is stringMap with number values.
+func (l *loader) floatMap(key string) map[string]float64 {
+	result := make(map[string]float64)
+	pairs := l.stringMap(key)
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		floatVal, err := strconv.ParseFloat(pairs[name], 64)
+		if err != nil {
+			l.invalid(key+" "+name, pairs[name], "a number")
+			continue
+		}
+		result[name] = floatVal
+	}
+	return result
+}
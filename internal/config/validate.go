@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpamRuleNames are the spam rules SPAM_RULE_WEIGHTS can weigh, as named by
+// their Name methods in package spam.
+var SpamRuleNames = []string{
+	"length", "profanity", "urls", "emails", "phones", "caps",
+	"repeated_chars", "scam", "classifier", "duplicates", "flood",
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks that settings are in range and consistent with each
+// other, returning a *ValidationError listing every problem.
+func (c *Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *Config) problems() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	positive := func(name string, value int) {
+		check(value > 0, "%s must be positive, got %d", name, value)
+	}
+	positiveDuration := func(name string, value time.Duration) {
+		check(value > 0, "%s must be positive, got %s", name, value)
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value))
+	}
+	port := func(name, value string) {
+		n, err := strconv.Atoi(value)
+		check(err == nil && n > 0 && n < 65536, "%s must be a port number from 1 to 65535, got %q", name, value)
+	}
+
+	// Server
+	port("PORT", c.Server.Port)
+
+	// Redis
+	oneOf("STORAGE", c.Redis.Storage, "redis", "memory")
+	if c.Redis.Storage == "redis" {
+		oneOf("REDIS_MODE", c.Redis.Mode, "standalone", "sentinel", "cluster")
+		if len(c.Redis.Addrs) == 0 {
+			port("REDIS_PORT", c.Redis.Port)
+		}
+		check(c.Redis.Mode != "sentinel" || c.Redis.MasterName != "",
+			"REDIS_MASTER_NAME is required in sentinel mode")
+		check(c.Redis.Mode != "cluster" || c.Redis.DB == 0,
+			"REDIS_DB must be 0 in cluster mode, got %d", c.Redis.DB)
+		check(c.Redis.DB >= 0, "REDIS_DB must not be negative, got %d", c.Redis.DB)
+		check((c.Redis.TLS.CertFile == "") == (c.Redis.TLS.KeyFile == ""),
+			"REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+	}
+
+	// Postgres
+	check(!c.Postgres.Enabled || c.Postgres.URL != "",
+		"DATABASE_URL is required when POSTGRES_ENABLED is true")
+
+	// Rate limits
+	rl := c.RateLimit
+	positive("RATE_LIMIT_MESSAGES_PER_MIN", rl.MessagesPerMin)
+	positive("RATE_LIMIT_LOCATION_PER_MIN", rl.LocationUpdatesPerMin)
+	positive("REQUESTS_PER_MINUTE", rl.RequestsPerMinute)
+	positive("RATE_LIMIT_SESSIONS_PER_IP_PER_HOUR", rl.SessionsPerIPPerHour)
+	positive("RATE_LIMIT_REPORTS_PER_IP_PER_HOUR", rl.ReportsPerIPPerHour)
+	positive("RATE_LIMIT_MESSAGE_BURST", rl.MessageBurst)
+	positive("RATE_LIMIT_LOCATION_BURST", rl.LocationBurst)
+	positive("REQUESTS_BURST", rl.RequestBurst)
+	check(rl.MaxUsernameChanges >= 0,
+		"RATE_LIMIT_MAX_USERNAME_CHANGES must not be negative, got %d", rl.MaxUsernameChanges)
+	positiveDuration("RATE_LIMIT_HEALTH_CHECK_SECONDS", rl.HealthCheckInterval)
+	check(rl.AreaMessagesPerMin >= 0,
+		"RATE_LIMIT_AREA_MESSAGES_PER_MIN must not be negative, got %d", rl.AreaMessagesPerMin)
+	if rl.AreaMessagesPerMin > 0 {
+		positive("RATE_LIMIT_SLOW_MODE_THRESHOLD", rl.SlowModeThreshold)
+		check(rl.SlowModeMinInterval <= rl.SlowModeMaxInterval,
+			"RATE_LIMIT_SLOW_MODE_MIN_SECONDS (%s) must not exceed RATE_LIMIT_SLOW_MODE_MAX_SECONDS (%s)",
+			rl.SlowModeMinInterval, rl.SlowModeMaxInterval)
+	}
+	check(rl.IPv6PrefixBits >= 1 && rl.IPv6PrefixBits <= 128,
+		"RATE_LIMIT_IPV6_PREFIX must be from 1 to 128, got %d", rl.IPv6PrefixBits)
+
+	// Sessions
+	positiveDuration("SESSION_TTL_MINUTES", c.Session.TTL)
+	positiveDuration("MESSAGE_TTL_MINUTES", c.Session.MessageTTL)
+
+	// Location
+	loc := c.Location
+	oneOf("LOCATION_BACKEND", loc.Backend, "geohash", "geo")
+	check(loc.GeohashPrecision >= 1 && loc.GeohashPrecision <= 12,
+		"GEOHASH_PRECISION must be from 1 to 12, got %d", loc.GeohashPrecision)
+	positive("MIN_RADIUS_METERS", loc.MinRadiusMeters)
+	check(loc.MinRadiusMeters < loc.MaxRadiusMeters,
+		"MIN_RADIUS_METERS (%d) must be less than MAX_RADIUS_METERS (%d)", loc.MinRadiusMeters, loc.MaxRadiusMeters)
+	positiveDuration("LOCATION_CLEANUP_SECONDS", loc.CleanupInterval)
+
+	// Spam
+	sp := c.Spam
+	positive("SPAM_DUPLICATE_WINDOW_SECONDS", sp.DuplicateWindowSeconds)
+	positive("SPAM_FLOOD_WINDOW_SECONDS", sp.FloodWindowSeconds)
+	positive("SPAM_FLOOD_MAX_TRACKED", sp.FloodMaxTracked)
+	check(sp.FloodAreaPrecision >= 1 && sp.FloodAreaPrecision <= loc.GeohashPrecision,
+		"SPAM_FLOOD_AREA_PRECISION must be from 1 to GEOHASH_PRECISION (%d), got %d", loc.GeohashPrecision, sp.FloodAreaPrecision)
+	check(sp.FlagScore >= 0 && sp.FlagScore <= sp.RejectScore && sp.RejectScore <= 100,
+		"SPAM_FLAG_SCORE (%d) and SPAM_REJECT_SCORE (%d) must satisfy 0 <= flag <= reject <= 100", sp.FlagScore, sp.RejectScore)
+	positiveDuration("SPAM_BAN_BASE_MINUTES", sp.BanBaseDuration)
+	check(sp.BanBaseDuration <= sp.BanMaxDuration,
+		"SPAM_BAN_BASE_MINUTES (%s) must not exceed SPAM_BAN_MAX_MINUTES (%s)", sp.BanBaseDuration, sp.BanMaxDuration)
+	for _, name := range slices.Sorted(maps.Keys(sp.RuleWeights)) {
+		check(slices.Contains(SpamRuleNames, name),
+			"SPAM_RULE_WEIGHTS: unknown rule %q (want one of %s)", name, strings.Join(SpamRuleNames, ", "))
+	}
+	// 0 turns shadow bans off, so offenders are banned outright
+	check(sp.ShadowBanDuration >= 0,
+		"SPAM_SHADOW_BAN_MINUTES must not be negative, got %s", sp.ShadowBanDuration)
+	if sp.ClassifierEnabled {
+		oneOf("SPAM_CLASSIFIER_STORE", sp.ClassifierStore, "redis", "file")
+		check(sp.ClassifierStore != "file" || sp.ClassifierModelFile != "",
+			"SPAM_CLASSIFIER_MODEL_FILE is required when SPAM_CLASSIFIER_STORE is file")
+		positiveDuration("SPAM_CLASSIFIER_REFRESH_SECONDS", sp.ClassifierRefreshInterval)
+	}
+
+	// Reputation
+	if c.Reputation.Enabled {
+		rep := c.Reputation
+		check(rep.MinMultiplier > 0 && rep.MinMultiplier <= rep.MaxMultiplier,
+			"REPUTATION_MIN_MULTIPLIER (%g) must be positive and at most REPUTATION_MAX_MULTIPLIER (%g)",
+			rep.MinMultiplier, rep.MaxMultiplier)
+		positiveDuration("REPUTATION_TTL_HOURS", rep.TTL)
+	}
+
+	// Analytics
+	positiveDuration("ANALYTICS_FLUSH_MINUTES", c.Analytics.FlushInterval)
+	positiveDuration("ANALYTICS_RETENTION_DAYS", c.Analytics.Retention)
+
+	return problems
+}
@@ -0,0 +1,221 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// GeoService is a LocationService that finds nearby users with one
+// GEOSEARCH on a Redis GEO index instead of the geohash cell sets. Locations
+// themselves are stored as Service stores them, so GetLocation and
+// GetGeohash behave the same.
+type GeoService struct {
+	*Service
+}
+
+func NewGeoService(redisClient storage.RedisClient, geohashPrecision, minRadius, maxRadius int) *GeoService {
+	return &GeoService{
+		Service: NewService(redisClient, geohashPrecision, minRadius, maxRadius),
+	}
+}
+
+func (s *GeoService) UpdateLocation(ctx context.Context, sessionID string, lat, lon float64, radius int) error {
+	// Validate radius
+	if radius < s.minRadius || radius > s.maxRadius {
+		return fmt.Errorf("radius must be between %d and %d meters", s.minRadius, s.maxRadius)
+	}
+
+	now := time.Now()
+	location := &Location{
+		SessionID: sessionID,
+		Lat:       lat,
+		Lon:       lon,
+		Radius:    radius,
+		Geohash:   Encode(lat, lon, s.geohashPrecision),
+		UpdatedAt: now,
+	}
+
+	data, err := json.Marshal(location)
+	if err != nil {
+		return fmt.Errorf("failed to marshal location: %w", err)
+	}
+
+	pipe := s.redis.Pipeline()
+	setCmd := pipe.Set(ctx, s.locationKey(sessionID), data, locationTTL)
+	geoCmd := pipe.GeoAdd(ctx, s.geoIndexKey(), &redis.GeoLocation{
+		Name:      sessionID,
+		Latitude:  lat,
+		Longitude: lon,
+	})
+	pipe.ZAdd(ctx, s.geoFreshnessKey(), redis.Z{Score: float64(now.UnixMilli()), Member: sessionID})
+
+	if err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store location: %w", err)
+	}
+	if err := setCmd.Err(); err != nil {
+		return fmt.Errorf("failed to store location: %w", err)
+	}
+	if err := geoCmd.Err(); err != nil {
+		return fmt.Errorf("failed to add to geo index: %w", err)
+	}
+
+	return nil
+}
+
+// GetNearbyUsers finds the users within the session's radius with one
+// GEOSEARCH, then reads their locations to skip any that have expired but
+// are still indexed, removing those from the index as it goes (see
+// removeStale).
+func (s *GeoService) GetNearbyUsers(ctx context.Context, sessionID string, getUsernamesFn func([]string) map[string]string) ([]NearbyUser, error) {
+	userLoc, err := s.GetLocation(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := s.redis.GeoSearchLocation(ctx, s.geoIndexKey(), &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  userLoc.Lon,
+			Latitude:   userLoc.Lat,
+			Radius:     float64(userLoc.Radius),
+			RadiusUnit: "m",
+			Sort:       "ASC",
+		},
+		WithDist: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search geo index: %w", err)
+	}
+
+	candidates := make([]redis.GeoLocation, 0, len(found))
+	for _, candidate := range found {
+		if candidate.Name != sessionID {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	nearby := make([]NearbyUser, 0)
+	if len(candidates) == 0 {
+		return nearby, nil
+	}
+
+	keys := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		keys[i] = s.locationKey(candidate.Name)
+	}
+	values, err := s.redis.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get locations: %w", err)
+	}
+
+	stale := make([]string, 0)
+	ids := make([]string, 0, len(candidates))
+	for i, candidate := range candidates {
+		if values[i] == nil {
+			stale = append(stale, candidate.Name)
+			continue
+		}
+		nearby = append(nearby, NearbyUser{
+			SessionID: candidate.Name,
+			Distance:  RoundToNearest50(candidate.Dist),
+		})
+		ids = append(ids, candidate.Name)
+	}
+	if len(stale) > 0 {
+		s.removeStale(ctx, time.Now().Add(-locationTTL).UnixMilli(), stale)
+	}
+
+	if len(nearby) == 0 {
+		return nearby, nil
+	}
+
+	usernames := getUsernamesFn(ids)
+	for i := range nearby {
+		nearby[i].Username = usernames[nearby[i].SessionID]
+	}
+
+	return nearby, nil
+}
+
+func (s *GeoService) DeleteLocation(ctx context.Context, sessionID string) error {
+	if err := s.removeFromIndex(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.redis.Del(ctx, s.locationKey(sessionID))
+}
+
+// CleanupStaleLocations removes sessions not updated within the location
+// TTL from the GEO index.
+func (s *GeoService) CleanupStaleLocations(ctx context.Context) error {
+	cutoff := time.Now().Add(-locationTTL).UnixMilli()
+	stale, err := s.redis.ZRangeByScore(ctx, s.geoFreshnessKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(cutoff, 10),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find stale locations: %w", err)
+	}
+
+	for len(stale) > 0 {
+		batch := stale[:min(len(stale), staleBatch)]
+		stale = stale[len(batch):]
+		if err := s.removeStale(ctx, cutoff, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleBatch is how many sessions one cleanup script checks, so a long
+// backlog does not block Redis.
+const staleBatch = 100
+
+// removeStale removes the sessions from the GEO index that are still stale
+// when geoCleanupScript checks them. Outside a cluster that includes
+// sessions whose location is gone; in one, only those not updated since
+// cutoff, and the rest wait for a later cleanup.
+func (s *GeoService) removeStale(ctx context.Context, cutoff int64, sessionIDs []string) error {
+	keys := []string{s.geoIndexKey(), s.geoFreshnessKey()}
+	if !s.redis.Clustered() {
+		for _, id := range sessionIDs {
+			keys = append(keys, s.locationKey(id))
+		}
+	}
+	args := make([]interface{}, 0, len(sessionIDs)+1)
+	args = append(args, cutoff)
+	for _, id := range sessionIDs {
+		args = append(args, id)
+	}
+
+	if _, err := s.redis.RunScript(ctx, geoCleanupScript, keys, args...); err != nil {
+		return fmt.Errorf("failed to remove stale locations: %w", err)
+	}
+	return nil
+}
+
+// geoIndexKey is a GEO sorted set of every located session. It shares a
+// hash tag with geoFreshnessKey, as the two are always updated together.
+func (s *GeoService) geoIndexKey() string {
+	return s.redis.Keys().Key("locations", storage.HashTag("geo"), "index")
+}
+
+// geoFreshnessKey scores each session in the GEO index by its last update
+// in Unix milliseconds, since GEO members do not expire.
+func (s *GeoService) geoFreshnessKey() string {
+	return s.redis.Keys().Key("locations", storage.HashTag("geo"), "fresh")
+}
+
+func (s *GeoService) removeFromIndex(ctx context.Context, sessionIDs ...interface{}) error {
+	pipe := s.redis.Pipeline()
+	pipe.ZRem(ctx, s.geoIndexKey(), sessionIDs...)
+	pipe.ZRem(ctx, s.geoFreshnessKey(), sessionIDs...)
+	if err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to remove from geo index: %w", err)
+	}
+	return nil
+}
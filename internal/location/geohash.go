@@ -88,6 +88,12 @@ func GetNeighbors(geohash string) []string {
 	return neighbors
 }
 
+// CoverageCells returns the cells a message sent from geohash reaches: the
+// cell itself and its neighbors.
+func CoverageCells(geohash string) []string {
+	return append([]string{geohash}, GetNeighbors(geohash)...)
+}
+
 // Decode decodes a geohash to latitude and longitude bounds
 func Decode(geohash string) (latMin, latMax, lonMin, lonMax float64) {
 	evenBit := true
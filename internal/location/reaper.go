@@ -0,0 +1,42 @@
+package location
+
+import (
+	"context"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+)
+
+// Reaper periodically removes stale sessions from the location index, so
+// users who stopped updating drop out of nearby queries.
+type Reaper struct {
+	service  LocationService
+	interval time.Duration
+}
+
+func NewReaper(service LocationService, interval time.Duration) *Reaper {
+	return &Reaper{
+		service:  service,
+		interval: interval,
+	}
+}
+
+// Start runs CleanupStaleLocations every interval until ctx is done.
+func (r *Reaper) Start(ctx context.Context, log logger.Logger) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	log.Info("Location reaper started", "interval", r.interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.service.CleanupStaleLocations(ctx); err != nil {
+				log.Error("Failed to clean up stale locations", "error", err)
+			}
+		case <-ctx.Done():
+			log.Info("Location reaper stopped")
+			return
+		}
+	}
+}
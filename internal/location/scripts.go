@@ -0,0 +1,33 @@
+package location
+
+import "github.com/redis/go-redis/v9"
+
+// geoCleanupScript removes sessions from the GEO index that are stale: not
+// updated since the cutoff, or whose location is gone. Each session is
+// checked again as it is removed, so one updated since it was found stale
+// stays indexed.
+//
+// KEYS[1] GEO index key
+// KEYS[2] freshness key
+// KEYS[3...] location keys of the sessions, in ARGV order; left out in a
+// cluster, where they are in other slots, so only freshness counts there
+// ARGV[1] cutoff in Unix milliseconds
+// ARGV[2...] session IDs
+//
+// Returns how many sessions were removed from the GEO index.
+var geoCleanupScript = redis.NewScript(`
+local cutoff = tonumber(ARGV[1])
+local removed = 0
+for i = 2, #ARGV do
+	local score = redis.call('ZSCORE', KEYS[2], ARGV[i])
+	local stale = score and tonumber(score) < cutoff
+	if not stale and KEYS[i + 1] then
+		stale = redis.call('EXISTS', KEYS[i + 1]) == 0
+	end
+	if stale then
+		removed = removed + redis.call('ZREM', KEYS[1], ARGV[i])
+		redis.call('ZREM', KEYS[2], ARGV[i])
+	end
+end
+return removed
+`)
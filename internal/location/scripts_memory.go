@@ -0,0 +1,50 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// In-memory implementation of geoCleanupScript, for storage.MemoryClient.
+// It follows the Lua line by line; keep the two in step
+// (TestGeoCleanupScriptParity checks both).
+func init() {
+	storage.RegisterScript(geoCleanupScript, geoCleanupMemory)
+}
+
+func geoCleanupMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("script needs 1 argument, got %d", len(args))
+	}
+	cutoff, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("script argument 1 is not a number: %q", args[0])
+	}
+
+	removed := int64(0)
+	for i, sessionID := range args[1:] {
+		score, err := tx.ZScore(keys[1], sessionID)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		stale := err == nil && score < cutoff
+		if !stale && i+2 < len(keys) {
+			stale = !tx.Exists(keys[i+2])
+		}
+		if stale {
+			count, err := tx.ZRem(keys[0], sessionID)
+			if err != nil {
+				return nil, err
+			}
+			removed += count
+			if _, err := tx.ZRem(keys[1], sessionID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,118 @@
+package location
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestGeoCleanupScriptParity runs geoCleanupScript against the in-memory
+// implementation and, when REDIS_TEST_ADDR is set, against Redis, checking
+// both remove the same sessions.
+func TestGeoCleanupScriptParity(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		runGeoCleanup(t, storagetest.NewClient(t))
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		addr := os.Getenv("REDIS_TEST_ADDR")
+		if addr == "" {
+			t.Skip("REDIS_TEST_ADDR not set")
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A fresh prefix keeps runs apart and away from real keys
+		redisClient, err := storage.NewRedisClient(&config.Config{Redis: config.RedisConfig{
+			Host:      host,
+			Port:      port,
+			KeyPrefix: "neartalk-test-" + uuid.NewString(),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer redisClient.Close()
+		if err := redisClient.Ping(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		runGeoCleanup(t, redisClient)
+	})
+}
+
+func runGeoCleanup(t *testing.T, redisClient storage.RedisClient) {
+	ctx := context.Background()
+	s := NewGeoService(redisClient, 6, 100, 2000)
+	index, fresh := s.geoIndexKey(), s.geoFreshnessKey()
+
+	// old was last updated at 1000 and updated is at 5000, both still
+	// located; gone is at 5000 but its location expired
+	sessions := map[string]float64{"old": 1000, "updated": 5000, "gone": 5000}
+	used := []string{index, fresh}
+	defer func() { redisClient.Del(ctx, used...) }()
+	for id, score := range sessions {
+		if err := redisClient.GeoAdd(ctx, index, &redis.GeoLocation{Name: id, Latitude: origin[0], Longitude: origin[1]}); err != nil {
+			t.Fatal(err)
+		}
+		if err := redisClient.ZAdd(ctx, fresh, &redis.Z{Score: score, Member: id}); err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, s.locationKey(id))
+		if id != "gone" {
+			if err := redisClient.Set(ctx, s.locationKey(id), "{}", 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	steps := []struct {
+		name string
+		// located passes the sessions' location keys, as outside a cluster
+		located bool
+		want    int64
+		// indexed is what is left in both sets afterwards
+		indexed []string
+	}{
+		{"only freshness", false, 1, []string{"gone", "updated"}},
+		{"missing location", true, 1, []string{"updated"}},
+		{"nothing stale", true, 0, []string{"updated"}},
+	}
+	for _, step := range steps {
+		ids := []string{"old", "updated", "gone"}
+		keys := []string{index, fresh}
+		if step.located {
+			for _, id := range ids {
+				keys = append(keys, s.locationKey(id))
+			}
+		}
+
+		reply, err := redisClient.RunScript(ctx, geoCleanupScript, keys, 3000, "old", "updated", "gone")
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if reply != step.want {
+			t.Errorf("%s: removed %v, want %d", step.name, reply, step.want)
+		}
+
+		for _, key := range []string{index, fresh} {
+			members, err := redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(members)
+			if !reflect.DeepEqual(members, step.indexed) {
+				t.Errorf("%s: %s has %v, want %v", step.name, key, members, step.indexed)
+			}
+		}
+	}
+}
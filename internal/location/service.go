@@ -4,16 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/askwhyharsh/neartalk/internal/storage"
 	"github.com/redis/go-redis/v9"
 )
 
+// locationTTL is how long a location lasts without an update
+const locationTTL = 5 * time.Minute
+
 type LocationService interface {
 	UpdateLocation(ctx context.Context, sessionID string, lat, lon float64, radius int) error
 	GetLocation(ctx context.Context, sessionID string) (*Location, error)
-	GetNearbyUsers(ctx context.Context, sessionID string, getUsernameFn func(string) string) ([]NearbyUser, error)
+	GetNearbyUsers(ctx context.Context, sessionID string, getUsernamesFn func([]string) map[string]string) ([]NearbyUser, error)
 	GetGeohash(ctx context.Context, sessionID string) (string, int, error)
 	DeleteLocation(ctx context.Context, sessionID string) error
 	CleanupStaleLocations(ctx context.Context) error
@@ -75,19 +80,36 @@ func (s *Service) UpdateLocation(ctx context.Context, sessionID string, lat, lon
 		return fmt.Errorf("failed to marshal location: %w", err)
 	}
 
-	// Store location with 5 minute TTL (auto-refresh on activity)
-	if err := s.redis.Set(ctx, key, data, 5*time.Minute); err != nil {
-		return fmt.Errorf("failed to store location: %w", err)
+	// The previous location says which cell to leave after a move
+	previous, err := s.GetLocation(ctx, sessionID)
+	if err != nil {
+		previous = nil
 	}
 
-	// Add to geohash index
-	geohashKey := s.geohashKey(geohash)
-	if err := s.redis.SAdd(ctx, geohashKey, sessionID); err != nil {
-		return fmt.Errorf("failed to add to geohash index: %w", err)
+	pipe := s.redis.Pipeline()
+
+	// Store location with TTL (auto-refresh on activity)
+	setCmd := pipe.Set(ctx, key, data, locationTTL)
+
+	// Add to the cell's index, scored by update time so stale members can
+	// be told apart and trimmed. The cell expires once nobody in it updates.
+	cellKey := s.cellKey(geohash)
+	addCmd := pipe.ZAdd(ctx, cellKey, redis.Z{Score: float64(location.UpdatedAt.UnixMilli()), Member: sessionID})
+	pipe.Expire(ctx, cellKey, locationTTL)
+
+	if previous != nil && previous.Geohash != geohash {
+		pipe.ZRem(ctx, s.cellKey(previous.Geohash), sessionID)
 	}
 
-	// Set expiration on geohash index
-	s.redis.Expire(ctx, geohashKey, 5*time.Minute)
+	if err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store location: %w", err)
+	}
+	if err := setCmd.Err(); err != nil {
+		return fmt.Errorf("failed to store location: %w", err)
+	}
+	if err := addCmd.Err(); err != nil {
+		return fmt.Errorf("failed to add to geohash index: %w", err)
+	}
 
 	return nil
 }
@@ -110,7 +132,10 @@ func (s *Service) GetLocation(ctx context.Context, sessionID string) (*Location,
 	return &location, nil
 }
 
-func (s *Service) GetNearbyUsers(ctx context.Context, sessionID string, getUsernameFn func(string) string) ([]NearbyUser, error) {
+// GetNearbyUsers finds the users within the session's radius. However many
+// candidates there are, it takes four round trips: the session's location,
+// the coverage cells' members, the candidates' locations and their usernames.
+func (s *Service) GetNearbyUsers(ctx context.Context, sessionID string, getUsernamesFn func([]string) map[string]string) ([]NearbyUser, error) {
 	userLoc, err := s.GetLocation(ctx, sessionID)
 	if err != nil {
 		return nil, err
@@ -120,27 +145,22 @@ func (s *Service) GetNearbyUsers(ctx context.Context, sessionID string, getUsern
 	geohashes := s.getGeohashesInRadius(userLoc.Geohash)
 
 	// Collect candidates from all geohash cells
-	candidateMap := make(map[string]bool)
-	for _, gh := range geohashes {
-		candidates, err := s.getUsersInGeohash(ctx, gh)
-		if err != nil {
-			continue
-		}
-		for _, c := range candidates {
-			if c != sessionID {
-				candidateMap[c] = true
-			}
-		}
+	candidateIDs, err := s.getUsersInGeohashes(ctx, geohashes)
+	if err != nil {
+		return nil, err
+	}
+	candidateIDs = slices.DeleteFunc(candidateIDs, func(id string) bool {
+		return id == sessionID
+	})
+
+	locations, err := s.getLocations(ctx, candidateIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	// Calculate actual distances
 	nearby := make([]NearbyUser, 0)
-	for candidateID := range candidateMap {
-		candidateLoc, err := s.GetLocation(ctx, candidateID)
-		if err != nil {
-			continue
-		}
-
+	for _, candidateLoc := range locations {
 		distance := HaversineDistance(
 			userLoc.Lat, userLoc.Lon,
 			candidateLoc.Lat, candidateLoc.Lon,
@@ -150,13 +170,25 @@ func (s *Service) GetNearbyUsers(ctx context.Context, sessionID string, getUsern
 		if distance <= float64(userLoc.Radius) {
 			approxDist := RoundToNearest50(distance)
 			nearby = append(nearby, NearbyUser{
-				SessionID: candidateID,
-				Username:  getUsernameFn(candidateID),
+				SessionID: candidateLoc.SessionID,
 				Distance:  approxDist,
 			})
 		}
 	}
 
+	if len(nearby) == 0 {
+		return nearby, nil
+	}
+
+	ids := make([]string, len(nearby))
+	for i, user := range nearby {
+		ids[i] = user.SessionID
+	}
+	usernames := getUsernamesFn(ids)
+	for i := range nearby {
+		nearby[i].Username = usernames[nearby[i].SessionID]
+	}
+
 	return nearby, nil
 }
 
@@ -172,8 +204,7 @@ func (s *Service) DeleteLocation(ctx context.Context, sessionID string) error {
 	// Get current location to remove from geohash index
 	location, err := s.GetLocation(ctx, sessionID)
 	if err == nil {
-		geohashKey := s.geohashKey(location.Geohash)
-		s.redis.SRem(ctx, geohashKey, sessionID)
+		s.redis.ZRem(ctx, s.cellKey(location.Geohash), sessionID)
 	}
 
 	// Delete location
@@ -181,18 +212,16 @@ func (s *Service) DeleteLocation(ctx context.Context, sessionID string) error {
 	return s.redis.Del(ctx, key)
 }
 
+// CleanupStaleLocations trims members not updated within the location TTL
+// from every cell. Redis deletes cells left empty.
 func (s *Service) CleanupStaleLocations(ctx context.Context) error {
-	// This is handled automatically by Redis TTL
-	// But we can explicitly clean up geohash indices
-	pattern := "geohash:*"
+	max := "(" + strconv.FormatInt(time.Now().Add(-locationTTL).UnixMilli(), 10)
+	pattern := s.redis.Keys().Key("cell", "*")
 	iter := s.redis.Scan(ctx, 0, pattern, 100).Iterator()
 
 	for iter.Next(ctx) {
-		key := iter.Val()
-		// Check if set is empty
-		count, err := s.redis.SCard(ctx, key)
-		if err != nil || count == 0 {
-			s.redis.Del(ctx, key)
+		if err := s.redis.ZRemRangeByScore(ctx, iter.Val(), "-inf", max); err != nil {
+			return fmt.Errorf("failed to trim %s: %w", iter.Val(), err)
 		}
 	}
 
@@ -200,21 +229,83 @@ func (s *Service) CleanupStaleLocations(ctx context.Context) error {
 }
 
 func (s *Service) getGeohashesInRadius(geohash string) []string {
-	geohashes := []string{geohash}
-	neighbors := GetNeighbors(geohash)
-	geohashes = append(geohashes, neighbors...)
-	return geohashes
+	return CoverageCells(geohash)
+}
+
+// getUsersInGeohashes returns the distinct members of the cells updated
+// within the location TTL, read in one pipeline.
+func (s *Service) getUsersInGeohashes(ctx context.Context, geohashes []string) ([]string, error) {
+	fresh := &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-locationTTL).UnixMilli(), 10),
+		Max: "+inf",
+	}
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(geohashes))
+	for i, gh := range geohashes {
+		cmds[i] = pipe.ZRangeByScore(ctx, s.cellKey(gh), fresh)
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get geohash members: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	members := make([]string, 0)
+	for _, cmd := range cmds {
+		for _, id := range cmd.Val() {
+			if !seen[id] {
+				seen[id] = true
+				members = append(members, id)
+			}
+		}
+	}
+	return members, nil
 }
 
-func (s *Service) getUsersInGeohash(ctx context.Context, geohash string) ([]string, error) {
-	key := s.geohashKey(geohash)
-	return s.redis.SMembers(ctx, key)
+// getLocations fetches the sessions' locations with one MGET. Sessions whose
+// location has expired are left out.
+func (s *Service) getLocations(ctx context.Context, sessionIDs []string) ([]*Location, error) {
+	locations := make([]*Location, 0, len(sessionIDs))
+	if len(sessionIDs) == 0 {
+		return locations, nil
+	}
+
+	keys := make([]string, len(sessionIDs))
+	for i, id := range sessionIDs {
+		keys[i] = s.locationKey(id)
+	}
+	values, err := s.redis.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get locations: %w", err)
+	}
+
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var location Location
+		if err := json.Unmarshal([]byte(data), &location); err != nil {
+			continue
+		}
+		locations = append(locations, &location)
+	}
+	return locations, nil
 }
 
+// locationKey is tagged with the session ID, keeping a session's location on
+// one slot however it is keyed elsewhere. It cannot share a slot with its
+// cell's index: locations are read by session ID alone, without knowing the
+// cell, and a cell's index holds every session in it. UpdateLocation writes
+// both in a pipeline instead, which Redis Cluster splits by node.
 func (s *Service) locationKey(sessionID string) string {
-	return fmt.Sprintf("location:%s", sessionID)
+	return s.redis.Keys().Key("location", storage.HashTag(sessionID))
 }
 
-func (s *Service) geohashKey(geohash string) string {
-	return fmt.Sprintf("geohash:%s", geohash)
+// cellKey is a cell's index: a sorted set of its sessions scored by their
+// last update in Unix milliseconds. Cells with the same parent share a slot;
+// nearby queries read the indexes in a pipeline, which Redis Cluster splits
+// by node when neighbours cross a parent's edge.
+func (s *Service) cellKey(geohash string) string {
+	return s.redis.Keys().Key("cell", storage.CellTag(geohash), geohash)
 }
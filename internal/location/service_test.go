@@ -0,0 +1,210 @@
+package location
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/redis/go-redis/v9"
+)
+
+// Points around San Francisco. near is about 100m from origin, in the same
+// cell; far is about 5km away.
+var (
+	origin = [2]float64{37.7749, -122.4194}
+	near   = [2]float64{37.7758, -122.4194}
+	far    = [2]float64{37.8199, -122.4194}
+)
+
+func noUsernames(ids []string) map[string]string {
+	usernames := make(map[string]string, len(ids))
+	for _, id := range ids {
+		usernames[id] = "user-" + id
+	}
+	return usernames
+}
+
+func nearbyIDs(t *testing.T, s LocationService, sessionID string) []string {
+	t.Helper()
+
+	users, err := s.GetNearbyUsers(context.Background(), sessionID, noUsernames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.SessionID
+		if user.Username != "user-"+user.SessionID {
+			t.Errorf("%s has username %q", user.SessionID, user.Username)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// backends runs a test against both location backends.
+func backends(t *testing.T, test func(t *testing.T, s LocationService, redisClient *storage.MemoryClient)) {
+	t.Run("geohash", func(t *testing.T) {
+		redisClient := storagetest.NewClient(t)
+		test(t, NewService(redisClient, 6, 100, 2000), redisClient)
+	})
+	t.Run("geo", func(t *testing.T) {
+		redisClient := storagetest.NewClient(t)
+		test(t, NewGeoService(redisClient, 6, 100, 2000), redisClient)
+	})
+}
+
+func TestGetNearbyUsers(t *testing.T) {
+	backends(t, func(t *testing.T, s LocationService, _ *storage.MemoryClient) {
+		ctx := context.Background()
+
+		updates := []struct {
+			sessionID string
+			point     [2]float64
+			radius    int
+		}{
+			{"me", origin, 1000},
+			{"neighbour", near, 1000},
+			{"stranger", far, 1000},
+		}
+		for _, u := range updates {
+			if err := s.UpdateLocation(ctx, u.sessionID, u.point[0], u.point[1], u.radius); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := nearbyIDs(t, s, "me"); strings.Join(got, ",") != "neighbour" {
+			t.Errorf("nearby = %v, want [neighbour]", got)
+		}
+
+		users, _ := s.GetNearbyUsers(ctx, "me", noUsernames)
+		if len(users) == 1 && users[0].Distance != 100 {
+			t.Errorf("distance = %d, want 100", users[0].Distance)
+		}
+	})
+}
+
+func TestUpdateLocationRadius(t *testing.T) {
+	backends(t, func(t *testing.T, s LocationService, _ *storage.MemoryClient) {
+		tests := []struct {
+			radius  int
+			wantErr bool
+		}{
+			{99, true},
+			{100, false},
+			{2000, false},
+			{2001, true},
+		}
+
+		for _, tt := range tests {
+			err := s.UpdateLocation(context.Background(), "me", origin[0], origin[1], tt.radius)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("radius %d: err = %v, want error %v", tt.radius, err, tt.wantErr)
+			}
+		}
+	})
+}
+
+func TestMoveLeavesOldCell(t *testing.T) {
+	backends(t, func(t *testing.T, s LocationService, _ *storage.MemoryClient) {
+		ctx := context.Background()
+
+		s.UpdateLocation(ctx, "me", origin[0], origin[1], 1000)
+		s.UpdateLocation(ctx, "mover", near[0], near[1], 1000)
+		if got := nearbyIDs(t, s, "me"); len(got) != 1 {
+			t.Fatalf("nearby = %v before the move, want [mover]", got)
+		}
+
+		s.UpdateLocation(ctx, "mover", far[0], far[1], 1000)
+		if got := nearbyIDs(t, s, "me"); len(got) != 0 {
+			t.Errorf("nearby = %v after the move, want none", got)
+		}
+	})
+}
+
+func TestDeleteLocation(t *testing.T) {
+	backends(t, func(t *testing.T, s LocationService, _ *storage.MemoryClient) {
+		ctx := context.Background()
+
+		s.UpdateLocation(ctx, "me", origin[0], origin[1], 1000)
+		s.UpdateLocation(ctx, "leaver", near[0], near[1], 1000)
+		if err := s.DeleteLocation(ctx, "leaver"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := nearbyIDs(t, s, "me"); len(got) != 0 {
+			t.Errorf("nearby = %v, want none", got)
+		}
+		if _, err := s.GetLocation(ctx, "leaver"); err == nil {
+			t.Error("deleted location still readable")
+		}
+	})
+}
+
+// TestExpiredLocationsSkipped checks sessions whose location expired are
+// left out of nearby queries even while still indexed.
+func TestExpiredLocationsSkipped(t *testing.T) {
+	backends(t, func(t *testing.T, s LocationService, redisClient *storage.MemoryClient) {
+		ctx := context.Background()
+
+		s.UpdateLocation(ctx, "me", origin[0], origin[1], 1000)
+		s.UpdateLocation(ctx, "gone", near[0], near[1], 1000)
+		redisClient.Del(ctx, redisClient.Keys().Key("location", storage.HashTag("gone")))
+
+		if got := nearbyIDs(t, s, "me"); len(got) != 0 {
+			t.Errorf("nearby = %v, want none", got)
+		}
+	})
+}
+
+func TestCleanupStaleLocations(t *testing.T) {
+	ctx := context.Background()
+	redisClient := storagetest.NewClient(t)
+	s := NewService(redisClient, 6, 100, 2000)
+
+	s.UpdateLocation(ctx, "fresh", origin[0], origin[1], 1000)
+	cell := s.cellKey(Encode(origin[0], origin[1], 6))
+	stale := float64(time.Now().Add(-2 * locationTTL).UnixMilli())
+	redisClient.ZAdd(ctx, cell, &redis.Z{Score: stale, Member: "stale"})
+
+	if err := s.CleanupStaleLocations(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := redisClient.ZRangeByScore(ctx, cell, &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(members, ",") != "fresh" {
+		t.Errorf("cell members = %v, want [fresh]", members)
+	}
+}
+
+func TestGeoCleanupStaleLocations(t *testing.T) {
+	ctx := context.Background()
+	redisClient := storagetest.NewClient(t)
+	s := NewGeoService(redisClient, 6, 100, 2000)
+
+	s.UpdateLocation(ctx, "fresh", origin[0], origin[1], 1000)
+	s.UpdateLocation(ctx, "stale", near[0], near[1], 1000)
+	staleScore := float64(time.Now().Add(-2 * locationTTL).UnixMilli())
+	redisClient.ZAdd(ctx, s.geoFreshnessKey(), &redis.Z{Score: staleScore, Member: "stale"})
+
+	if err := s.CleanupStaleLocations(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{s.geoIndexKey(), s.geoFreshnessKey()} {
+		members, err := redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(members, ",") != "fresh" {
+			t.Errorf("%s members = %v, want [fresh]", key, members)
+		}
+	}
+}
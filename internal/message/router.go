@@ -73,5 +73,5 @@ func (r *Router) Subscribe(ctx context.Context, geohash string, handler func(*Me
 }
 
 func (r *Router) channelName(geohash string) string {
-	return fmt.Sprintf("chat:%s", geohash)
+	return r.redis.Keys().Key("chat", geohash)
 }
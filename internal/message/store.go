@@ -104,7 +104,7 @@ func (s *Store) GetRecent(ctx context.Context, geohash string, limit int) ([]*Me
 }
 
 func (s *Store) CleanupExpired(ctx context.Context) error {
-	pattern := "messages:*"
+	pattern := s.redis.Keys().Key("messages", "*")
 	iter := s.redis.Scan(ctx, 0, pattern, 100).Iterator()
 
 	now := time.Now().Unix()
@@ -129,5 +129,5 @@ func (s *Store) CleanupExpired(ctx context.Context) error {
 }
 
 func (s *Store) messageKey(geohash string) string {
-	return fmt.Sprintf("messages:%s", geohash)
+	return s.redis.Keys().Key("messages", geohash)
 }
@@ -0,0 +1,103 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+func newTestStore(t *testing.T, ttl time.Duration) (*Store, *storage.MemoryClient) {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	return NewStore(redisClient, ttl), redisClient
+}
+
+func TestGetRecent(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, time.Hour)
+
+	now := time.Now()
+	messages := []*Message{
+		{Content: "oldest", Geohash: "9q8yy", Timestamp: now.Add(-3 * time.Second)},
+		{Content: "older", Geohash: "9q8yy", Timestamp: now.Add(-2 * time.Second)},
+		{Content: "newest", Geohash: "9q8yy", Timestamp: now.Add(-time.Second)},
+		{Content: "elsewhere", Geohash: "u4pru", Timestamp: now},
+		{Content: "expired", Geohash: "9q8yy", Timestamp: now.Add(-4 * time.Second), ExpiresAt: now.Add(-time.Second)},
+	}
+	for _, msg := range messages {
+		if err := s.Save(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		geohash string
+		limit   int
+		want    []string
+	}{
+		{"newest first", "9q8yy", 10, []string{"newest", "older", "oldest"}},
+		{"limited", "9q8yy", 2, []string{"newest", "older"}},
+		{"other cell", "u4pru", 10, []string{"elsewhere"}},
+		{"empty cell", "gcpvj", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetRecent(ctx, tt.geohash, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(got), len(tt.want))
+			}
+			for i, msg := range got {
+				if msg.Content != tt.want[i] {
+					t.Errorf("message %d = %q, want %q", i, msg.Content, tt.want[i])
+				}
+				if msg.ID == "" {
+					t.Errorf("message %d has no ID", i)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupExpired(t *testing.T) {
+	ctx := context.Background()
+	s, redisClient := newTestStore(t, time.Minute)
+
+	now := time.Now()
+	s.Save(ctx, &Message{Content: "stale", Geohash: "9q8yy", Timestamp: now.Add(-2 * time.Minute)})
+	s.Save(ctx, &Message{Content: "fresh", Geohash: "9q8yy", Timestamp: now})
+	s.Save(ctx, &Message{Content: "stale", Geohash: "u4pru", Timestamp: now.Add(-2 * time.Minute)})
+
+	if err := s.CleanupExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want int64
+	}{
+		{s.messageKey("9q8yy"), 1},
+		// Cells left empty are deleted
+		{s.messageKey("u4pru"), 0},
+	}
+	for _, tt := range tests {
+		count, err := redisClient.ZCard(ctx, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tt.want {
+			t.Errorf("%s has %d messages, want %d", tt.key, count, tt.want)
+		}
+	}
+	if exists, _ := redisClient.Exists(ctx, s.messageKey("u4pru")); exists != 0 {
+		t.Error("emptied cell still exists")
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// AreaRefusal says why an area check refused a message.
+type AreaRefusal string
+
+const (
+	// AreaCeiling means a cell the message would reach is at its message
+	// ceiling
+	AreaCeiling AreaRefusal = "ceiling"
+	// AreaSlowMode means the sender must wait out the slow mode interval
+	AreaSlowMode AreaRefusal = "slow_mode"
+)
+
+// AreaResult is the outcome of an area check.
+type AreaResult struct {
+	Allowed bool
+	Refusal AreaRefusal
+	// RetryAfter is how long until the sender may try again
+	RetryAfter time.Duration
+	// SlowMode is the interval between one sender's messages in the sender's
+	// area, zero when slow mode is off
+	SlowMode time.Duration
+	// SlowModeChanged reports that this check turned slow mode on or off in
+	// the sender's cell, or changed its interval
+	SlowModeChanged bool
+}
+
+// AllowAreaMessage checks a message from a session against the message
+// ceiling of every cell it reaches (see location.CoverageCells) and the
+// slow mode of the busiest of them. The sender's own cell comes first.
+// Area limits protect busy areas rather than individual sessions, so while
+// Redis is unavailable every message is allowed.
+func (l *Limiter) AllowAreaMessage(ctx context.Context, sessionID string, cells []string) (*AreaResult, error) {
+	if l.config.AreaMessagesPerMin <= 0 || len(cells) == 0 || l.degraded.Load() {
+		return &AreaResult{Allowed: true}, nil
+	}
+
+	// Each cell keeps one window under its own tag, whichever cells send
+	// into it. The sender's slow mode follows the session, not its cell.
+	keys := areaKeys{
+		sender: l.redis.Keys().Key("ratelimit", "slowmode", "session", sessionID),
+		state:  l.redis.Keys().Key("ratelimit", storage.CellTag(cells[0]), "slowmode", "area", cells[0]),
+		cells:  make([]string, len(cells)),
+	}
+	for i, cell := range cells {
+		keys.cells[i] = l.redis.Keys().Key("ratelimit", storage.CellTag(cell), "cell", cell)
+	}
+
+	var (
+		area *AreaResult
+		err  error
+	)
+	if l.redis.Clustered() {
+		area, err = l.checkAreaSplit(ctx, keys)
+	} else {
+		area, err = l.checkArea(ctx, keys)
+	}
+	if err != nil {
+		if ctx.Err() != nil || !storage.Unavailable(err) {
+			return nil, err
+		}
+		l.degrade(err)
+		return &AreaResult{Allowed: true}, nil
+	}
+	return area, nil
+}
+
+// areaKeys are the keys of one area check.
+type areaKeys struct {
+	// sender is the sender's slow mode key
+	sender string
+	// state is the slow mode state of the sender's cell
+	state string
+	// cells are the windows of the cells the message reaches
+	cells []string
+}
+
+// checkArea runs an area check as one script, so concurrent messages cannot
+// pass a ceiling together.
+func (l *Limiter) checkArea(ctx context.Context, keys areaKeys) (*AreaResult, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s", now, uuid.NewString())
+	result, err := l.redis.RunScript(ctx, areaScript, append([]string{keys.sender, keys.state}, keys.cells...),
+		now,
+		l.areaWindow.Milliseconds(),
+		l.config.AreaMessagesPerMin,
+		member,
+		l.config.SlowModeThreshold,
+		l.config.SlowModeMinInterval.Milliseconds(),
+		l.config.SlowModeMaxInterval.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := int64s(result)
+	if err != nil || len(values) != 5 {
+		return nil, fmt.Errorf("unexpected area rate limit result %v", result)
+	}
+
+	area := &AreaResult{
+		Allowed:         values[0] == 1,
+		RetryAfter:      time.Duration(values[2]) * time.Millisecond,
+		SlowMode:        time.Duration(values[3]) * time.Millisecond,
+		SlowModeChanged: values[4] == 1,
+	}
+	switch values[1] {
+	case 1:
+		area.Refusal = AreaCeiling
+	case 2:
+		area.Refusal = AreaSlowMode
+	}
+	return area, nil
+}
+
+// checkAreaSplit runs an area check in Redis Cluster, where the keys are in
+// several slots and no one script can use them all. It counts each cell,
+// sets slow mode and waits the sender, then adds the message to every cell.
+// Messages checked at the same moment may all pass a ceiling they reach
+// together, so a cell can briefly go over it by the messages in flight.
+func (l *Limiter) checkAreaSplit(ctx context.Context, keys areaKeys) (*AreaResult, error) {
+	now := time.Now().UnixMilli()
+	window := l.areaWindow.Milliseconds()
+	ceiling := l.config.AreaMessagesPerMin
+
+	var busiest, retry int64
+	for _, key := range keys.cells {
+		result, err := l.redis.RunScript(ctx, cellCountScript, []string{key}, now, window, ceiling)
+		if err != nil {
+			return nil, err
+		}
+		values, err := int64s(result)
+		if err != nil || len(values) != 2 {
+			return nil, fmt.Errorf("unexpected area rate limit result %v", result)
+		}
+		busiest = max(busiest, values[0])
+		retry = max(retry, values[1])
+	}
+
+	result, err := l.redis.RunScript(ctx, slowModeScript, []string{keys.state},
+		busiest,
+		window,
+		ceiling,
+		l.config.SlowModeThreshold,
+		l.config.SlowModeMinInterval.Milliseconds(),
+		l.config.SlowModeMaxInterval.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	values, err := int64s(result)
+	if err != nil || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected slow mode result %v", result)
+	}
+	area := &AreaResult{
+		SlowMode:        time.Duration(values[0]) * time.Millisecond,
+		SlowModeChanged: values[1] == 1,
+	}
+
+	if busiest >= int64(ceiling) {
+		area.Refusal = AreaCeiling
+		area.RetryAfter = time.Duration(retry) * time.Millisecond
+		return area, nil
+	}
+
+	if area.SlowMode > 0 {
+		result, err := l.redis.RunScript(ctx, senderSlowModeScript, []string{keys.sender}, area.SlowMode.Milliseconds())
+		if err != nil {
+			return nil, err
+		}
+		wait, ok := result.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected slow mode result %v", result)
+		}
+		if wait > 0 {
+			area.Refusal = AreaSlowMode
+			area.RetryAfter = time.Duration(wait) * time.Millisecond
+			return area, nil
+		}
+	}
+
+	member := fmt.Sprintf("%d-%s", now, uuid.NewString())
+	pipe := l.redis.Pipeline()
+	for _, key := range keys.cells {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now), Member: member})
+		pipe.Expire(ctx, key, l.areaWindow)
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	area.Allowed = true
+	return area, nil
+}
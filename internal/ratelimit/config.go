@@ -1,6 +1,10 @@
 package ratelimit
 
-import "github.com/askwhyharsh/neartalk/internal/config"
+import (
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+)
 
 func DefaultConfig() *config.RateLimitConfig {
 	return &config.RateLimitConfig{
@@ -8,7 +12,17 @@ func DefaultConfig() *config.RateLimitConfig {
 		LocationUpdatesPerMin: 6,
 		MaxUsernameChanges:    3,
 		SessionsPerIPPerHour:  10,
+		ReportsPerIPPerHour:   10,
 		RequestsPerMinute:     100,
 		ConcurrentConnections: 5,
+		MessageBurst:          5,
+		LocationBurst:         3,
+		RequestBurst:          20,
+		HealthCheckInterval:   5 * time.Second,
+		AreaMessagesPerMin:    120,
+		SlowModeThreshold:     60,
+		SlowModeMinInterval:   5 * time.Second,
+		SlowModeMaxInterval:   30 * time.Second,
+		IPv6PrefixBits:        64,
 	}
 }
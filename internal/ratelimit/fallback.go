@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+)
+
+// FailureMode is what an action's checks do while Redis is unavailable.
+type FailureMode string
+
+const (
+	// FailLocal enforces the limits in memory. Each server counts on its
+	// own, so the effective limit is multiplied by the number of servers
+	FailLocal FailureMode = "local"
+	// FailOpen allows every hit
+	FailOpen FailureMode = "open"
+	// FailClosed refuses every hit
+	FailClosed FailureMode = "closed"
+)
+
+func ParseFailureMode(s string) (FailureMode, error) {
+	switch mode := FailureMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case FailLocal, FailOpen, FailClosed:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid rate limit failure mode %q (want local, open or closed)", s)
+}
+
+// Fallback metrics, published through expvar.
+var (
+	fallbackActive      = expvar.NewInt("ratelimit_fallback_active")
+	fallbackActivations = expvar.NewInt("ratelimit_fallback_activations")
+	fallbackChecks      = expvar.NewMap("ratelimit_fallback_checks")
+)
+
+// fallback checks a limit according to the action's failure mode.
+func (l *Limiter) fallback(action Action, limit actionLimit) *Result {
+	mode := l.failureModes[action]
+	fallbackChecks.Add(string(action)+"_"+string(mode), 1)
+
+	switch mode {
+	case FailOpen:
+		return &Result{Allowed: true, Limit: limit.limit, Remaining: limit.limit}
+	case FailClosed:
+		// Nothing is allowed before the next health check
+		return &Result{
+			Allowed:    false,
+			Limit:      limit.limit,
+			RetryAfter: l.config.HealthCheckInterval,
+			Reset:      l.config.HealthCheckInterval,
+		}
+	}
+	return l.local.check(limit, 1, time.Now())
+}
+
+// degrade switches every check to the fallback after Redis is found
+// unavailable (see storage.Unavailable).
+func (l *Limiter) degrade(err error) {
+	if !l.degraded.CompareAndSwap(false, true) {
+		return
+	}
+
+	l.stateMu.Lock()
+	l.degradedErr = err
+	l.degradedAt = time.Now()
+	l.stateMu.Unlock()
+
+	fallbackActive.Set(1)
+	fallbackActivations.Add(1)
+
+	// Have Start report it and check Redis straight away
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start health-checks Redis while the limiter is degraded and switches back
+// to it once Redis answers, until ctx is cancelled.
+func (l *Limiter) Start(ctx context.Context, log logger.Logger) {
+	ticker := time.NewTicker(l.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	reported := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		if !l.degraded.Load() {
+			continue
+		}
+
+		l.stateMu.Lock()
+		err, since := l.degradedErr, l.degradedAt
+		l.stateMu.Unlock()
+
+		if !reported {
+			log.Warn("Redis unavailable, rate limits fall back to failure modes", "error", err)
+			reported = true
+		}
+
+		l.local.prune(time.Now())
+
+		pingCtx, cancel := context.WithTimeout(ctx, l.config.HealthCheckInterval)
+		err = l.redis.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		// Local counts are dropped: Redis still has its own
+		l.local.clear()
+		l.degraded.Store(false)
+		fallbackActive.Set(0)
+		reported = false
+		log.Info("Redis reachable again, rate limits switched back to Redis", "degraded_for", time.Since(since).Round(time.Second))
+	}
+}
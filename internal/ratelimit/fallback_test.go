@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// flakyClient is a memory client that can be taken down, failing scripts
+// and pings as an unreachable Redis would.
+type flakyClient struct {
+	*storage.MemoryClient
+	down atomic.Bool
+}
+
+var errConnRefused = errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+
+func (c *flakyClient) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	if c.down.Load() {
+		return nil, errConnRefused
+	}
+	return c.MemoryClient.RunScript(ctx, script, keys, args...)
+}
+
+func (c *flakyClient) Ping(ctx context.Context) error {
+	if c.down.Load() {
+		return errConnRefused
+	}
+	return c.MemoryClient.Ping(ctx)
+}
+
+func newFlakyLimiter(t *testing.T, modes map[string]string) (*Limiter, *flakyClient) {
+	t.Helper()
+
+	cfg := *DefaultConfig()
+	cfg.FailureModes = modes
+	cfg.HealthCheckInterval = 10 * time.Millisecond
+
+	redisClient := &flakyClient{MemoryClient: storagetest.NewClient(t)}
+	limiter, err := NewLimiter(redisClient, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter, redisClient
+}
+
+func TestFallbackModes(t *testing.T) {
+	tests := []struct {
+		mode FailureMode
+		// allowed is how many of 12 messages pass; the limit is 10
+		allowed int
+	}{
+		{FailLocal, 10},
+		{FailOpen, 12},
+		{FailClosed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			ctx := context.Background()
+			limiter, redisClient := newFlakyLimiter(t, map[string]string{"message": string(tt.mode)})
+			redisClient.down.Store(true)
+
+			activations := fallbackActivations.Value()
+			checks := fallbackCheckCount("message_" + string(tt.mode))
+
+			allowed := 0
+			for i := 0; i < 12; i++ {
+				result, err := limiter.Allow(ctx, ActionMessage, "s1")
+				if err != nil {
+					t.Fatalf("message %d: %v", i+1, err)
+				}
+				if result.Allowed {
+					allowed++
+				} else if result.RetryAfter <= 0 {
+					t.Errorf("message %d refused with RetryAfter %s", i+1, result.RetryAfter)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("%d messages allowed, want %d", allowed, tt.allowed)
+			}
+
+			if !limiter.degraded.Load() {
+				t.Error("limiter not degraded while Redis is down")
+			}
+			if got := fallbackActive.Value(); got != 1 {
+				t.Errorf("ratelimit_fallback_active = %d, want 1", got)
+			}
+			if got := fallbackActivations.Value() - activations; got != 1 {
+				t.Errorf("%d fallback activations, want 1", got)
+			}
+			if got := fallbackCheckCount("message_"+string(tt.mode)) - checks; got != 12 {
+				t.Errorf("%d fallback checks counted, want 12", got)
+			}
+
+			// Other actions keep their own mode: username changes are local
+			if result, err := limiter.Allow(ctx, ActionUsername, "s1"); err != nil || !result.Allowed {
+				t.Errorf("username change while degraded: %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+// TestFallbackRecovery checks the health loop switches back to Redis once
+// it answers again, and drops the local counts.
+func TestFallbackRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limiter, redisClient := newFlakyLimiter(t, nil)
+	go limiter.Start(ctx, logger.Discard)
+
+	redisClient.down.Store(true)
+	for i := 0; i < 10; i++ {
+		limiter.Allow(ctx, ActionMessage, "s1")
+	}
+	if result, _ := limiter.Allow(ctx, ActionMessage, "s1"); result.Allowed {
+		t.Fatal("local limit not enforced while Redis is down")
+	}
+
+	// Still down: health checks keep the fallback
+	time.Sleep(50 * time.Millisecond)
+	if !limiter.degraded.Load() {
+		t.Fatal("limiter recovered while Redis is still down")
+	}
+
+	redisClient.down.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for limiter.degraded.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("limiter did not switch back to Redis")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fallbackActive.Value(); got != 0 {
+		t.Errorf("ratelimit_fallback_active = %d after recovery, want 0", got)
+	}
+
+	// Redis never saw the local hits, so the session starts afresh there
+	result, err := limiter.Allow(ctx, ActionMessage, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 9 {
+		t.Errorf("first message after recovery: %+v, want allowed with 9 remaining", result)
+	}
+	if n, _ := redisClient.ZCard(ctx, redisClient.Keys().Key("ratelimit", "msg", "s1")); n != 1 {
+		t.Errorf("%d hits in Redis after recovery, want 1", n)
+	}
+}
+
+// TestAreaFailsOpen checks area limits allow every message while Redis is
+// down.
+func TestAreaFailsOpen(t *testing.T) {
+	ctx := context.Background()
+	limiter, redisClient := newFlakyLimiter(t, nil)
+	limiter.config.AreaMessagesPerMin = 1
+	redisClient.down.Store(true)
+
+	for i := 0; i < 3; i++ {
+		area, err := limiter.AllowAreaMessage(ctx, "s1", []string{"9q8yyk"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !area.Allowed {
+			t.Errorf("message %d refused while Redis is down", i+1)
+		}
+	}
+	if !limiter.degraded.Load() {
+		t.Error("limiter not degraded by an unavailable area check")
+	}
+}
+
+func fallbackCheckCount(key string) int64 {
+	if v, ok := fallbackChecks.Get(key).(interface{ Value() int64 }); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHeaders describes a rate limit check in response headers:
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (seconds
+// until the full limit is available again), plus Retry-After (seconds) when
+// the request was limited. When the headers already describe an earlier
+// check, such as IPRateLimit's, they describe whichever is more restrictive:
+// a refusal, or else the check with fewer requests remaining.
+func SetHeaders(header http.Header, result *Result) {
+	if result.Allowed {
+		if remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil && remaining <= result.Remaining {
+			return
+		}
+	}
+
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	if !result.Allowed {
+		retryAfter := seconds(result.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+}
+
+// seconds rounds a duration up to whole seconds, so clients never retry too
+// early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
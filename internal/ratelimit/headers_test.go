@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSetHeadersMostRestrictive checks a second check only replaces the
+// headers of the first when it is more restrictive.
+func TestSetHeadersMostRestrictive(t *testing.T) {
+	first := &Result{Allowed: true, Limit: 100, Remaining: 50, Reset: 30 * time.Second}
+
+	tests := []struct {
+		name          string
+		second        *Result
+		wantLimit     string
+		wantRemaining string
+		wantRetry     string
+	}{
+		{"more remaining", &Result{Allowed: true, Limit: 10, Remaining: 60, Reset: time.Minute}, "100", "50", ""},
+		{"same remaining", &Result{Allowed: true, Limit: 10, Remaining: 50, Reset: time.Minute}, "100", "50", ""},
+		{"fewer remaining", &Result{Allowed: true, Limit: 10, Remaining: 9, Reset: time.Minute}, "10", "9", ""},
+		{"refused", &Result{Limit: 10, Remaining: 0, Reset: time.Minute, RetryAfter: 1500 * time.Millisecond}, "10", "0", "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			SetHeaders(header, first)
+			SetHeaders(header, tt.second)
+
+			for name, want := range map[string]string{
+				"X-RateLimit-Limit":     tt.wantLimit,
+				"X-RateLimit-Remaining": tt.wantRemaining,
+				"Retry-After":           tt.wantRetry,
+			} {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
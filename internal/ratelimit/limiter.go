@@ -3,11 +3,16 @@ package ratelimit
 import (
 	"context"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/askwhyharsh/neartalk/internal/clientip"
 	"github.com/askwhyharsh/neartalk/internal/config"
 	"github.com/askwhyharsh/neartalk/internal/storage"
-	"github.com/redis/go-redis/v9"
+	"github.com/google/uuid"
 )
 
 // RateLimiter defines the contract for enforcing and managing rate limits.
@@ -33,120 +38,456 @@ type RateLimiter interface {
 
 	// ResetLimits clears all rate limit counters for a session.
 	ResetLimits(ctx context.Context, sessionID string) error
+
+	// Allow checks a hit for an action by a session (or, for requests,
+	// session creation and reports, an IP) and returns the details,
+	// including how long until the next hit would be allowed.
+	Allow(ctx context.Context, action Action, id string) (*Result, error)
+
+	// AllowScaled is Allow with the session's reputation multiplier
+	// supplied by a caller that already looked it up.
+	AllowScaled(ctx context.Context, action Action, id string, multiplier float64) (*Result, error)
+}
+
+// Action is a rate-limited action. Messages, location updates and requests
+// use a configurable algorithm; username changes, session creation and
+// reports are counted in fixed windows.
+type Action string
+
+const (
+	ActionMessage  Action = "message"
+	ActionLocation Action = "location"
+	ActionRequest  Action = "request"
+	ActionUsername Action = "username"
+	ActionSession  Action = "session"
+	ActionReport   Action = "report"
+)
+
+// Algorithm is how an action's hits are limited.
+type Algorithm string
+
+const (
+	// AlgorithmSlidingWindow allows the per-minute limit in any 60 seconds
+	AlgorithmSlidingWindow Algorithm = "sliding_window"
+	// AlgorithmTokenBucket allows bursts up to the bucket size, refilled at
+	// the per-minute limit
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+)
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch algorithm := Algorithm(strings.ToLower(strings.TrimSpace(s))); algorithm {
+	case AlgorithmSlidingWindow, AlgorithmTokenBucket:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("invalid rate limit algorithm %q (want sliding_window or token_bucket)", s)
+}
+
+// Result is the outcome of a rate limit check.
+type Result struct {
+	Allowed bool
+	// Limit is the most hits allowed at once: the window limit or the
+	// bucket size
+	Limit int
+	// Remaining is how many more hits are allowed right now
+	Remaining int
+	// RetryAfter is how long until another hit is allowed; zero when
+	// Remaining is above zero
+	RetryAfter time.Duration
+	// Reset is how long until the full limit is available again
+	Reset time.Duration
+}
+
+// Reputation scales per-session limits: trusted sessions get more headroom,
+// distrusted ones less.
+type Reputation interface {
+	Multiplier(ctx context.Context, sessionID string) (float64, error)
 }
 
 type Limiter struct {
-	redis  storage.RedisClient
-	config config.RateLimitConfig
+	redis        storage.RedisClient
+	config       config.RateLimitConfig
+	reputation   Reputation
+	algorithms   map[Action]Algorithm
+	failureModes map[Action]FailureMode
+
+	// areaWindow is how long messages count toward area ceilings and slow
+	// mode
+	areaWindow time.Duration
+
+	// While degraded, checks skip Redis and use the local fallback until a
+	// health check succeeds
+	local       *localLimiter
+	degraded    atomic.Bool
+	degradedErr error
+	degradedAt  time.Time
+	stateMu     sync.Mutex
+	wake        chan struct{}
 }
 
-func NewLimiter(redisClient storage.RedisClient, config config.RateLimitConfig) *Limiter {
-	return &Limiter{
-		redis:  redisClient,
-		config: config,
+// algorithmFixedWindow counts hits in windows that start at the first hit.
+// It is used for username changes and session creation and cannot be
+// configured.
+const algorithmFixedWindow Algorithm = "fixed_window"
+
+// actionLimit is the key and limits for one check of an action.
+type actionLimit struct {
+	key       string
+	algorithm Algorithm
+	// limit is the hits allowed per window, or for token buckets the
+	// tokens added per minute
+	limit  int
+	burst  int
+	window time.Duration
+}
+
+// NewLimiter creates a limiter. reputation may be nil to apply the configured
+// limits to every session. Start must be running for the limiter to switch
+// back to Redis after falling back to local limits.
+func NewLimiter(redisClient storage.RedisClient, config config.RateLimitConfig, reputation Reputation) (*Limiter, error) {
+	algorithms := map[Action]Algorithm{
+		ActionMessage:  AlgorithmSlidingWindow,
+		ActionLocation: AlgorithmSlidingWindow,
+		ActionRequest:  AlgorithmSlidingWindow,
+	}
+	for name, value := range config.Algorithms {
+		action := Action(name)
+		if _, ok := algorithms[action]; !ok {
+			return nil, fmt.Errorf("unknown rate limited action %q", name)
+		}
+		algorithm, err := ParseAlgorithm(value)
+		if err != nil {
+			return nil, fmt.Errorf("action %s: %w", name, err)
+		}
+		algorithms[action] = algorithm
+	}
+
+	failureModes := map[Action]FailureMode{
+		ActionMessage:  FailLocal,
+		ActionLocation: FailLocal,
+		ActionRequest:  FailLocal,
+		ActionUsername: FailLocal,
+		ActionSession:  FailLocal,
+		ActionReport:   FailLocal,
 	}
+	for name, value := range config.FailureModes {
+		action := Action(name)
+		if _, ok := failureModes[action]; !ok {
+			return nil, fmt.Errorf("unknown rate limited action %q", name)
+		}
+		mode, err := ParseFailureMode(value)
+		if err != nil {
+			return nil, fmt.Errorf("action %s: %w", name, err)
+		}
+		failureModes[action] = mode
+	}
+
+	if config.IPv6PrefixBits < 1 || config.IPv6PrefixBits > 128 {
+		return nil, fmt.Errorf("IPv6 rate limit prefix must be between 1 and 128 bits, got %d", config.IPv6PrefixBits)
+	}
+
+	if config.HealthCheckInterval <= 0 {
+		return nil, fmt.Errorf("rate limit health check interval must be positive, got %s", config.HealthCheckInterval)
+	}
+
+	return &Limiter{
+		redis:        redisClient,
+		config:       config,
+		reputation:   reputation,
+		algorithms:   algorithms,
+		failureModes: failureModes,
+		areaWindow:   time.Minute,
+		local:        newLocalLimiter(),
+		wake:         make(chan struct{}, 1),
+	}, nil
 }
 
 // AllowMessage checks if a session can send a message
 func (l *Limiter) AllowMessage(ctx context.Context, sessionID string) (bool, error) {
-	key := fmt.Sprintf("ratelimit:msg:%s", sessionID)
-	return l.checkSlidingWindow(ctx, key, l.config.MessagesPerMin, 60)
+	return allowed(l.Allow(ctx, ActionMessage, sessionID))
 }
 
 // AllowLocationUpdate checks if a session can update location
 func (l *Limiter) AllowLocationUpdate(ctx context.Context, sessionID string) (bool, error) {
-	key := fmt.Sprintf("ratelimit:location:%s", sessionID)
-	return l.checkSlidingWindow(ctx, key, l.config.LocationUpdatesPerMin, 60)
+	return allowed(l.Allow(ctx, ActionLocation, sessionID))
 }
 
-// AllowUsernameChange checks if a session can change username
-func (l *Limiter) AllowUsernameChange(ctx context.Context, sessionID string) (bool, int, error) {
-	key := fmt.Sprintf("ratelimit:username:%s", sessionID)
+// Allow checks a hit for an action with the action's configured algorithm.
+// If Redis is unavailable, the check and every check after it use the
+// action's failure mode until Redis is healthy again.
+func (l *Limiter) Allow(ctx context.Context, action Action, id string) (*Result, error) {
+	multiplier := 1.0
+	if action == ActionMessage || action == ActionLocation {
+		multiplier = l.multiplier(ctx, id)
+	}
+	return l.AllowScaled(ctx, action, id, multiplier)
+}
 
-	count, err := l.redis.Incr(ctx, key)
+// AllowScaled is Allow with a known reputation multiplier, which scales
+// message and location limits only.
+func (l *Limiter) AllowScaled(ctx context.Context, action Action, id string, multiplier float64) (*Result, error) {
+	limit, err := l.limitFor(action, id, multiplier)
 	if err != nil {
-		return false, 0, fmt.Errorf("failed to check username rate limit: %w", err)
+		return nil, err
 	}
 
-	// Set expiration on first increment (24 hours)
-	if count == 1 {
-		l.redis.Expire(ctx, key, 24*time.Hour)
+	if !l.degraded.Load() {
+		result, err := l.check(ctx, limit)
+		// A cancelled request says nothing about Redis, and neither does a
+		// command Redis refused
+		if err == nil || ctx.Err() != nil || !storage.Unavailable(err) {
+			return result, err
+		}
+		l.degrade(err)
 	}
 
-	remaining := l.config.MaxUsernameChanges - int(count) + 1
-	if remaining < 0 {
-		remaining = 0
+	return l.fallback(action, limit), nil
+}
+
+// check runs a limit against Redis.
+func (l *Limiter) check(ctx context.Context, limit actionLimit) (*Result, error) {
+	switch limit.algorithm {
+	case AlgorithmTokenBucket:
+		return l.takeToken(ctx, limit.key, limit.limit, limit.burst, 1)
+	case algorithmFixedWindow:
+		return l.checkFixedWindow(ctx, limit.key, limit.limit, limit.window)
 	}
+	return l.checkSlidingWindow(ctx, limit.key, limit.limit, limit.window)
+}
 
-	return count <= int64(l.config.MaxUsernameChanges), remaining, nil
+// limitFor returns the key and limits for an action. Session actions are
+// scaled by the session's reputation multiplier.
+func (l *Limiter) limitFor(action Action, id string, multiplier float64) (actionLimit, error) {
+	switch action {
+	case ActionMessage:
+		return l.configuredLimit(action, l.redis.Keys().Key("ratelimit", "msg", id),
+			scaleLimit(l.config.MessagesPerMin, multiplier),
+			scaleLimit(l.config.MessageBurst, multiplier)), nil
+	case ActionLocation:
+		return l.configuredLimit(action, l.redis.Keys().Key("ratelimit", "location", id),
+			scaleLimit(l.config.LocationUpdatesPerMin, multiplier),
+			scaleLimit(l.config.LocationBurst, multiplier)), nil
+	case ActionRequest:
+		id = clientip.Aggregate(id, l.config.IPv6PrefixBits)
+		return l.configuredLimit(action, l.redis.Keys().Key("ratelimit", "ip", id, "requests"),
+			l.config.RequestsPerMinute, l.config.RequestBurst), nil
+	case ActionUsername:
+		return actionLimit{
+			key:       l.redis.Keys().Key("ratelimit", "username", id),
+			algorithm: algorithmFixedWindow,
+			limit:     l.config.MaxUsernameChanges,
+			window:    24 * time.Hour,
+		}, nil
+	case ActionSession:
+		id = clientip.Aggregate(id, l.config.IPv6PrefixBits)
+		return actionLimit{
+			key:       l.redis.Keys().Key("ratelimit", "ip", id, "sessions"),
+			algorithm: algorithmFixedWindow,
+			limit:     l.config.SessionsPerIPPerHour,
+			window:    time.Hour,
+		}, nil
+	case ActionReport:
+		id = clientip.Aggregate(id, l.config.IPv6PrefixBits)
+		return actionLimit{
+			key:       l.redis.Keys().Key("ratelimit", "ip", id, "reports"),
+			algorithm: algorithmFixedWindow,
+			limit:     l.config.ReportsPerIPPerHour,
+			window:    time.Hour,
+		}, nil
+	}
+	return actionLimit{}, fmt.Errorf("unknown rate limited action %q", action)
 }
 
-// AllowSessionCreation checks if an IP can create a new session
-func (l *Limiter) AllowSessionCreation(ctx context.Context, ip string) (bool, error) {
-	key := fmt.Sprintf("ratelimit:ip:%s:sessions", ip)
+// configuredLimit builds the limit for an action with a configurable
+// algorithm. Token buckets live under their own keys, so switching
+// algorithms never reads the other algorithm's data.
+func (l *Limiter) configuredLimit(action Action, key string, perMinute, burst int) actionLimit {
+	if l.algorithms[action] == AlgorithmTokenBucket {
+		return actionLimit{
+			key:       key + ":bucket",
+			algorithm: AlgorithmTokenBucket,
+			limit:     perMinute,
+			burst:     burst,
+			window:    time.Minute,
+		}
+	}
+	return actionLimit{
+		key:       key,
+		algorithm: AlgorithmSlidingWindow,
+		limit:     perMinute,
+		window:    time.Minute,
+	}
+}
 
-	count, err := l.redis.Incr(ctx, key)
+// multiplier returns the session's reputation multiplier. Reputation errors
+// fall back to the configured limits, and reputation is not looked up at all
+// while Redis is unavailable.
+func (l *Limiter) multiplier(ctx context.Context, sessionID string) float64 {
+	if l.reputation == nil || l.degraded.Load() {
+		return 1
+	}
+
+	multiplier, err := l.reputation.Multiplier(ctx, sessionID)
 	if err != nil {
-		return false, fmt.Errorf("failed to check session creation rate limit: %w", err)
+		return 1
 	}
+	return multiplier
+}
 
-	// Set expiration on first increment (1 hour)
-	if count == 1 {
-		l.redis.Expire(ctx, key, time.Hour)
+// scaleLimit scales a configured limit, never going below one.
+func scaleLimit(limit int, multiplier float64) int {
+	scaled := int(math.Round(float64(limit) * multiplier))
+	if scaled < 1 {
+		scaled = 1
 	}
+	return scaled
+}
 
-	return count <= int64(l.config.SessionsPerIPPerHour), nil
+func allowed(result *Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+// AllowUsernameChange checks if a session can change username. Counts reset
+// 24 hours after the first change.
+func (l *Limiter) AllowUsernameChange(ctx context.Context, sessionID string) (bool, int, error) {
+	result, err := l.Allow(ctx, ActionUsername, sessionID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to check username rate limit: %w", err)
+	}
+	return result.Allowed, result.Remaining, nil
+}
+
+// AllowSessionCreation checks if an IP can create a new session. Counts reset
+// an hour after the first session.
+func (l *Limiter) AllowSessionCreation(ctx context.Context, ip string) (bool, error) {
+	result, err := l.Allow(ctx, ActionSession, ip)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session creation rate limit: %w", err)
+	}
+	return result.Allowed, nil
 }
 
 // AllowIPRequest checks if an IP can make a request
 func (l *Limiter) AllowIPRequest(ctx context.Context, ip string) (bool, error) {
-	key := fmt.Sprintf("ratelimit:ip:%s:requests", ip)
-	return l.checkSlidingWindow(ctx, key, l.config.RequestsPerMinute, 60)
+	return allowed(l.Allow(ctx, ActionRequest, ip))
 }
 
-// checkSlidingWindow implements a sliding window rate limiter using sorted sets
-func (l *Limiter) checkSlidingWindow(ctx context.Context, key string, maxCount int, windowSec int) (bool, error) {
-	now := time.Now().Unix()
-	windowStart := now - int64(windowSec)
+// checkSlidingWindow implements a sliding window rate limiter using sorted
+// sets. The check runs as one Lua script, so concurrent requests cannot race
+// past the limit, and every hit is a unique member scored in milliseconds, so
+// hits within the same second all count.
+func (l *Limiter) checkSlidingWindow(ctx context.Context, key string, maxCount int, window time.Duration) (*Result, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s", now, uuid.NewString())
+
+	result, err := l.redis.RunScript(ctx, slidingWindowScript, []string{key}, now, window.Milliseconds(), maxCount, member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+
+	values, err := int64s(result)
+	if err != nil || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", result)
+	}
 
-	// Remove old entries outside the window
-	if err := l.redis.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("%d", windowStart)); err != nil {
-		return false, fmt.Errorf("failed to clean old entries: %w", err)
+	remaining := maxCount - int(values[1])
+	if remaining < 0 {
+		remaining = 0
 	}
 
-	// Count entries in current window
-	count, err := l.redis.ZCard(ctx, key)
+	return &Result{
+		Allowed:    values[0] == 1,
+		Limit:      maxCount,
+		Remaining:  remaining,
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// checkFixedWindow counts a hit against a counter that resets window after
+// its first hit, allowing up to limit hits.
+func (l *Limiter) checkFixedWindow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	result, err := l.redis.RunScript(ctx, fixedWindowScript, []string{key}, window.Milliseconds())
 	if err != nil {
-		return false, fmt.Errorf("failed to count entries: %w", err)
+		return nil, err
 	}
 
-	if count >= int64(maxCount) {
-		return false, nil
+	values, err := int64s(result)
+	if err != nil || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", result)
 	}
 
-	// Add new entry
-	if err := l.redis.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(now),
-		Member: fmt.Sprintf("%d", now),
-	}); err != nil {
-		return false, fmt.Errorf("failed to add entry: %w", err)
+	count, reset := int(values[0]), time.Duration(values[1])*time.Millisecond
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
 	}
 
-	// Set expiration
-	l.redis.Expire(ctx, key, time.Duration(windowSec)*time.Second)
+	var retryAfter time.Duration
+	if remaining == 0 {
+		retryAfter = reset
+	}
 
-	return true, nil
+	return &Result{
+		Allowed:    count <= limit,
+		Limit:      limit,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+		Reset:      reset,
+	}, nil
+}
+
+// int64s converts an array reply from a Lua script.
+func int64s(result interface{}) ([]int64, error) {
+	items, ok := result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected array, got %T", result)
+	}
+
+	values := make([]int64, len(items))
+	for i, item := range items {
+		value, ok := item.(int64)
+		if !ok {
+			return nil, fmt.Errorf("expected integer, got %T", item)
+		}
+		values[i] = value
+	}
+	return values, nil
 }
 
 // GetRemainingMessages returns how many messages a session can still send
 func (l *Limiter) GetRemainingMessages(ctx context.Context, sessionID string) (int, error) {
-	key := fmt.Sprintf("ratelimit:msg:%s", sessionID)
-	count, err := l.redis.ZCard(ctx, key)
+	limit, err := l.limitFor(ActionMessage, sessionID, l.multiplier(ctx, sessionID))
 	if err != nil {
-		return l.config.MessagesPerMin, nil
+		return 0, err
+	}
+
+	if l.degraded.Load() {
+		return l.local.check(limit, 0, time.Now()).Remaining, nil
+	}
+
+	if limit.algorithm == AlgorithmTokenBucket {
+		// Taking no tokens just refills the bucket and reports its level
+		result, err := l.takeToken(ctx, limit.key, limit.limit, limit.burst, 0)
+		if err != nil {
+			return limit.burst, nil
+		}
+		return result.Remaining, nil
+	}
+
+	// Trim expired hits first so they do not count against the session
+	result, err := l.redis.RunScript(ctx, windowCountScript, []string{limit.key}, time.Now().UnixMilli(), limit.window.Milliseconds())
+	if err != nil {
+		return limit.limit, nil
+	}
+	count, ok := result.(int64)
+	if !ok {
+		return limit.limit, nil
 	}
 
-	remaining := l.config.MessagesPerMin - int(count)
+	remaining := limit.limit - int(count)
 	if remaining < 0 {
 		remaining = 0
 	}
@@ -157,11 +498,14 @@ func (l *Limiter) GetRemainingMessages(ctx context.Context, sessionID string) (i
 // ResetLimits resets all rate limits for a session (use with caution)
 func (l *Limiter) ResetLimits(ctx context.Context, sessionID string) error {
 	keys := []string{
-		fmt.Sprintf("ratelimit:msg:%s", sessionID),
-		fmt.Sprintf("ratelimit:location:%s", sessionID),
-		fmt.Sprintf("ratelimit:username:%s", sessionID),
+		l.redis.Keys().Key("ratelimit", "msg", sessionID),
+		l.redis.Keys().Key("ratelimit", "msg", sessionID, "bucket"),
+		l.redis.Keys().Key("ratelimit", "location", sessionID),
+		l.redis.Keys().Key("ratelimit", "location", sessionID, "bucket"),
+		l.redis.Keys().Key("ratelimit", "username", sessionID),
 	}
 
+	l.local.forget(keys...)
 	for _, key := range keys {
 		if err := l.redis.Del(ctx, key); err != nil {
 			return err
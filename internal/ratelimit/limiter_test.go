@@ -0,0 +1,389 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+type fixedReputation float64
+
+func (r fixedReputation) Multiplier(ctx context.Context, sessionID string) (float64, error) {
+	return float64(r), nil
+}
+
+func newTestLimiter(t *testing.T, cfg config.RateLimitConfig, reputation Reputation) *Limiter {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	limiter, err := NewLimiter(redisClient, cfg, reputation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter
+}
+
+func TestAllow(t *testing.T) {
+	tokenBucket := *DefaultConfig()
+	tokenBucket.Algorithms = map[string]string{"message": "token_bucket"}
+
+	tests := []struct {
+		name       string
+		config     config.RateLimitConfig
+		reputation Reputation
+		action     Action
+		// ids are used in turn for each hit, to check which share a limit
+		ids  []string
+		want int
+	}{
+		{"sliding window", *DefaultConfig(), nil, ActionMessage, []string{"s1"}, 10},
+		{"token bucket", tokenBucket, nil, ActionMessage, []string{"s1"}, 5},
+		{"location", *DefaultConfig(), nil, ActionLocation, []string{"s1"}, 6},
+		{"distrusted session", *DefaultConfig(), fixedReputation(0.5), ActionMessage, []string{"s1"}, 5},
+		{"trusted session", *DefaultConfig(), fixedReputation(2), ActionMessage, []string{"s1"}, 20},
+		{"username", *DefaultConfig(), nil, ActionUsername, []string{"s1"}, 3},
+		{"sessions per IP", *DefaultConfig(), nil, ActionSession, []string{"192.0.2.1"}, 10},
+		{"sessions per IPv6 prefix", *DefaultConfig(), nil, ActionSession,
+			[]string{"2001:db8::1", "2001:db8::2"}, 10},
+		{"reports per IP", *DefaultConfig(), nil, ActionReport, []string{"192.0.2.1"}, 10},
+		{"reports per IPv6 prefix", *DefaultConfig(), nil, ActionReport,
+			[]string{"2001:db8::1", "2001:db8::ffff"}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newTestLimiter(t, tt.config, tt.reputation)
+
+			for i := 0; i < tt.want; i++ {
+				result, err := limiter.Allow(ctx, tt.action, tt.ids[i%len(tt.ids)])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Allowed {
+					t.Fatalf("hit %d refused, want %d allowed", i+1, tt.want)
+				}
+				if result.Remaining != tt.want-i-1 {
+					t.Errorf("hit %d: %d remaining, want %d", i+1, result.Remaining, tt.want-i-1)
+				}
+			}
+
+			result, err := limiter.Allow(ctx, tt.action, tt.ids[tt.want%len(tt.ids)])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed {
+				t.Errorf("hit %d allowed, want %d allowed", tt.want+1, tt.want)
+			}
+			if result.RetryAfter <= 0 {
+				t.Errorf("refused with RetryAfter %s", result.RetryAfter)
+			}
+
+			// Another session or address has its own limit
+			result, err = limiter.Allow(ctx, tt.action, "198.51.100.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Error("limit shared with another id")
+			}
+		})
+	}
+}
+
+func TestAllowScaled(t *testing.T) {
+	ctx := context.Background()
+	// The limiter's own reputation is ignored when the caller supplies one
+	limiter := newTestLimiter(t, *DefaultConfig(), fixedReputation(2))
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.AllowScaled(ctx, ActionMessage, "s1", 0.3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Limit != 3 {
+			t.Fatalf("hit %d: %+v, want allowed with limit 3", i+1, result)
+		}
+	}
+	if result, _ := limiter.AllowScaled(ctx, ActionMessage, "s1", 0.3); result.Allowed {
+		t.Error("fourth hit allowed with a limit of 3")
+	}
+}
+
+func TestGetRemainingMessages(t *testing.T) {
+	tokenBucket := *DefaultConfig()
+	tokenBucket.Algorithms = map[string]string{"message": "token_bucket"}
+
+	tests := []struct {
+		name   string
+		config config.RateLimitConfig
+		sent   int
+		want   int
+	}{
+		{"sliding window unused", *DefaultConfig(), 0, 10},
+		{"sliding window", *DefaultConfig(), 4, 6},
+		{"token bucket unused", tokenBucket, 0, 5},
+		{"token bucket", tokenBucket, 4, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newTestLimiter(t, tt.config, nil)
+
+			for i := 0; i < tt.sent; i++ {
+				limiter.AllowMessage(ctx, "s1")
+			}
+			remaining, err := limiter.GetRemainingMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining != tt.want {
+				t.Errorf("%d remaining, want %d", remaining, tt.want)
+			}
+
+			if err := limiter.ResetLimits(ctx, "s1"); err != nil {
+				t.Fatal(err)
+			}
+			if remaining, _ := limiter.GetRemainingMessages(ctx, "s1"); remaining != tt.want+tt.sent {
+				t.Errorf("%d remaining after reset, want %d", remaining, tt.want+tt.sent)
+			}
+		})
+	}
+}
+
+func TestAllowAreaMessage(t *testing.T) {
+	cfg := *DefaultConfig()
+	cfg.AreaMessagesPerMin = 4
+	cfg.SlowModeThreshold = 2
+	cfg.SlowModeMinInterval = time.Second
+	cfg.SlowModeMaxInterval = 3 * time.Second
+
+	cells := []string{"9q8yyk", "9q8yym", "9q8yyj"}
+
+	tests := []struct {
+		sessionID string
+		want      AreaResult
+	}{
+		{"s1", AreaResult{Allowed: true}},
+		{"s2", AreaResult{Allowed: true, SlowMode: time.Second, SlowModeChanged: true}},
+		{"s2", AreaResult{Refusal: AreaSlowMode, SlowMode: 2 * time.Second, SlowModeChanged: true}},
+		{"s3", AreaResult{Allowed: true, SlowMode: 2 * time.Second}},
+		{"s4", AreaResult{Allowed: true, SlowMode: 3 * time.Second, SlowModeChanged: true}},
+		{"s5", AreaResult{Refusal: AreaCeiling, SlowMode: 3 * time.Second}},
+	}
+
+	for _, client := range areaClients {
+		t.Run(client.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newAreaLimiter(t, client.new(t), cfg)
+
+			for i, tt := range tests {
+				got, err := limiter.AllowAreaMessage(ctx, tt.sessionID, cells)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// RetryAfter depends on timing; only check it is set on refusals
+				if (got.RetryAfter > 0) != !tt.want.Allowed {
+					t.Errorf("message %d: RetryAfter %s", i+1, got.RetryAfter)
+				}
+				got.RetryAfter = 0
+				if *got != tt.want {
+					t.Errorf("message %d from %s: %+v, want %+v", i+1, tt.sessionID, *got, tt.want)
+				}
+			}
+
+			// A cell the busy area does not reach is unaffected
+			got, err := limiter.AllowAreaMessage(ctx, "s5", []string{"u4pruy"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Allowed {
+				t.Errorf("message to a quiet cell refused: %+v", *got)
+			}
+		})
+	}
+}
+
+// clusteredClient makes the limiter check areas as it does in Redis
+// Cluster.
+type clusteredClient struct {
+	*storage.MemoryClient
+}
+
+func (clusteredClient) Clustered() bool {
+	return true
+}
+
+// areaClients are the clients area checks run against: one script, and the
+// split check used in a cluster.
+var areaClients = []struct {
+	name string
+	new  func(t *testing.T) storage.RedisClient
+}{
+	{"single script", func(t *testing.T) storage.RedisClient { return storagetest.NewClient(t) }},
+	{"cluster", func(t *testing.T) storage.RedisClient { return clusteredClient{storagetest.NewClient(t)} }},
+}
+
+func newAreaLimiter(t *testing.T, redisClient storage.RedisClient, cfg config.RateLimitConfig) *Limiter {
+	t.Helper()
+
+	limiter, err := NewLimiter(redisClient, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter
+}
+
+// TestAreaCeilingAcrossParents checks a cell on its parent's edge takes no
+// more than its ceiling when the messages come from cells on either side.
+func TestAreaCeilingAcrossParents(t *testing.T) {
+	cfg := *DefaultConfig()
+	cfg.AreaMessagesPerMin = 3
+	cfg.SlowModeThreshold = 100
+
+	// 9q8yyz sits on its parent's edge; 9q8yzb and 9q8yzc are over it
+	senders := []struct {
+		sessionID string
+		cells     []string
+	}{
+		{"s1", []string{"9q8yyz", "9q8yyx"}},
+		{"s2", []string{"9q8yzb", "9q8yyz"}},
+		{"s3", []string{"9q8yzc", "9q8yyz"}},
+	}
+
+	for _, client := range areaClients {
+		t.Run(client.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newAreaLimiter(t, client.new(t), cfg)
+
+			for i, sender := range senders {
+				got, err := limiter.AllowAreaMessage(ctx, sender.sessionID, sender.cells)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got.Allowed {
+					t.Fatalf("message %d from %s refused: %+v", i+1, sender.sessionID, *got)
+				}
+			}
+
+			for _, sender := range senders {
+				got, err := limiter.AllowAreaMessage(ctx, sender.sessionID, sender.cells)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Allowed || got.Refusal != AreaCeiling || got.RetryAfter <= 0 {
+					t.Errorf("message from %s into a full cell: %+v, want refused by the ceiling", sender.sessionID, *got)
+				}
+			}
+
+			// 9q8yzb has only had one message
+			got, err := limiter.AllowAreaMessage(ctx, "s4", []string{"9q8yzb"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Allowed {
+				t.Errorf("message to a cell under its ceiling refused: %+v", *got)
+			}
+		})
+	}
+}
+
+// TestAreaSlowModeFollowsSender checks a sender moving over a parent cell's
+// edge still waits out slow mode.
+func TestAreaSlowModeFollowsSender(t *testing.T) {
+	cfg := *DefaultConfig()
+	cfg.AreaMessagesPerMin = 10
+	cfg.SlowModeThreshold = 1
+	cfg.SlowModeMinInterval = 5 * time.Second
+	cfg.SlowModeMaxInterval = 10 * time.Second
+
+	for _, client := range areaClients {
+		t.Run(client.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newAreaLimiter(t, client.new(t), cfg)
+
+			got, err := limiter.AllowAreaMessage(ctx, "s1", []string{"9q8yyz", "9q8yzb"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Allowed || got.SlowMode == 0 {
+				t.Fatalf("first message: %+v, want allowed in slow mode", *got)
+			}
+
+			got, err = limiter.AllowAreaMessage(ctx, "s1", []string{"9q8yzb", "9q8yyz"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Allowed || got.Refusal != AreaSlowMode {
+				t.Errorf("message after moving cells: %+v, want refused by slow mode", *got)
+			}
+		})
+	}
+}
+
+// TestAreaSlowModeEnds checks the first message after an area cools for a
+// window announces slow mode ending.
+func TestAreaSlowModeEnds(t *testing.T) {
+	cfg := *DefaultConfig()
+	cfg.AreaMessagesPerMin = 10
+	cfg.SlowModeThreshold = 2
+	cfg.SlowModeMinInterval = time.Second
+	cfg.SlowModeMaxInterval = time.Second
+
+	for _, client := range areaClients {
+		t.Run(client.name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := newAreaLimiter(t, client.new(t), cfg)
+			limiter.areaWindow = 50 * time.Millisecond
+
+			tests := []struct {
+				sessionID string
+				want      AreaResult
+			}{
+				{"s1", AreaResult{Allowed: true}},
+				{"s2", AreaResult{Allowed: true, SlowMode: time.Second, SlowModeChanged: true}},
+			}
+			for i, tt := range tests {
+				got, err := limiter.AllowAreaMessage(ctx, tt.sessionID, []string{"9q8yyk"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *got != tt.want {
+					t.Fatalf("message %d from %s: %+v, want %+v", i+1, tt.sessionID, *got, tt.want)
+				}
+			}
+
+			time.Sleep(2 * limiter.areaWindow)
+
+			got, err := limiter.AllowAreaMessage(ctx, "s3", []string{"9q8yyk"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (AreaResult{Allowed: true, SlowModeChanged: true}); *got != want {
+				t.Errorf("message after the area cooled: %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+// TestRefusedCommandKeepsRedis checks an error Redis replies with is
+// returned rather than switching every limit to the fallback.
+func TestRefusedCommandKeepsRedis(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestLimiter(t, *DefaultConfig(), nil)
+
+	// A string where the window's sorted set should be
+	limiter.redis.Set(ctx, limiter.redis.Keys().Key("ratelimit", "msg", "s1"), "x", 0)
+
+	if _, err := limiter.Allow(ctx, ActionMessage, "s1"); err == nil {
+		t.Fatal("Allow succeeded against a key of the wrong type")
+	}
+	if limiter.degraded.Load() {
+		t.Error("limiter degraded after a refused command")
+	}
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// localLimiter enforces limits in memory while Redis is unavailable, with the
+// same algorithms as the Redis scripts.
+type localLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*localEntry
+}
+
+// localEntry is the state behind one key. Each algorithm uses its own fields.
+type localEntry struct {
+	hits    []time.Time // sliding windows
+	tokens  float64     // token buckets
+	count   int         // fixed windows
+	updated time.Time
+	expires time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{entries: make(map[string]*localEntry)}
+}
+
+// check counts cost hits (0 or 1) against a limit. A cost of 0 only reports
+// the current state.
+func (m *localLimiter) check(limit actionLimit, cost int, now time.Time) *Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[limit.key]
+	if !ok || now.After(entry.expires) {
+		entry = &localEntry{tokens: float64(limit.burst), updated: now}
+		m.entries[limit.key] = entry
+	}
+
+	switch limit.algorithm {
+	case AlgorithmTokenBucket:
+		return entry.takeToken(limit, cost, now)
+	case algorithmFixedWindow:
+		return entry.fixedWindow(limit, cost, now)
+	}
+	return entry.slidingWindow(limit, cost, now)
+}
+
+func (e *localEntry) slidingWindow(limit actionLimit, cost int, now time.Time) *Result {
+	cutoff := now.Add(-limit.window)
+	for len(e.hits) > 0 && !e.hits[0].After(cutoff) {
+		e.hits = e.hits[1:]
+	}
+
+	allowed := len(e.hits)+cost <= limit.limit
+	if allowed && cost > 0 {
+		e.hits = append(e.hits, now)
+	}
+
+	result := &Result{Allowed: allowed, Limit: limit.limit}
+	result.Remaining = max(0, limit.limit-len(e.hits))
+	if len(e.hits) > 0 {
+		if result.Remaining == 0 {
+			result.RetryAfter = e.hits[0].Add(limit.window).Sub(now)
+		}
+		result.Reset = e.hits[len(e.hits)-1].Add(limit.window).Sub(now)
+	}
+	e.expires = now.Add(result.Reset)
+	return result
+}
+
+func (e *localEntry) takeToken(limit actionLimit, cost int, now time.Time) *Result {
+	interval := time.Minute / time.Duration(max(1, limit.limit))
+	if now.After(e.updated) {
+		e.tokens = math.Min(float64(limit.burst), e.tokens+float64(now.Sub(e.updated))/float64(interval))
+		e.updated = now
+	}
+
+	allowed := e.tokens >= float64(cost)
+	if allowed {
+		e.tokens -= float64(cost)
+	}
+
+	result := &Result{
+		Allowed:   allowed,
+		Limit:     limit.burst,
+		Remaining: int(e.tokens),
+		Reset:     time.Duration((float64(limit.burst) - e.tokens) * float64(interval)),
+	}
+	if e.tokens < 1 {
+		result.RetryAfter = time.Duration((1 - e.tokens) * float64(interval))
+	}
+	e.expires = now.Add(result.Reset)
+	return result
+}
+
+func (e *localEntry) fixedWindow(limit actionLimit, cost int, now time.Time) *Result {
+	if e.count == 0 {
+		e.expires = now.Add(limit.window)
+	}
+	e.count += cost
+
+	result := &Result{
+		Allowed:   e.count <= limit.limit,
+		Limit:     limit.limit,
+		Remaining: max(0, limit.limit-e.count),
+		Reset:     e.expires.Sub(now),
+	}
+	if result.Remaining == 0 {
+		result.RetryAfter = result.Reset
+	}
+	return result
+}
+
+// prune drops entries whose limits have fully reset.
+func (m *localLimiter) prune(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, entry := range m.entries {
+		if now.After(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+func (m *localLimiter) forget(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+}
+
+func (m *localLimiter) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = make(map[string]*localEntry)
+}
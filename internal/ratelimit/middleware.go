@@ -3,6 +3,7 @@ package ratelimit
 import (
 	"net/http"
 
+	"github.com/askwhyharsh/neartalk/internal/clientip"
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,9 +20,9 @@ func NewMiddleware(limiter *Limiter) *Middleware {
 // IPRateLimit middleware for general IP-based rate limiting
 func (m *Middleware) IPRateLimit() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
+		ip := clientip.FromContext(c)
 		
-		allowed, err := m.limiter.AllowIPRequest(c.Request.Context(), ip)
+		result, err := m.limiter.Allow(c.Request.Context(), ActionRequest, ip)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to check rate limit",
@@ -30,7 +31,8 @@ func (m *Middleware) IPRateLimit() gin.HandlerFunc {
 			return
 		}
 		
-		if !allowed {
+		SetHeaders(c.Writer.Header(), result)
+		if !result.Allowed {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "Rate limit exceeded. Please try again later.",
 				"code":  "RATE_LIMIT_IP",
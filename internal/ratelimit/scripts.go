@@ -0,0 +1,294 @@
+package ratelimit
+
+import "github.com/redis/go-redis/v9"
+
+// slidingWindowScript trims a sorted-set window and adds a hit if it is
+// under the limit, in one atomic step.
+//
+// KEYS[1] window key
+// ARGV[1] now in milliseconds
+// ARGV[2] window length in milliseconds
+// ARGV[3] limit
+// ARGV[4] unique member for this hit
+//
+// Returns {allowed (0 or 1), hits in the window, milliseconds until another
+// hit would be allowed (0 if one would be allowed now), milliseconds until the
+// window is empty}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+-- Milliseconds until the hit at index leaves the window
+local function leaves(index)
+	local hit = redis.call('ZRANGE', key, index, index, 'WITHSCORES')
+	if hit[2] then
+		return tonumber(hit[2]) + window - now
+	end
+	return 0
+end
+
+if count >= limit then
+	return {0, count, leaves(0), leaves(-1)}
+end
+
+redis.call('ZADD', key, now, ARGV[4])
+redis.call('PEXPIRE', key, window)
+count = count + 1
+
+local retry = 0
+if count >= limit then
+	retry = leaves(0)
+end
+return {1, count, retry, window}
+`)
+
+// windowCountScript trims a sorted-set window and returns how many hits are
+// left in it.
+//
+// KEYS[1] window key
+// ARGV[1] now in milliseconds
+// ARGV[2] window length in milliseconds
+var windowCountScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[1]) - tonumber(ARGV[2]))
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// fixedWindowScript increments a counter and starts its expiry on the first
+// hit, in one atomic step. A counter that somehow lost its expiry gets it
+// back, so it can never stick forever.
+//
+// KEYS[1] counter key
+// ARGV[1] window length in milliseconds
+//
+// Returns {the count including this hit, milliseconds until the counter
+// resets}.
+var fixedWindowScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if count == 1 or ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// tokenBucketScript refills a token bucket for the time since it was last
+// used and takes tokens from it if there are enough, in one atomic step. The
+// bucket is a hash of its token count and the time it was last refilled; a
+// missing bucket starts full.
+//
+// KEYS[1] bucket key
+// ARGV[1] now in milliseconds
+// ARGV[2] milliseconds per token
+// ARGV[3] bucket size
+// ARGV[4] tokens to take; 0 only refills the bucket
+//
+// Returns {allowed (0 or 1), whole tokens left, milliseconds until the next
+// token (0 if at least one is left), milliseconds until the bucket is full}.
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+-- Servers' clocks may disagree slightly; never refill for negative time
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / interval)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+local retry = 0
+if tokens < 1 then
+	retry = math.ceil((1 - tokens) * interval)
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', tostring(ts))
+-- A bucket left alone this long is full again, so it can go
+redis.call('PEXPIRE', key, math.ceil(burst * interval))
+return {allowed, math.floor(tokens), retry, math.ceil((burst - tokens) * interval)}
+`)
+
+// slowModeLua defines slow_mode_interval and set_slow_mode, shared by the
+// scripts that decide a cell's slow mode. The interval grows from the minimum
+// at the threshold to the maximum at the ceiling, in whole seconds so small
+// changes are not announced; it is 0 below the threshold. The state is kept
+// for slow_mode_state_windows windows after it is last set, so the first
+// message once the area cools still finds it and announces slow mode ending.
+const slowModeLua = `
+local slow_mode_state_windows = 60
+
+local function slow_mode_interval(busiest, ceiling, threshold, min_interval, max_interval)
+	if busiest + 1 < threshold then
+		return 0
+	end
+	local heat = 1
+	if ceiling > threshold then
+		heat = math.min(1, (busiest + 1 - threshold) / (ceiling - threshold))
+	end
+	local interval = min_interval + (max_interval - min_interval) * heat
+	return math.ceil(interval / 1000) * 1000
+end
+
+-- set_slow_mode stores a cell's slow mode interval and returns 1 if it
+-- changed
+local function set_slow_mode(key, interval, window)
+	local previous = tonumber(redis.call('GET', key) or '0')
+	if interval > 0 then
+		redis.call('SET', key, interval, 'PX', window * slow_mode_state_windows)
+	else
+		redis.call('DEL', key)
+	end
+	if previous ~= interval then
+		return 1
+	end
+	return 0
+end
+`
+
+// areaScript enforces the message ceiling and slow mode for the cells a
+// message reaches, in one atomic step. Each cell keeps a sliding window of
+// messages sent into it; the busiest cell decides. The keys are in several
+// slots in a cluster, where the limiter uses the scripts below instead.
+//
+// KEYS[1] sender's slow mode key, set for the interval after each message
+// KEYS[2] slow mode state key for the sender's own cell
+// KEYS[3...] window keys of the cells the message reaches
+// ARGV[1] now in milliseconds
+// ARGV[2] window length in milliseconds
+// ARGV[3] messages per window allowed into a cell
+// ARGV[4] unique member for this message
+// ARGV[5] messages per window at which slow mode starts
+// ARGV[6] slow mode interval at the threshold, in milliseconds
+// ARGV[7] slow mode interval at the ceiling, in milliseconds
+//
+// Returns {allowed (0 or 1), 1 if refused by the ceiling or 2 by slow mode,
+// milliseconds until the sender may try again, slow mode interval in
+// milliseconds (0 when off), 1 if the sender's cell changed slow mode
+// interval}.
+var areaScript = redis.NewScript(slowModeLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local ceiling = tonumber(ARGV[3])
+
+local busiest = 0
+local retry = 0
+for i = 3, #KEYS do
+	redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', now - window)
+	local count = redis.call('ZCARD', KEYS[i])
+	if count > busiest then
+		busiest = count
+	end
+	if count >= ceiling then
+		local oldest = redis.call('ZRANGE', KEYS[i], 0, 0, 'WITHSCORES')
+		if oldest[2] then
+			retry = math.max(retry, tonumber(oldest[2]) + window - now)
+		end
+	end
+end
+
+local interval = slow_mode_interval(busiest, ceiling, tonumber(ARGV[5]), tonumber(ARGV[6]), tonumber(ARGV[7]))
+
+local changed = set_slow_mode(KEYS[2], interval, window)
+
+if busiest >= ceiling then
+	return {0, 1, retry, interval, changed}
+end
+
+if interval > 0 then
+	local wait = redis.call('PTTL', KEYS[1])
+	if wait > 0 then
+		return {0, 2, wait, interval, changed}
+	end
+end
+
+for i = 3, #KEYS do
+	redis.call('ZADD', KEYS[i], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[i], window)
+end
+if interval > 0 then
+	redis.call('SET', KEYS[1], 1, 'PX', interval)
+end
+return {1, 0, 0, interval, changed}
+`)
+
+// cellCountScript trims one cell's message window and counts it, for area
+// checks in a cluster.
+//
+// KEYS[1] cell window key
+// ARGV[1] now in milliseconds
+// ARGV[2] window length in milliseconds
+// ARGV[3] messages per window allowed into a cell
+//
+// Returns {messages in the window, milliseconds until the cell is under the
+// ceiling again (0 if it is now)}.
+var cellCountScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local retry = 0
+if count >= tonumber(ARGV[3]) then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	if oldest[2] then
+		retry = tonumber(oldest[2]) + window - now
+	end
+end
+return {count, retry}
+`)
+
+// slowModeScript sets the slow mode of the sender's cell from the busiest
+// cell's count, for area checks in a cluster.
+//
+// KEYS[1] slow mode state key for the sender's own cell
+// ARGV[1] messages in the busiest cell's window
+// ARGV[2] window length in milliseconds
+// ARGV[3] messages per window allowed into a cell
+// ARGV[4] messages per window at which slow mode starts
+// ARGV[5] slow mode interval at the threshold, in milliseconds
+// ARGV[6] slow mode interval at the ceiling, in milliseconds
+//
+// Returns {slow mode interval in milliseconds (0 when off), 1 if the
+// interval changed}.
+var slowModeScript = redis.NewScript(slowModeLua + `
+local interval = slow_mode_interval(tonumber(ARGV[1]), tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6]))
+
+local changed = set_slow_mode(KEYS[1], interval, tonumber(ARGV[2]))
+return {interval, changed}
+`)
+
+// senderSlowModeScript refuses a sender still waiting out slow mode, and
+// otherwise starts their next wait, for area checks in a cluster.
+//
+// KEYS[1] sender's slow mode key
+// ARGV[1] slow mode interval in milliseconds
+//
+// Returns the milliseconds the sender must still wait, 0 if they may send.
+var senderSlowModeScript = redis.NewScript(`
+local wait = redis.call('PTTL', KEYS[1])
+if wait > 0 then
+	return wait
+end
+redis.call('SET', KEYS[1], 1, 'PX', ARGV[1])
+return 0
+`)
@@ -0,0 +1,310 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// In-memory implementations of the scripts in scripts.go, for
+// storage.MemoryClient. Each follows its Lua line by line; keep them in step
+// (TestScriptParity checks both against the replies the Lua gives).
+func init() {
+	storage.RegisterScript(slidingWindowScript, slidingWindowMemory)
+	storage.RegisterScript(windowCountScript, windowCountMemory)
+	storage.RegisterScript(fixedWindowScript, fixedWindowMemory)
+	storage.RegisterScript(tokenBucketScript, tokenBucketMemory)
+	storage.RegisterScript(areaScript, areaMemory)
+	storage.RegisterScript(cellCountScript, cellCountMemory)
+	storage.RegisterScript(slowModeScript, slowModeMemory)
+	storage.RegisterScript(senderSlowModeScript, senderSlowModeMemory)
+}
+
+func slidingWindowMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	now, window, limit := nums[0], nums[1], nums[2]
+
+	if _, err := tx.ZRemRangeByScore(key, math.Inf(-1), now-window); err != nil {
+		return nil, err
+	}
+	count, err := tx.ZCard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Milliseconds until the hit at index leaves the window
+	leaves := func(index int64) int64 {
+		hit, _ := tx.ZRangeWithScores(key, index, index)
+		if len(hit) > 0 {
+			return int64(hit[0].Score + window - now)
+		}
+		return 0
+	}
+
+	if float64(count) >= limit {
+		return []interface{}{int64(0), count, leaves(0), leaves(-1)}, nil
+	}
+
+	if err := tx.ZAdd(key, now, args[3]); err != nil {
+		return nil, err
+	}
+	tx.PExpire(key, milliseconds(window))
+	count++
+
+	retry := int64(0)
+	if float64(count) >= limit {
+		retry = leaves(0)
+	}
+	return []interface{}{int64(1), count, retry, int64(window)}, nil
+}
+
+func windowCountMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ZRemRangeByScore(keys[0], math.Inf(-1), nums[0]-nums[1]); err != nil {
+		return nil, err
+	}
+	return tx.ZCard(keys[0])
+}
+
+func fixedWindowMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := tx.Incr(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	ttl := tx.PTTL(keys[0])
+	if count == 1 || ttl < 0 {
+		tx.PExpire(keys[0], milliseconds(nums[0]))
+		ttl = int64(nums[0])
+	}
+	return []interface{}{count, ttl}, nil
+}
+
+func tokenBucketMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 4)
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	now, interval, burst, cost := nums[0], nums[1], nums[2], nums[3]
+
+	tokens, tokensErr := hashNumber(tx, key, "tokens")
+	ts, tsErr := hashNumber(tx, key, "ts")
+	if tokensErr != nil || tsErr != nil {
+		tokens = burst
+		ts = now
+	}
+
+	// Servers' clocks may disagree slightly; never refill for negative time
+	if now > ts {
+		tokens = math.Min(burst, tokens+(now-ts)/interval)
+		ts = now
+	}
+
+	allowed := int64(0)
+	if tokens >= cost {
+		tokens -= cost
+		allowed = 1
+	}
+
+	retry := int64(0)
+	if tokens < 1 {
+		retry = int64(math.Ceil((1 - tokens) * interval))
+	}
+
+	if err := tx.HSet(key, "tokens", luaString(tokens), "ts", luaString(ts)); err != nil {
+		return nil, err
+	}
+	// A bucket left alone this long is full again, so it can go
+	tx.PExpire(key, milliseconds(math.Ceil(burst*interval)))
+	return []interface{}{allowed, int64(math.Floor(tokens)), retry, int64(math.Ceil((burst - tokens) * interval))}, nil
+}
+
+// slowModeInterval follows slow_mode_interval in slowModeLua.
+func slowModeInterval(busiest, ceiling, threshold, minInterval, maxInterval float64) float64 {
+	if busiest+1 < threshold {
+		return 0
+	}
+	heat := 1.0
+	if ceiling > threshold {
+		heat = math.Min(1, (busiest+1-threshold)/(ceiling-threshold))
+	}
+	interval := minInterval + (maxInterval-minInterval)*heat
+	return math.Ceil(interval/1000) * 1000
+}
+
+// countCell trims a cell's window and returns its count and the
+// milliseconds until it is under the ceiling.
+func countCell(tx *storage.MemoryTx, key string, now, window, ceiling float64) (float64, float64, error) {
+	if _, err := tx.ZRemRangeByScore(key, math.Inf(-1), now-window); err != nil {
+		return 0, 0, err
+	}
+	count, err := tx.ZCard(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	retry := 0.0
+	if float64(count) >= ceiling {
+		if oldest, _ := tx.ZRangeWithScores(key, 0, 0); len(oldest) > 0 {
+			retry = oldest[0].Score + window - now
+		}
+	}
+	return float64(count), retry, nil
+}
+
+// slowModeStateWindows follows slow_mode_state_windows in slowModeLua.
+const slowModeStateWindows = 60
+
+// setSlowMode follows set_slow_mode in slowModeLua.
+func setSlowMode(tx *storage.MemoryTx, key string, interval, window float64) int64 {
+	changed := int64(0)
+	previous := 0.0
+	if value, err := tx.Get(key); err == nil {
+		previous, _ = strconv.ParseFloat(value, 64)
+	}
+	if previous != interval {
+		changed = 1
+	}
+	if interval > 0 {
+		tx.Set(key, luaString(interval), milliseconds(window*slowModeStateWindows))
+	} else {
+		tx.Del(key)
+	}
+	return changed
+}
+
+func areaMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	if len(args) < 7 {
+		return nil, fmt.Errorf("area script needs 7 arguments, got %d", len(args))
+	}
+	nums, err := scriptNumbers(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	thresholds, err := scriptNumbers(args[4:], 3)
+	if err != nil {
+		return nil, err
+	}
+	now, window, ceiling := nums[0], nums[1], nums[2]
+
+	busiest := 0.0
+	retry := 0.0
+	for _, key := range keys[2:] {
+		count, wait, err := countCell(tx, key, now, window, ceiling)
+		if err != nil {
+			return nil, err
+		}
+		busiest = math.Max(busiest, count)
+		retry = math.Max(retry, wait)
+	}
+
+	interval := slowModeInterval(busiest, ceiling, thresholds[0], thresholds[1], thresholds[2])
+	changed := setSlowMode(tx, keys[1], interval, window)
+
+	if busiest >= ceiling {
+		return []interface{}{int64(0), int64(1), int64(retry), int64(interval), changed}, nil
+	}
+
+	if interval > 0 {
+		if wait := tx.PTTL(keys[0]); wait > 0 {
+			return []interface{}{int64(0), int64(2), wait, int64(interval), changed}, nil
+		}
+	}
+
+	for _, key := range keys[2:] {
+		if err := tx.ZAdd(key, now, args[3]); err != nil {
+			return nil, err
+		}
+		tx.PExpire(key, milliseconds(window))
+	}
+	if interval > 0 {
+		tx.Set(keys[0], "1", milliseconds(interval))
+	}
+	return []interface{}{int64(1), int64(0), int64(0), int64(interval), changed}, nil
+}
+
+func cellCountMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	count, retry, err := countCell(tx, keys[0], nums[0], nums[1], nums[2])
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{int64(count), int64(retry)}, nil
+}
+
+func slowModeMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 6)
+	if err != nil {
+		return nil, err
+	}
+	interval := slowModeInterval(nums[0], nums[2], nums[3], nums[4], nums[5])
+	changed := setSlowMode(tx, keys[0], interval, nums[1])
+	return []interface{}{int64(interval), changed}, nil
+}
+
+func senderSlowModeMemory(tx *storage.MemoryTx, keys, args []string) (interface{}, error) {
+	nums, err := scriptNumbers(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if wait := tx.PTTL(keys[0]); wait > 0 {
+		return wait, nil
+	}
+	tx.Set(keys[0], "1", milliseconds(nums[0]))
+	return int64(0), nil
+}
+
+// scriptNumbers parses the first n arguments as numbers, as tonumber would.
+func scriptNumbers(args []string, n int) ([]float64, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("script needs %d arguments, got %d", n, len(args))
+	}
+	nums := make([]float64, n)
+	for i := range nums {
+		num, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("script argument %d is not a number: %q", i+1, args[i])
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+// hashNumber reads a numeric hash field, failing if it is missing.
+func hashNumber(tx *storage.MemoryTx, key, field string) (float64, error) {
+	value, err := tx.HGet(key, field)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, redis.Nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// luaString formats a number as Lua's tostring does.
+func luaString(n float64) string {
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
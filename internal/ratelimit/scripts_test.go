@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// scriptStep is one script run and the reply the Lua script gives. Replies
+// that depend on a real TTL (PTTL) are matched within tolerance
+// milliseconds.
+type scriptStep struct {
+	name      string
+	script    *redis.Script
+	keys      []string
+	args      []interface{}
+	want      []int64
+	tolerance int64
+}
+
+// scriptParitySteps run in order against one client, so later steps see
+// the state earlier ones left. The expected replies are worked out from the
+// Lua in scripts.go.
+var scriptParitySteps = []scriptStep{
+	// Sliding window: 2 hits per second
+	{name: "sliding first hit", script: slidingWindowScript, keys: []string{"sliding"},
+		args: []interface{}{10000, 1000, 2, "a"}, want: []int64{1, 1, 0, 1000}},
+	{name: "sliding reaches limit", script: slidingWindowScript, keys: []string{"sliding"},
+		args: []interface{}{10100, 1000, 2, "b"}, want: []int64{1, 2, 900, 1000}},
+	{name: "sliding over limit", script: slidingWindowScript, keys: []string{"sliding"},
+		args: []interface{}{10200, 1000, 2, "c"}, want: []int64{0, 2, 800, 900}},
+	{name: "sliding oldest left window", script: slidingWindowScript, keys: []string{"sliding"},
+		args: []interface{}{11050, 1000, 2, "d"}, want: []int64{1, 2, 50, 1000}},
+	{name: "window count trims", script: windowCountScript, keys: []string{"sliding"},
+		args: []interface{}{11200, 1000}, want: []int64{1}},
+	{name: "window count missing key", script: windowCountScript, keys: []string{"missing"},
+		args: []interface{}{11200, 1000}, want: []int64{0}},
+
+	// Fixed window: the TTL is real time, so allow for the test's own delay
+	{name: "fixed first hit", script: fixedWindowScript, keys: []string{"fixed"},
+		args: []interface{}{60000}, want: []int64{1, 60000}},
+	{name: "fixed second hit", script: fixedWindowScript, keys: []string{"fixed"},
+		args: []interface{}{60000}, want: []int64{2, 60000}, tolerance: 1000},
+
+	// Token bucket: a token a second, 2 at most
+	{name: "bucket starts full", script: tokenBucketScript, keys: []string{"bucket"},
+		args: []interface{}{100000, 1000, 2, 1}, want: []int64{1, 1, 0, 1000}},
+	{name: "bucket emptied", script: tokenBucketScript, keys: []string{"bucket"},
+		args: []interface{}{100000, 1000, 2, 1}, want: []int64{1, 0, 1000, 2000}},
+	{name: "bucket half refilled", script: tokenBucketScript, keys: []string{"bucket"},
+		args: []interface{}{100500, 1000, 2, 1}, want: []int64{0, 0, 500, 1500}},
+	{name: "bucket refilled", script: tokenBucketScript, keys: []string{"bucket"},
+		args: []interface{}{101500, 1000, 2, 1}, want: []int64{1, 0, 500, 1500}},
+	{name: "bucket clock behind", script: tokenBucketScript, keys: []string{"bucket"},
+		args: []interface{}{100000, 1000, 2, 0}, want: []int64{1, 0, 500, 1500}},
+
+	// Area: a ceiling of 3 per minute, slow mode from 2, between 1.5s and 4s
+	{name: "area quiet", script: areaScript, keys: []string{"slow:1", "state", "cell:a", "cell:b"},
+		args: []interface{}{1000, 60000, 3, "m1", 2, 1500, 4000}, want: []int64{1, 0, 0, 0, 0}},
+	{name: "area slow mode starts", script: areaScript, keys: []string{"slow:1", "state", "cell:a", "cell:b"},
+		args: []interface{}{2000, 60000, 3, "m2", 2, 1500, 4000}, want: []int64{1, 0, 0, 2000, 1}},
+	{name: "area slow mode refuses", script: areaScript, keys: []string{"slow:1", "state", "cell:a", "cell:b"},
+		args: []interface{}{3000, 60000, 3, "m3", 2, 1500, 4000}, want: []int64{0, 2, 2000, 4000, 1}, tolerance: 1000},
+	{name: "area other sender", script: areaScript, keys: []string{"slow:2", "state", "cell:a", "cell:b"},
+		args: []interface{}{3000, 60000, 3, "m3", 2, 1500, 4000}, want: []int64{1, 0, 0, 4000, 0}},
+	{name: "area ceiling", script: areaScript, keys: []string{"slow:3", "state", "cell:a", "cell:b"},
+		args: []interface{}{4000, 60000, 3, "m4", 2, 1500, 4000}, want: []int64{0, 1, 57000, 4000, 0}},
+	{name: "area window moved on", script: areaScript, keys: []string{"slow:4", "state", "cell:b"},
+		args: []interface{}{62000, 60000, 3, "m5", 2, 1500, 4000}, want: []int64{1, 0, 0, 2000, 1}},
+
+	// Area checks split for a cluster, with the same limits
+	{name: "cell count empty", script: cellCountScript, keys: []string{"split:a"},
+		args: []interface{}{1000, 60000, 3}, want: []int64{0, 0}},
+	{name: "cell count under ceiling", script: cellCountScript, keys: []string{"cell:b"},
+		args: []interface{}{62000, 60000, 3}, want: []int64{2, 0}},
+	{name: "cell count at ceiling", script: cellCountScript, keys: []string{"cell:a"},
+		args: []interface{}{4000, 60000, 3}, want: []int64{3, 57000}},
+	{name: "slow mode off", script: slowModeScript, keys: []string{"split:state"},
+		args: []interface{}{0, 60000, 3, 2, 1500, 4000}, want: []int64{0, 0}},
+	{name: "slow mode starts", script: slowModeScript, keys: []string{"split:state"},
+		args: []interface{}{1, 60000, 3, 2, 1500, 4000}, want: []int64{2000, 1}},
+	{name: "slow mode unchanged", script: slowModeScript, keys: []string{"split:state"},
+		args: []interface{}{1, 60000, 3, 2, 1500, 4000}, want: []int64{2000, 0}},
+	{name: "slow mode ends", script: slowModeScript, keys: []string{"split:state"},
+		args: []interface{}{0, 60000, 3, 2, 1500, 4000}, want: []int64{0, 1}},
+	{name: "sender may send", script: senderSlowModeScript, keys: []string{"split:slow"},
+		args: []interface{}{2000}, want: []int64{0}},
+	{name: "sender waits", script: senderSlowModeScript, keys: []string{"split:slow"},
+		args: []interface{}{2000}, want: []int64{2000}, tolerance: 1000},
+}
+
+// TestScriptParity runs the scripts against the in-memory implementations
+// and, when REDIS_TEST_ADDR is set, against Redis, checking both give the
+// replies the Lua does.
+func TestScriptParity(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		runScriptSteps(t, storagetest.NewClient(t))
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		addr := os.Getenv("REDIS_TEST_ADDR")
+		if addr == "" {
+			t.Skip("REDIS_TEST_ADDR not set")
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A fresh prefix keeps runs apart and away from real keys
+		redisClient, err := storage.NewRedisClient(&config.Config{Redis: config.RedisConfig{
+			Host:      host,
+			Port:      port,
+			KeyPrefix: "neartalk-test-" + uuid.NewString(),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer redisClient.Close()
+		if err := redisClient.Ping(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		runScriptSteps(t, redisClient)
+	})
+}
+
+func runScriptSteps(t *testing.T, redisClient storage.RedisClient) {
+	ctx := context.Background()
+
+	var used []string
+	defer func() { redisClient.Del(ctx, used...) }()
+
+	for _, step := range scriptParitySteps {
+		keys := make([]string, len(step.keys))
+		for i, key := range step.keys {
+			keys[i] = redisClient.Keys().Key("ratelimit", storage.HashTag("parity"), key)
+		}
+		used = append(used, keys...)
+
+		reply, err := redisClient.RunScript(ctx, step.script, keys, step.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got, err := int64s(reply)
+		if count, ok := reply.(int64); ok {
+			got, err = []int64{count}, nil
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if len(got) != len(step.want) {
+			t.Fatalf("%s: reply %v, want %v", step.name, got, step.want)
+		}
+		for i := range got {
+			if diff := step.want[i] - got[i]; diff < 0 || diff > step.tolerance {
+				t.Errorf("%s: reply %v, want %v", step.name, got, step.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// takeToken takes cost tokens from a token bucket holding up to burst tokens
+// and refilled at perMinute tokens a minute. Unlike a sliding window, a quiet
+// session can send a short burst straight away, then settles to the steady
+// rate.
+func (l *Limiter) takeToken(ctx context.Context, key string, perMinute, burst, cost int) (*Result, error) {
+	if perMinute < 1 {
+		perMinute = 1
+	}
+	interval := float64(time.Minute.Milliseconds()) / float64(perMinute)
+
+	result, err := l.redis.RunScript(ctx, tokenBucketScript, []string{key}, time.Now().UnixMilli(), interval, burst, cost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+
+	values, err := int64s(result)
+	if err != nil || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", result)
+	}
+
+	return &Result{
+		Allowed:    values[0] == 1,
+		Limit:      burst,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,241 @@
+package reputation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/clientip"
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	apperrors "github.com/askwhyharsh/neartalk/pkg/errors"
+)
+
+// Neutral is the score at which limits and thresholds are left as
+// configured.
+const Neutral = 50
+
+// Score contributions. New sessions start a little below neutral, reach it
+// after half an hour of quiet use, and get the full age bonus after an hour
+// and the full clean-message bonus after 40 accepted messages.
+const (
+	initialScore        = 40
+	maxAgeBonus         = 20
+	ageBonusPerMinute   = maxAgeBonus / 60.0
+	maxCleanBonus       = 20
+	cleanBonus          = 0.5
+	reportPenalty       = 10
+	maxReportPenalty    = 40
+	violationPenalty    = 15
+	maxViolationPenalty = 60
+
+	// ipWeight is how much the IP's score counts towards a session's, so a
+	// fresh session from an IP with a bad history starts out distrusted
+	ipWeight = 0.3
+
+	// bestScore is the highest combined score: a session with the full age
+	// and clean-message bonuses from an IP with the full clean-message
+	// bonus (IPs have no age bonus). MaxMultiplier is reached there.
+	bestScore = (1-ipWeight)*(initialScore+maxAgeBonus+maxCleanBonus) +
+		ipWeight*(initialScore+maxCleanBonus)
+)
+
+type ReputationService interface {
+	// Get returns the reputation of a session, combined with its IP's.
+	Get(ctx context.Context, sessionID string) (*Reputation, error)
+
+	// Multiplier scales a session's limits and spam thresholds: below 1 for
+	// distrusted sessions, above 1 for trusted ones.
+	Multiplier(ctx context.Context, sessionID string) (float64, error)
+
+	// RecordClean counts a message that passed spam checks.
+	RecordClean(ctx context.Context, sessionID string) error
+
+	// RecordViolation counts a spam or profanity violation.
+	RecordViolation(ctx context.Context, sessionID string) error
+
+	// RecordReport counts a report against a session. Each reporter
+	// network (an IPv4 address or IPv6 prefix) counts once per reported
+	// session, so one client cannot mint sessions to report someone over
+	// and over; it returns false for repeat reports, and
+	// apperrors.ErrSessionNotFound if the session does not exist.
+	RecordReport(ctx context.Context, reporterIP, sessionID string) (bool, error)
+}
+
+type Service struct {
+	redis          storage.RedisClient
+	sessions       session.SessionService
+	config         config.ReputationConfig
+	ipv6PrefixBits int
+}
+
+// Reputation is a session's score and the counts it was computed from.
+// Scores run from 0 to bestScore (74); a session alone can reach 80 and an
+// IP 60.
+type Reputation struct {
+	SessionID    string  `json:"session_id"`
+	Score        int     `json:"score"`
+	Multiplier   float64 `json:"multiplier"`
+	SessionScore int     `json:"session_score"`
+	IPScore      int     `json:"ip_score"`
+	Counts       Counts  `json:"counts"`
+}
+
+// Counts are the events recorded against a session or IP.
+type Counts struct {
+	Clean      int64 `json:"clean"`
+	Reports    int64 `json:"reports"`
+	Violations int64 `json:"violations"`
+}
+
+// NewService creates a reputation service. IPv6 addresses, of sessions and
+// of reporters, are grouped by their ipv6PrefixBits prefix, as rate limits
+// group them, so a client cannot shed its history by changing address
+// within its prefix.
+func NewService(redisClient storage.RedisClient, sessions session.SessionService, cfg config.ReputationConfig, ipv6PrefixBits int) *Service {
+	return &Service{
+		redis:          redisClient,
+		sessions:       sessions,
+		config:         cfg,
+		ipv6PrefixBits: ipv6PrefixBits,
+	}
+}
+
+func (s *Service) Get(ctx context.Context, sessionID string) (*Reputation, error) {
+	sess, err := s.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both histories are read in one round trip
+	pipe := s.redis.Pipeline()
+	sessionCmd := pipe.HGetAll(ctx, s.sessionKey(sessionID))
+	ipCmd := pipe.HGetAll(ctx, s.ipKey(sess.IPAddress))
+	if err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load reputation: %w", err)
+	}
+	sessionCounts := counts(sessionCmd.Val())
+	ipCounts := counts(ipCmd.Val())
+
+	age := time.Since(sess.CreatedAt)
+	sessionScore := score(age, sessionCounts)
+	// IPs have no age of their own, so only their history counts
+	ipScore := score(0, ipCounts)
+	combined := (1-ipWeight)*sessionScore + ipWeight*ipScore
+
+	return &Reputation{
+		SessionID:    sessionID,
+		Score:        int(math.Round(combined)),
+		Multiplier:   s.multiplier(combined),
+		SessionScore: int(math.Round(sessionScore)),
+		IPScore:      int(math.Round(ipScore)),
+		Counts:       sessionCounts,
+	}, nil
+}
+
+func (s *Service) Multiplier(ctx context.Context, sessionID string) (float64, error) {
+	if !s.config.Enabled {
+		return 1, nil
+	}
+
+	rep, err := s.Get(ctx, sessionID)
+	if err != nil {
+		return 1, err
+	}
+	return rep.Multiplier, nil
+}
+
+func (s *Service) RecordClean(ctx context.Context, sessionID string) error {
+	return s.record(ctx, sessionID, "clean")
+}
+
+func (s *Service) RecordViolation(ctx context.Context, sessionID string) error {
+	return s.record(ctx, sessionID, "violations")
+}
+
+func (s *Service) RecordReport(ctx context.Context, reporterIP, sessionID string) (bool, error) {
+	exists, err := s.sessions.Exists(ctx, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check reported session: %w", err)
+	}
+	if !exists {
+		return false, apperrors.ErrSessionNotFound
+	}
+
+	network := clientip.Aggregate(reporterIP, s.ipv6PrefixBits)
+	key := s.redis.Keys().Key("reputation", "reported", network, sessionID)
+	reported, err := s.redis.Exists(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check report: %w", err)
+	}
+	if reported > 0 {
+		return false, nil
+	}
+
+	if err := s.redis.Set(ctx, key, 1, s.config.TTL); err != nil {
+		return false, fmt.Errorf("failed to store report: %w", err)
+	}
+
+	if err := s.record(ctx, sessionID, "reports"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// record increments a counter for the session and its IP.
+func (s *Service) record(ctx context.Context, sessionID, field string) error {
+	keys := []string{s.sessionKey(sessionID)}
+	if sess, err := s.sessions.Get(ctx, sessionID); err == nil && sess.IPAddress != "" {
+		keys = append(keys, s.ipKey(sess.IPAddress))
+	}
+
+	pipe := s.redis.Pipeline()
+	for _, key := range keys {
+		pipe.HIncrBy(ctx, key, field, 1)
+		pipe.Expire(ctx, key, s.config.TTL)
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record reputation: %w", err)
+	}
+	return nil
+}
+
+func counts(fields map[string]string) Counts {
+	var counts Counts
+	counts.Clean, _ = strconv.ParseInt(fields["clean"], 10, 64)
+	counts.Reports, _ = strconv.ParseInt(fields["reports"], 10, 64)
+	counts.Violations, _ = strconv.ParseInt(fields["violations"], 10, 64)
+	return counts
+}
+
+// multiplier maps a score onto [MinMultiplier, MaxMultiplier], with the
+// neutral score mapping to 1 and bestScore to MaxMultiplier.
+func (s *Service) multiplier(score float64) float64 {
+	if score < Neutral {
+		return s.config.MinMultiplier + (1-s.config.MinMultiplier)*score/Neutral
+	}
+	return 1 + (s.config.MaxMultiplier-1)*math.Min(1, (score-Neutral)/(bestScore-Neutral))
+}
+
+// score starts at initialScore, rises with age and clean messages and falls with
+// reports and violations, clamped to [0, 100].
+func score(age time.Duration, counts Counts) float64 {
+	value := float64(initialScore)
+	value += math.Min(maxAgeBonus, age.Minutes()*ageBonusPerMinute)
+	value += math.Min(maxCleanBonus, float64(counts.Clean)*cleanBonus)
+	value -= math.Min(maxReportPenalty, float64(counts.Reports)*reportPenalty)
+	value -= math.Min(maxViolationPenalty, float64(counts.Violations)*violationPenalty)
+	return math.Max(0, math.Min(100, value))
+}
+
+func (s *Service) sessionKey(sessionID string) string {
+	return s.redis.Keys().Key("reputation", "session", sessionID)
+}
+
+// ipKey is keyed by the IP's network, an IPv4 address or IPv6 prefix.
+func (s *Service) ipKey(ip string) string {
+	return s.redis.Keys().Key("reputation", "ip", clientip.Aggregate(ip, s.ipv6PrefixBits))
+}
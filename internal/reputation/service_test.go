@@ -0,0 +1,184 @@
+package reputation
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	apperrors "github.com/askwhyharsh/neartalk/pkg/errors"
+)
+
+func newTestService(t *testing.T) (*Service, *session.Service) {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	sessions := session.NewService(redisClient, time.Hour, 3)
+	return NewService(redisClient, sessions, config.ReputationConfig{
+		Enabled:       true,
+		MinMultiplier: 0.25,
+		MaxMultiplier: 2,
+		TTL:           time.Hour,
+	}, 64), sessions
+}
+
+// TestGet checks clean messages and violations move a session's score, and
+// its IP's history carries over to new sessions from the same address.
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	s, sessions := newTestService(t)
+
+	sess, _ := sessions.Create(ctx, "192.0.2.1")
+	score := func(sessionID string) int {
+		t.Helper()
+
+		rep, err := s.Get(ctx, sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep.Score
+	}
+
+	if got := score(sess.ID); got != initialScore {
+		t.Errorf("new session scored %d, want %d", got, initialScore)
+	}
+
+	s.RecordClean(ctx, sess.ID)
+	s.RecordClean(ctx, sess.ID)
+	if got := score(sess.ID); got != initialScore+1 {
+		t.Errorf("after two clean messages scored %d, want %d", got, initialScore+1)
+	}
+
+	s.RecordViolation(ctx, sess.ID)
+	if got := score(sess.ID); got != initialScore+1-violationPenalty {
+		t.Errorf("after a violation scored %d, want %d", got, initialScore+1-violationPenalty)
+	}
+
+	// 70% of a fresh session's 40 and 30% of the IP's 26
+	next, _ := sessions.Create(ctx, "192.0.2.1")
+	if got := score(next.ID); got != 36 {
+		t.Errorf("new session from the same IP scored %d, want 36", got)
+	}
+
+	if _, err := s.Get(ctx, "missing"); err == nil {
+		t.Error("Get of a missing session succeeded")
+	}
+}
+
+func TestMultiplier(t *testing.T) {
+	s, _ := newTestService(t)
+
+	tests := []struct {
+		score float64
+		want  float64
+	}{
+		{0, 0.25},
+		{Neutral / 2, 0.625},
+		{Neutral, 1},
+		{(Neutral + bestScore) / 2, 1.5},
+		{bestScore, 2},
+		{100, 2},
+	}
+
+	for _, tt := range tests {
+		if got := s.multiplier(tt.score); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("multiplier(%g) = %g, want %g", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestMultiplierDisabled(t *testing.T) {
+	ctx := context.Background()
+	s, sessions := newTestService(t)
+	s.config.Enabled = false
+
+	sess, _ := sessions.Create(ctx, "192.0.2.1")
+	s.RecordViolation(ctx, sess.ID)
+	if got, err := s.Multiplier(ctx, sess.ID); err != nil || got != 1 {
+		t.Errorf("Multiplier = %g, %v, want 1 while disabled", got, err)
+	}
+}
+
+// TestIPv6Prefix checks a violation from one address in an IPv6 prefix
+// counts against sessions from the rest of it.
+func TestIPv6Prefix(t *testing.T) {
+	ctx := context.Background()
+	s, sessions := newTestService(t)
+
+	offender, _ := sessions.Create(ctx, "2001:db8::1")
+	for i := 0; i < 3; i++ {
+		if err := s.RecordViolation(ctx, offender.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"2001:db8::2", 0},
+		{"2001:db8:0:0:ffff::1", 0},
+		{"2001:db8:0:1::1", 40},
+		{"192.0.2.1", 40},
+	}
+
+	for _, tt := range tests {
+		sess, _ := sessions.Create(ctx, tt.ip)
+		rep, err := s.Get(ctx, sess.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.IPScore != tt.want {
+			t.Errorf("%s: IP score %d, want %d", tt.ip, rep.IPScore, tt.want)
+		}
+	}
+}
+
+func TestRecordReport(t *testing.T) {
+	ctx := context.Background()
+	s, sessions := newTestService(t)
+
+	reported, _ := sessions.Create(ctx, "192.0.2.1")
+
+	tests := []struct {
+		name       string
+		reporterIP string
+		sessionID  string
+		want       bool
+		wantErr    error
+	}{
+		{"first report", "198.51.100.1", reported.ID, true, nil},
+		{"same reporter", "198.51.100.1", reported.ID, false, nil},
+		{"other reporter", "198.51.100.2", reported.ID, true, nil},
+		{"same IPv6 prefix", "2001:db8::1", reported.ID, true, nil},
+		{"same IPv6 prefix again", "2001:db8::2", reported.ID, false, nil},
+		{"missing session", "198.51.100.3", "missing", false, apperrors.ErrSessionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.RecordReport(ctx, tt.reporterIP, tt.sessionID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("counted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rep, err := s.Get(ctx, reported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Counts.Reports != 3 {
+		t.Errorf("%d reports counted, want 3", rep.Counts.Reports)
+	}
+	if exists, _ := s.redis.Exists(ctx, s.sessionKey("missing")); exists != 0 {
+		t.Error("report against a missing session recorded")
+	}
+}
@@ -14,6 +14,7 @@ import (
 type SessionService interface {
 	Create(ctx context.Context, ipAddress string) (*Session, error)
 	Get(ctx context.Context, sessionID string) (*Session, error)
+	GetMany(ctx context.Context, sessionIDs []string) (map[string]*Session, error)
 	UpdateUsername(ctx context.Context, sessionID, newUsername string) error
 	UpdateLastSeen(ctx context.Context, sessionID string) error
 	Delete(ctx context.Context, sessionID string) error
@@ -81,6 +82,38 @@ func (s *Service) Get(ctx context.Context, sessionID string) (*Session, error) {
 	return &session, nil
 }
 
+// GetMany fetches sessions in one round trip. Sessions that are missing or
+// unreadable are left out of the result.
+func (s *Service) GetMany(ctx context.Context, sessionIDs []string) (map[string]*Session, error) {
+	sessions := make(map[string]*Session, len(sessionIDs))
+	if len(sessionIDs) == 0 {
+		return sessions, nil
+	}
+
+	keys := make([]string, len(sessionIDs))
+	for i, id := range sessionIDs {
+		keys[i] = s.sessionKey(id)
+	}
+	values, err := s.redis.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			continue
+		}
+		sessions[sessionIDs[i]] = &session
+	}
+
+	return sessions, nil
+}
+
 func (s *Service) UpdateUsername(ctx context.Context, sessionID, newUsername string) error {
 	session, err := s.Get(ctx, sessionID)
 	if err != nil {
@@ -124,7 +157,7 @@ func (s *Service) save(ctx context.Context, session *Session) error {
 }
 
 func (s *Service) sessionKey(sessionID string) string {
-	return fmt.Sprintf("session:%s", sessionID)
+	return s.redis.Keys().Key("session", sessionID)
 }
 
 func (s *Service) GetRemainingChanges(ctx context.Context, sessionID string) (int, error) {
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+func newTestService(t *testing.T, ttl time.Duration, maxChanges int) *Service {
+	t.Helper()
+
+	return NewService(storagetest.NewClient(t), ttl, maxChanges)
+}
+
+func TestCreateAndGet(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, time.Hour, 3)
+
+	created, err := s.Create(ctx, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Username == "" {
+		t.Fatalf("Create returned %+v, want an ID and a username", created)
+	}
+
+	got, err := s.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID || got.Username != created.Username || got.IPAddress != "192.0.2.1" {
+		t.Errorf("Get = %+v, want %+v", got, created)
+	}
+
+	if _, err := s.Get(ctx, "missing"); err == nil {
+		t.Error("Get of a missing session succeeded")
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, 20*time.Millisecond, 3)
+
+	created, err := s.Create(ctx, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	exists, err := s.Exists(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("session still exists after its TTL")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, time.Hour, 3)
+
+	a, _ := s.Create(ctx, "192.0.2.1")
+	b, _ := s.Create(ctx, "192.0.2.2")
+
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"all", []string{a.ID, b.ID}, []string{a.ID, b.ID}},
+		{"missing left out", []string{a.ID, "missing"}, []string{a.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, err := s.GetMany(ctx, tt.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) != len(tt.want) {
+				t.Fatalf("got %d sessions, want %d", len(sessions), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if sessions[id] == nil || sessions[id].ID != id {
+					t.Errorf("session %s missing from %v", id, sessions)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateUsername(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, time.Hour, 2)
+
+	created, _ := s.Create(ctx, "192.0.2.1")
+
+	tests := []struct {
+		username      string
+		wantErr       bool
+		wantRemaining int
+	}{
+		{"first", false, 1},
+		{"second", false, 0},
+		{"third", true, 0},
+	}
+
+	for _, tt := range tests {
+		err := s.UpdateUsername(ctx, created.ID, tt.username)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("UpdateUsername(%q) err = %v, want error %v", tt.username, err, tt.wantErr)
+		}
+		remaining, err := s.GetRemainingChanges(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining != tt.wantRemaining {
+			t.Errorf("after %q: %d changes remaining, want %d", tt.username, remaining, tt.wantRemaining)
+		}
+	}
+
+	got, _ := s.Get(ctx, created.ID)
+	if got.Username != "second" {
+		t.Errorf("username = %q, want %q", got.Username, "second")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, time.Hour, 3)
+
+	created, _ := s.Create(ctx, "192.0.2.1")
+	if err := s.Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Exists(ctx, created.ID); exists {
+		t.Error("session exists after Delete")
+	}
+}
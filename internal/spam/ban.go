@@ -0,0 +1,252 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// BanService records and checks bans for sessions and IP addresses.
+type BanService interface {
+	// Ban bans the session and IP and returns how long the ban lasts.
+	Ban(ctx context.Context, sessionID, ipAddress, reason string) (time.Duration, error)
+
+	// IsBanned reports whether the session or IP is banned, with the ban reason.
+	IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, string, error)
+
+	// Enforce applies the sanction for a session that has met the ban rules.
+	Enforce(ctx context.Context, sessionID, ipAddress, reason string) (BanAction, time.Duration, error)
+
+	// ShadowBan shadow-bans the session and IP for the given duration.
+	ShadowBan(ctx context.Context, sessionID, ipAddress, reason string, duration time.Duration) error
+
+	// LiftShadowBan removes a shadow ban from the session, the IP it was
+	// shadow-banned with and ipAddress, which may be empty.
+	LiftShadowBan(ctx context.Context, sessionID, ipAddress string) error
+
+	// IsShadowBanned reports whether the session or IP is shadow-banned.
+	IsShadowBanned(ctx context.Context, sessionID, ipAddress string) (bool, error)
+}
+
+// BanAction is the sanction Enforce applied.
+type BanAction string
+
+const (
+	// BanActionShadow hides the offender's messages from everyone but them.
+	BanActionShadow BanAction = "shadow"
+	// BanActionHard refuses and disconnects the offender.
+	BanActionHard BanAction = "hard"
+)
+
+// BanStore persists bans outside Redis. storage.PostgresClient implements it.
+type BanStore interface {
+	AddBan(ctx context.Context, sessionID, ipAddress, reason string, duration time.Duration) error
+	IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, error)
+}
+
+type BanManager struct {
+	redis          storage.RedisClient
+	store          BanStore
+	baseDuration   time.Duration
+	maxDuration    time.Duration
+	shadowDuration time.Duration
+}
+
+// NewBanManager creates a ban manager. store is optional and may be nil.
+// A positive shadowDuration makes Enforce shadow-ban first offenders for that
+// long instead of banning them outright.
+func NewBanManager(redisClient storage.RedisClient, store BanStore, baseDuration, maxDuration, shadowDuration time.Duration) *BanManager {
+	return &BanManager{
+		redis:          redisClient,
+		store:          store,
+		baseDuration:   baseDuration,
+		maxDuration:    maxDuration,
+		shadowDuration: shadowDuration,
+	}
+}
+
+// Ban bans a session and its IP. Each repeat offence quadruples the ban
+// duration, up to maxDuration.
+func (b *BanManager) Ban(ctx context.Context, sessionID, ipAddress, reason string) (time.Duration, error) {
+	offences, err := b.countOffence(ctx, sessionID, ipAddress)
+	if err != nil {
+		return 0, err
+	}
+
+	duration := b.durationFor(offences)
+	return duration, b.ban(ctx, sessionID, ipAddress, reason, duration)
+}
+
+// Enforce shadow-bans first offenders when shadow bans are enabled, so they
+// keep talking to themselves instead of rotating sessions. Later offences are
+// hard bans, escalating as in Ban.
+func (b *BanManager) Enforce(ctx context.Context, sessionID, ipAddress, reason string) (BanAction, time.Duration, error) {
+	offences, err := b.countOffence(ctx, sessionID, ipAddress)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if b.shadowDuration > 0 {
+		if offences == 1 {
+			return BanActionShadow, b.shadowDuration, b.ShadowBan(ctx, sessionID, ipAddress, reason, b.shadowDuration)
+		}
+		offences--
+	}
+
+	duration := b.durationFor(offences)
+	return BanActionHard, duration, b.ban(ctx, sessionID, ipAddress, reason, duration)
+}
+
+func (b *BanManager) countOffence(ctx context.Context, sessionID, ipAddress string) (int64, error) {
+	key := b.offenceKey(sessionID, ipAddress)
+	offences, err := b.redis.Incr(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count offences: %w", err)
+	}
+	b.redis.Expire(ctx, key, 30*24*time.Hour)
+	return offences, nil
+}
+
+func (b *BanManager) ban(ctx context.Context, sessionID, ipAddress, reason string, duration time.Duration) error {
+	if err := b.redis.Set(ctx, b.sessionKey(sessionID), reason, duration); err != nil {
+		return fmt.Errorf("failed to store ban: %w", err)
+	}
+	if ipAddress != "" {
+		if err := b.redis.Set(ctx, b.ipKey(ipAddress), reason, duration); err != nil {
+			return fmt.Errorf("failed to store ban: %w", err)
+		}
+	}
+
+	if b.store != nil {
+		if err := b.store.AddBan(ctx, sessionID, ipAddress, reason, duration); err != nil {
+			return fmt.Errorf("failed to persist ban: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *BanManager) IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, string, error) {
+	keys := make([]string, 0, 2)
+	if sessionID != "" {
+		keys = append(keys, b.sessionKey(sessionID))
+	}
+	if ipAddress != "" {
+		keys = append(keys, b.ipKey(ipAddress))
+	}
+
+	for _, key := range keys {
+		reason, err := b.redis.Get(ctx, key)
+		if err == nil {
+			return true, reason, nil
+		}
+		if err != redis.Nil {
+			return false, "", fmt.Errorf("failed to check ban: %w", err)
+		}
+	}
+
+	if b.store != nil {
+		banned, err := b.store.IsBanned(ctx, sessionID, ipAddress)
+		if err != nil {
+			return false, "", fmt.Errorf("failed to check ban: %w", err)
+		}
+		if banned {
+			return true, "banned", nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// ShadowBan shadow-bans the session and IP. The IP is kept with the
+// session's ban, so LiftShadowBan can find it after the session expires.
+func (b *BanManager) ShadowBan(ctx context.Context, sessionID, ipAddress, reason string, duration time.Duration) error {
+	if err := b.redis.Set(ctx, b.shadowSessionKey(sessionID), reason, duration); err != nil {
+		return fmt.Errorf("failed to store shadow ban: %w", err)
+	}
+	if ipAddress != "" {
+		if err := b.redis.Set(ctx, b.shadowIPKey(ipAddress), reason, duration); err != nil {
+			return fmt.Errorf("failed to store shadow ban: %w", err)
+		}
+		if err := b.redis.Set(ctx, b.shadowSessionIPKey(sessionID), ipAddress, duration); err != nil {
+			return fmt.Errorf("failed to store shadow ban: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *BanManager) LiftShadowBan(ctx context.Context, sessionID, ipAddress string) error {
+	keys := []string{b.shadowSessionKey(sessionID), b.shadowSessionIPKey(sessionID)}
+	if ipAddress != "" {
+		keys = append(keys, b.shadowIPKey(ipAddress))
+	}
+
+	stored, err := b.redis.Get(ctx, b.shadowSessionIPKey(sessionID))
+	if err == nil && stored != ipAddress {
+		keys = append(keys, b.shadowIPKey(stored))
+	} else if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to lift shadow ban: %w", err)
+	}
+
+	return b.redis.Del(ctx, keys...)
+}
+
+func (b *BanManager) IsShadowBanned(ctx context.Context, sessionID, ipAddress string) (bool, error) {
+	keys := []string{b.shadowSessionKey(sessionID)}
+	if ipAddress != "" {
+		keys = append(keys, b.shadowIPKey(ipAddress))
+	}
+
+	count, err := b.redis.Exists(ctx, keys...)
+	if err != nil {
+		return false, fmt.Errorf("failed to check shadow ban: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (b *BanManager) durationFor(offences int64) time.Duration {
+	duration := b.baseDuration
+	for i := int64(1); i < offences; i++ {
+		duration *= 4
+		if duration >= b.maxDuration {
+			return b.maxDuration
+		}
+	}
+	if duration > b.maxDuration {
+		return b.maxDuration
+	}
+	return duration
+}
+
+// offenceKey tracks repeat offences by IP when known, so rotating sessions
+// does not reset the escalation.
+func (b *BanManager) offenceKey(sessionID, ipAddress string) string {
+	if ipAddress != "" {
+		return b.redis.Keys().Key("ban", "offences", "ip", ipAddress)
+	}
+	return b.redis.Keys().Key("ban", "offences", "session", sessionID)
+}
+
+func (b *BanManager) sessionKey(sessionID string) string {
+	return b.redis.Keys().Key("ban", "session", sessionID)
+}
+
+func (b *BanManager) ipKey(ipAddress string) string {
+	return b.redis.Keys().Key("ban", "ip", ipAddress)
+}
+
+func (b *BanManager) shadowSessionKey(sessionID string) string {
+	return b.redis.Keys().Key("ban", "shadow", "session", sessionID)
+}
+
+// shadowSessionIPKey holds the IP the session was shadow-banned with.
+func (b *BanManager) shadowSessionIPKey(sessionID string) string {
+	return b.redis.Keys().Key("ban", "shadow", "session", sessionID, "ip")
+}
+
+func (b *BanManager) shadowIPKey(ipAddress string) string {
+	return b.redis.Keys().Key("ban", "shadow", "ip", ipAddress)
+}
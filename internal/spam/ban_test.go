@@ -0,0 +1,82 @@
+package spam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+func newTestBanManager(t *testing.T) *BanManager {
+	t.Helper()
+
+	return NewBanManager(storagetest.NewClient(t), nil, 10*time.Minute, time.Hour, time.Hour)
+}
+
+func TestEnforce(t *testing.T) {
+	ctx := context.Background()
+	bans := newTestBanManager(t)
+
+	tests := []struct {
+		sessionID    string
+		wantAction   BanAction
+		wantDuration time.Duration
+	}{
+		{"s1", BanActionShadow, time.Hour},
+		{"s2", BanActionHard, 10 * time.Minute},
+		{"s3", BanActionHard, 40 * time.Minute},
+		{"s4", BanActionHard, time.Hour},
+	}
+
+	// Offences escalate by IP across sessions
+	for _, tt := range tests {
+		action, duration, err := bans.Enforce(ctx, tt.sessionID, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if action != tt.wantAction || duration != tt.wantDuration {
+			t.Errorf("%s: %s ban for %s, want %s for %s", tt.sessionID, action, duration, tt.wantAction, tt.wantDuration)
+		}
+	}
+}
+
+func TestLiftShadowBan(t *testing.T) {
+	tests := []struct {
+		name string
+		// liftIP is the IP passed to LiftShadowBan; empty once the session
+		// has expired and its IP is no longer known
+		liftIP string
+	}{
+		{"known IP", "192.0.2.1"},
+		{"expired session", ""},
+		{"other IP", "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bans := newTestBanManager(t)
+
+			if err := bans.ShadowBan(ctx, "s1", "192.0.2.1", "test", time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			if err := bans.LiftShadowBan(ctx, "s1", tt.liftIP); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, check := range []struct{ sessionID, ip string }{
+				{"s1", ""},
+				{"s2", "192.0.2.1"},
+			} {
+				banned, err := bans.IsShadowBanned(ctx, check.sessionID, check.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if banned {
+					t.Errorf("%s from %q still shadow-banned", check.sessionID, check.ip)
+				}
+			}
+		})
+	}
+}
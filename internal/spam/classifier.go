@@ -0,0 +1,373 @@
+package spam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+)
+
+// Label is a moderator's verdict on a message used to train the classifier.
+type Label string
+
+const (
+	LabelSpam Label = "spam"
+	LabelHam  Label = "ham"
+)
+
+// ParseLabel accepts "spam" or "ham" ("clean" is an alias for ham).
+func ParseLabel(s string) (Label, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "spam":
+		return LabelSpam, nil
+	case "ham", "clean":
+		return LabelHam, nil
+	}
+	return "", fmt.Errorf("invalid label %q (want spam or ham)", s)
+}
+
+// BayesModel holds document and token counts per label. Build it with
+// NewBayesModel and change it through Add and Merge, which keep the totals
+// used for scoring up to date.
+type BayesModel struct {
+	Docs   map[Label]int            `json:"docs"`
+	Tokens map[Label]map[string]int `json:"tokens"`
+
+	totals     map[Label]int
+	vocabulary int
+}
+
+func NewBayesModel() *BayesModel {
+	return &BayesModel{
+		Docs: map[Label]int{LabelSpam: 0, LabelHam: 0},
+		Tokens: map[Label]map[string]int{
+			LabelSpam: make(map[string]int),
+			LabelHam:  make(map[string]int),
+		},
+		totals: make(map[Label]int),
+	}
+}
+
+// Add counts one document with the given label.
+func (m *BayesModel) Add(label Label, tokens []string) {
+	m.Docs[label]++
+	for _, token := range tokens {
+		m.addToken(label, token, 1)
+	}
+}
+
+// Merge adds every count in other to m. Labels other than spam and ham are
+// ignored.
+func (m *BayesModel) Merge(other *BayesModel) {
+	for label, docs := range other.Docs {
+		if _, ok := m.Docs[label]; ok {
+			m.Docs[label] += docs
+		}
+	}
+	for label, tokens := range other.Tokens {
+		if _, ok := m.Tokens[label]; !ok {
+			continue
+		}
+		for token, count := range tokens {
+			m.addToken(label, token, count)
+		}
+	}
+}
+
+func (m *BayesModel) addToken(label Label, token string, count int) {
+	if m.Tokens[LabelSpam][token] == 0 && m.Tokens[LabelHam][token] == 0 {
+		m.vocabulary++
+	}
+	m.Tokens[label][token] += count
+	m.totals[label] += count
+}
+
+// SpamProbability returns the probability that a message with the given
+// tokens is spam, using multinomial naive Bayes with Laplace smoothing.
+func (m *BayesModel) SpamProbability(tokens []string) float64 {
+	spamDocs, hamDocs := m.Docs[LabelSpam], m.Docs[LabelHam]
+	if spamDocs == 0 || hamDocs == 0 || len(tokens) == 0 {
+		return 0.5
+	}
+
+	v := float64(m.vocabulary)
+
+	logSpam := math.Log(float64(spamDocs) / float64(spamDocs+hamDocs))
+	logHam := math.Log(float64(hamDocs) / float64(spamDocs+hamDocs))
+	for _, token := range tokens {
+		logSpam += math.Log((float64(m.Tokens[LabelSpam][token]) + 1) / (float64(m.totals[LabelSpam]) + v))
+		logHam += math.Log((float64(m.Tokens[LabelHam][token]) + 1) / (float64(m.totals[LabelHam]) + v))
+	}
+
+	// P(spam) = 1 / (1 + e^(logHam - logSpam)), computed without overflow
+	return 1 / (1 + math.Exp(math.Max(-700, math.Min(700, logHam-logSpam))))
+}
+
+// ClassifierTokens splits text into the features the classifier counts:
+// canonical words (see Normalize) and adjacent word pairs.
+func ClassifierTokens(text string) []string {
+	words := canonicalTokens(fold(text))
+	tokens := make([]string, 0, 2*len(words))
+	tokens = append(tokens, words...)
+	for i := 0; i+1 < len(words); i++ {
+		tokens = append(tokens, words[i]+" "+words[i+1])
+	}
+	return tokens
+}
+
+// ModelStore persists a classifier model. Add merges counts into the stored
+// model rather than replacing it, so several servers can train one model.
+type ModelStore interface {
+	Load(ctx context.Context) (*BayesModel, error)
+	Add(ctx context.Context, delta *BayesModel) error
+}
+
+// RedisModelStore keeps the model in Redis hashes: one of document counts
+// and one of token counts per label.
+type RedisModelStore struct {
+	redis storage.RedisClient
+}
+
+func NewRedisModelStore(redisClient storage.RedisClient) *RedisModelStore {
+	return &RedisModelStore{redis: redisClient}
+}
+
+func (s *RedisModelStore) Load(ctx context.Context) (*BayesModel, error) {
+	model := NewBayesModel()
+
+	docs, err := s.redis.HGetAll(ctx, s.redis.Keys().Key("spam", "bayes", "docs"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load classifier model: %w", err)
+	}
+	for _, label := range []Label{LabelSpam, LabelHam} {
+		model.Docs[label], _ = strconv.Atoi(docs[string(label)])
+	}
+
+	for _, label := range []Label{LabelSpam, LabelHam} {
+		tokens, err := s.redis.HGetAll(ctx, s.redis.Keys().Key("spam", "bayes", "tokens", string(label)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load classifier model: %w", err)
+		}
+		for token, count := range tokens {
+			n, _ := strconv.Atoi(count)
+			model.addToken(label, token, n)
+		}
+	}
+
+	return model, nil
+}
+
+func (s *RedisModelStore) Add(ctx context.Context, delta *BayesModel) error {
+	pipe := s.redis.Pipeline()
+	for label, count := range delta.Docs {
+		if count == 0 {
+			continue
+		}
+		pipe.HIncrBy(ctx, s.redis.Keys().Key("spam", "bayes", "docs"), string(label), int64(count))
+	}
+	for label, tokens := range delta.Tokens {
+		key := s.redis.Keys().Key("spam", "bayes", "tokens", string(label))
+		for token, count := range tokens {
+			pipe.HIncrBy(ctx, key, token, int64(count))
+		}
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to update classifier model: %w", err)
+	}
+	return nil
+}
+
+// FileModelStore keeps the model in a JSON file.
+type FileModelStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileModelStore(path string) *FileModelStore {
+	return &FileModelStore{path: path}
+}
+
+func (s *FileModelStore) Load(ctx context.Context) (*BayesModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileModelStore) load() (*BayesModel, error) {
+	model := NewBayesModel()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return model, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load classifier model: %w", err)
+	}
+
+	var stored BayesModel
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse classifier model %s: %w", s.path, err)
+	}
+	model.Merge(&stored)
+	return model, nil
+}
+
+func (s *FileModelStore) Add(ctx context.Context, delta *BayesModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	model, err := s.load()
+	if err != nil {
+		return err
+	}
+	model.Merge(delta)
+
+	data, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so readers never see a
+	// partially written model
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save classifier model: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save classifier model: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save classifier model: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save classifier model: %w", err)
+	}
+	return nil
+}
+
+// Classifier is a naive Bayes spam classifier trained from moderation
+// decisions. It classifies against an in-memory copy of the stored model,
+// refreshed periodically so training on other servers is picked up.
+type Classifier struct {
+	store           ModelStore
+	minDocs         int
+	refreshInterval time.Duration
+	model           *BayesModel
+	mu              sync.RWMutex
+}
+
+// NewClassifier creates a classifier backed by store. It abstains until the
+// model holds at least minDocs documents of each label.
+func NewClassifier(store ModelStore, minDocs int, refreshInterval time.Duration) *Classifier {
+	return &Classifier{
+		store:           store,
+		minDocs:         minDocs,
+		refreshInterval: refreshInterval,
+		model:           NewBayesModel(),
+	}
+}
+
+// NewModelStore returns the model store named by kind ("redis" or "file").
+func NewModelStore(kind string, redisClient storage.RedisClient, path string) (ModelStore, error) {
+	switch kind {
+	case "redis":
+		return NewRedisModelStore(redisClient), nil
+	case "file":
+		if path == "" {
+			return nil, errors.New("classifier model file is required for the file store")
+		}
+		return NewFileModelStore(path), nil
+	}
+	return nil, fmt.Errorf("unknown classifier store %q", kind)
+}
+
+// Refresh reloads the model from the store.
+func (c *Classifier) Refresh(ctx context.Context) error {
+	model, err := c.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.model = model
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Start loads the model and keeps refreshing it until ctx is cancelled.
+func (c *Classifier) Start(ctx context.Context, log logger.Logger) {
+	if err := c.Refresh(ctx); err != nil {
+		log.Error("Failed to load spam classifier model", "error", err)
+	}
+
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				log.Error("Failed to refresh spam classifier model", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Train records a labeled message in the store and the in-memory model.
+func (c *Classifier) Train(ctx context.Context, label Label, text string) error {
+	tokens := ClassifierTokens(text)
+	if len(tokens) == 0 {
+		return errors.New("message has no words to learn from")
+	}
+
+	delta := NewBayesModel()
+	delta.Add(label, tokens)
+	if err := c.store.Add(ctx, delta); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.model.Add(label, tokens)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// SpamProbability returns the probability that text is spam, and false if the
+// model has not been trained on enough messages to say.
+func (c *Classifier) SpamProbability(text string) (float64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.model.Docs[LabelSpam] < c.minDocs || c.model.Docs[LabelHam] < c.minDocs {
+		return 0, false
+	}
+	return c.model.SpamProbability(ClassifierTokens(text)), true
+}
+
+// Stats returns the number of training documents per label.
+func (c *Classifier) Stats() map[Label]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return map[Label]int{
+		LabelSpam: c.model.Docs[LabelSpam],
+		LabelHam:  c.model.Docs[LabelHam],
+	}
+}
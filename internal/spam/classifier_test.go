@@ -0,0 +1,225 @@
+package spam
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+// newTestModelStore returns a model store in a file of its own.
+func newTestModelStore(t *testing.T) ModelStore {
+	return NewFileModelStore(filepath.Join(t.TempDir(), "model.json"))
+}
+
+func TestSpamProbability(t *testing.T) {
+	model := NewBayesModel()
+	model.Add(LabelSpam, []string{"free", "prize"})
+	model.Add(LabelHam, []string{"hello", "friend"})
+
+	// With 4 words and 2 tokens per label, "free" is (1+1)/(2+4) likely in
+	// spam and (0+1)/(2+4) in ham: spam is twice as likely, so 2/3
+	tests := []struct {
+		tokens []string
+		want   float64
+	}{
+		{[]string{"free"}, 2.0 / 3},
+		{[]string{"hello"}, 1.0 / 3},
+		{[]string{"free", "prize"}, 4.0 / 5},
+		{[]string{"free", "hello"}, 0.5},
+		{[]string{"unseen"}, 0.5},
+		{nil, 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := model.SpamProbability(tt.tokens); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("SpamProbability(%q) = %g, want %g", tt.tokens, got, tt.want)
+		}
+	}
+
+	// Priors follow the document counts
+	model.Add(LabelSpam, []string{"other"})
+	model.Add(LabelSpam, []string{"other"})
+	if got := model.SpamProbability([]string{"unseen"}); got <= 0.5 {
+		t.Errorf("unseen token with 3 spam and 1 ham document: %g, want above 0.5", got)
+	}
+
+	// A model missing a label cannot tell
+	untrained := NewBayesModel()
+	untrained.Add(LabelSpam, []string{"free"})
+	if got := untrained.SpamProbability([]string{"free"}); got != 0.5 {
+		t.Errorf("SpamProbability without ham documents = %g, want 0.5", got)
+	}
+}
+
+func TestBayesModelVocabulary(t *testing.T) {
+	model := NewBayesModel()
+	model.Add(LabelSpam, []string{"a", "b", "a"})
+	model.Add(LabelHam, []string{"b", "c"})
+
+	if model.vocabulary != 3 {
+		t.Errorf("vocabulary %d, want 3: a token in both labels counts once", model.vocabulary)
+	}
+	if model.totals[LabelSpam] != 3 || model.totals[LabelHam] != 2 {
+		t.Errorf("totals %v, want 3 spam and 2 ham tokens", model.totals)
+	}
+}
+
+func TestBayesModelMerge(t *testing.T) {
+	docs := []struct {
+		label  Label
+		tokens []string
+	}{
+		{LabelSpam, []string{"free", "prize", "free prize"}},
+		{LabelHam, []string{"hello", "friend"}},
+		{LabelSpam, []string{"win", "prize"}},
+		{LabelHam, []string{"hello", "there", "prize"}},
+	}
+
+	whole := NewBayesModel()
+	first, second := NewBayesModel(), NewBayesModel()
+	for i, doc := range docs {
+		whole.Add(doc.label, doc.tokens)
+		if i%2 == 0 {
+			first.Add(doc.label, doc.tokens)
+		} else {
+			second.Add(doc.label, doc.tokens)
+		}
+	}
+
+	merged := NewBayesModel()
+	merged.Merge(first)
+	merged.Merge(second)
+
+	if !reflect.DeepEqual(merged.Docs, whole.Docs) || !reflect.DeepEqual(merged.Tokens, whole.Tokens) {
+		t.Errorf("merged counts %v %v, want %v %v", merged.Docs, merged.Tokens, whole.Docs, whole.Tokens)
+	}
+	if merged.vocabulary != whole.vocabulary || !reflect.DeepEqual(merged.totals, whole.totals) {
+		t.Errorf("merged vocabulary %d and totals %v, want %d and %v",
+			merged.vocabulary, merged.totals, whole.vocabulary, whole.totals)
+	}
+	tokens := []string{"free", "prize", "hello"}
+	if got, want := merged.SpamProbability(tokens), whole.SpamProbability(tokens); got != want {
+		t.Errorf("merged model scores %g, want %g", got, want)
+	}
+
+	// Labels other than spam and ham are ignored
+	other := NewBayesModel()
+	other.Docs["bogus"] = 5
+	other.Tokens["bogus"] = map[string]int{"x": 1}
+	merged.Merge(other)
+	if _, ok := merged.Docs["bogus"]; ok || merged.vocabulary != whole.vocabulary {
+		t.Errorf("merging an unknown label changed the model: %v, vocabulary %d", merged.Docs, merged.vocabulary)
+	}
+}
+
+func TestClassifierTokens(t *testing.T) {
+	got := ClassifierTokens("FREE Prize, click now")
+	want := []string{"free", "prize", "click", "now", "free prize", "prize click", "click now"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ClassifierTokens = %q, want %q", got, want)
+	}
+	if got := ClassifierTokens("!!! ..."); len(got) != 0 {
+		t.Errorf("ClassifierTokens of punctuation = %q, want none", got)
+	}
+}
+
+// TestClassifierCorpus trains on the corpus and checks it tells its own
+// messages and a few new ones apart.
+func TestClassifierCorpus(t *testing.T) {
+	ctx := context.Background()
+	classifier := NewClassifier(newTestModelStore(t), 5, time.Minute)
+	entries := readCorpus(t, "testdata/classifier_corpus.jsonl")
+	for _, entry := range entries {
+		label, err := ParseLabel(entry.Label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := classifier.Train(ctx, label, entry.Text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries = append(entries,
+		corpusEntry{Text: "claim your free prize now", Label: "spam"},
+		corpusEntry{Text: "is the coffee shop near the park open", Label: "ham"},
+	)
+	for _, entry := range entries {
+		p, ok := classifier.SpamProbability(entry.Text)
+		if !ok {
+			t.Fatal("classifier abstained after training")
+		}
+		if (p > 0.5) != (entry.Label == "spam") {
+			t.Errorf("%s %q scored %.2f", entry.Label, entry.Text, p)
+		}
+	}
+}
+
+func TestClassifierMinDocs(t *testing.T) {
+	ctx := context.Background()
+	classifier := NewClassifier(newTestModelStore(t), 2, time.Minute)
+	classifier.Train(ctx, LabelSpam, "free prize")
+	classifier.Train(ctx, LabelSpam, "win money")
+	classifier.Train(ctx, LabelHam, "hello there")
+	if _, ok := classifier.SpamProbability("free money"); ok {
+		t.Error("classifier scored with one ham document, want it to abstain below 2")
+	}
+
+	classifier.Train(ctx, LabelHam, "see you later")
+	if p, ok := classifier.SpamProbability("free money"); !ok || p <= 0.5 {
+		t.Errorf("SpamProbability = %g, %v, want spam", p, ok)
+	}
+
+	if err := classifier.Train(ctx, LabelHam, "?!"); err == nil {
+		t.Error("Train accepted a message with no words")
+	}
+}
+
+// TestModelStores checks a model trained through one classifier is loaded
+// by another sharing its store.
+func TestModelStores(t *testing.T) {
+	stores := map[string]ModelStore{
+		"redis": NewRedisModelStore(storagetest.NewClient(t)),
+		"file":  newTestModelStore(t),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			trainer := NewClassifier(store, 1, time.Minute)
+			trainer.Train(ctx, LabelSpam, "free prize")
+			trainer.Train(ctx, LabelSpam, "free money")
+			trainer.Train(ctx, LabelHam, "hello friend")
+
+			reader := NewClassifier(store, 1, time.Minute)
+			if err := reader.Refresh(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if want := map[Label]int{LabelSpam: 2, LabelHam: 1}; !reflect.DeepEqual(reader.Stats(), want) {
+				t.Errorf("Stats after loading = %v, want %v", reader.Stats(), want)
+			}
+
+			got, _ := reader.SpamProbability("free")
+			want, _ := trainer.SpamProbability("free")
+			if math.Abs(got-want) > 1e-9 {
+				t.Errorf("loaded model scores %g, want %g as trained", got, want)
+			}
+		})
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	for input, want := range map[string]Label{"spam": LabelSpam, " HAM ": LabelHam, "clean": LabelHam} {
+		if got, err := ParseLabel(input); err != nil || got != want {
+			t.Errorf("ParseLabel(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseLabel("maybe"); err == nil {
+		t.Error("ParseLabel accepted an unknown label")
+	}
+}
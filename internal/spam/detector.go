@@ -2,127 +2,233 @@ package spam
 
 import (
 	"context"
-	"crypto/md5"
 	"fmt"
-	"regexp"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/askwhyharsh/neartalk/internal/config"
 	"github.com/askwhyharsh/neartalk/internal/storage"
 )
 
+// Reputation adjusts spam thresholds per session and learns from the outcome
+// of each message.
+type Reputation interface {
+	Multiplier(ctx context.Context, sessionID string) (float64, error)
+	RecordClean(ctx context.Context, sessionID string) error
+	RecordViolation(ctx context.Context, sessionID string) error
+}
+
 type Detector struct {
 	redis                  storage.RedisClient
 	profanityEnabled       bool
 	duplicateWindowSeconds int
 	maxURLsPerMessage      int
-	profanityWords         []string
-	urlRegex               *regexp.Regexp
+	profanityFiles         map[string]string
+	allowlistFile          string
+	profanity              *profanityMatcher
+	flood                  FloodConfig
+	links                  *LinkRules
+	classifier             *Classifier
+	reputation             Reputation
+	pipeline               *Pipeline
 	mu                     sync.RWMutex
 }
 
-func NewDetector(redisClient storage.RedisClient, profanityEnabled bool, duplicateWindow, maxURLs int) *Detector {
-	return &Detector{
-		redis:                  redisClient,
-		profanityEnabled:       profanityEnabled,
-		duplicateWindowSeconds: duplicateWindow,
-		maxURLsPerMessage:      maxURLs,
-		profanityWords:         loadProfanityList(),
-		urlRegex:               regexp.MustCompile(`https?://[^\s]+`),
+// NewDetector creates a detector. reputation may be nil to apply the
+// configured thresholds to every session.
+func NewDetector(redisClient storage.RedisClient, cfg config.SpamConfig, reputation Reputation) (*Detector, error) {
+	profanity, err := loadProfanityMatcher(cfg.ProfanityFiles, cfg.ProfanityAllowlistFile)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (d *Detector) ValidateMessage(ctx context.Context, sessionID, content string) error {
-	// Check message length
-	if len(content) < 1 {
-		return fmt.Errorf("message too short")
+	links, err := NewLinkRules(cfg.LinkAllowlist, cfg.LinkBlocklist, cfg.LinkPolicy, cfg.AreaLinkPolicies)
+	if err != nil {
+		return nil, err
 	}
-	if len(content) > 500 {
-		return fmt.Errorf("message too long (max 500 characters)")
+
+	d := &Detector{
+		redis:                  redisClient,
+		profanityEnabled:       cfg.ProfanityEnabled,
+		duplicateWindowSeconds: cfg.DuplicateWindowSeconds,
+		maxURLsPerMessage:      cfg.MaxURLsPerMessage,
+		profanityFiles:         cfg.ProfanityFiles,
+		allowlistFile:          cfg.ProfanityAllowlistFile,
+		profanity:              profanity,
+		reputation:             reputation,
+		links:                  links,
+		flood: FloodConfig{
+			MaxDistance:   cfg.SimilarityDistance,
+			MinLength:     cfg.SimilarityMinLength,
+			Window:        time.Duration(cfg.FloodWindowSeconds) * time.Second,
+			MinSenders:    cfg.FloodMinSenders,
+			AreaPrecision: cfg.FloodAreaPrecision,
+			MaxTracked:    cfg.FloodMaxTracked,
+		},
 	}
 
-	// Check if empty after trimming
-	if strings.TrimSpace(content) == "" {
-		return fmt.Errorf("message cannot be empty")
+	rules := []Rule{
+		&lengthRule{maxLength: 500},
+		&profanityRule{detector: d},
+		&linkRule{detector: d},
+		&emailRule{},
+		&phoneRule{},
+		&capsRule{},
+		&repeatedCharsRule{maxRepeats: 5},
+		&scamRule{},
 	}
 
-	// Check profanity
-	if d.profanityEnabled {
-		if d.containsProfanity(content) {
-			return fmt.Errorf("message contains profanity")
+	if cfg.ClassifierEnabled {
+		store, err := NewModelStore(cfg.ClassifierStore, redisClient, cfg.ClassifierModelFile)
+		if err != nil {
+			return nil, err
 		}
+		d.classifier = NewClassifier(store, cfg.ClassifierMinDocs, cfg.ClassifierRefreshInterval)
+		rules = append(rules, &classifierRule{classifier: d.classifier})
 	}
 
-	// Check for excessive URLs
-	if d.hasExcessiveURLs(content) {
-		return fmt.Errorf("too many URLs in message (max %d)", d.maxURLsPerMessage)
+	// These record the message, so they run after the content rules
+	rules = append(rules,
+		&duplicateRule{detector: d},
+		&floodRule{detector: d},
+	)
+
+	d.pipeline = NewPipeline(
+		PipelineConfig{
+			FlagScore:   cfg.FlagScore,
+			RejectScore: cfg.RejectScore,
+			Weights:     cfg.RuleWeights,
+		},
+		rules...,
+	)
+
+	return d, nil
+}
+
+// Evaluate runs the rule pipeline over a message sent from a geohash cell.
+// Thresholds are scaled by the sender's reputation. Flagged messages are
+// recorded against the session for moderators, and clean ones count towards
+// its reputation.
+func (d *Detector) Evaluate(ctx context.Context, sessionID, geohash, content string) (*Decision, error) {
+	multiplier := 1.0
+	if d.reputation != nil {
+		if m, err := d.reputation.Multiplier(ctx, sessionID); err == nil {
+			multiplier = m
+		}
 	}
+	return d.EvaluateScaled(ctx, sessionID, geohash, content, multiplier)
+}
 
-	// Check for duplicate spam
-	if err := d.checkDuplicateSpam(ctx, sessionID, content); err != nil {
-		return err
+// EvaluateScaled is Evaluate with the sender's reputation multiplier
+// supplied by a caller that already looked it up.
+func (d *Detector) EvaluateScaled(ctx context.Context, sessionID, geohash, content string, multiplier float64) (*Decision, error) {
+	decision, err := d.pipeline.Evaluate(ctx, &Message{
+		SessionID:           sessionID,
+		Geohash:             geohash,
+		Content:             content,
+		ThresholdMultiplier: multiplier,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if decision.Flagged && decision.Action != ActionReject {
+		d.recordFlag(ctx, sessionID, decision)
+	}
+	if !decision.Flagged && decision.Action == ActionAllow && d.reputation != nil {
+		d.reputation.RecordClean(ctx, sessionID)
+	}
+
+	return decision, nil
 }
 
-func (d *Detector) containsProfanity(content string) bool {
+// Classifier returns the naive Bayes classifier, or nil if it is disabled.
+func (d *Detector) Classifier() *Classifier {
+	return d.classifier
+}
+
+func (d *Detector) recordFlag(ctx context.Context, sessionID string, decision *Decision) {
+	key := d.redis.Keys().Key("spam", "flags", sessionID)
+	reason := decision.Reason()
+	if reason == "" {
+		reason = ReasonSpamScore
+	}
+	if _, err := d.redis.HIncrBy(ctx, key, string(reason), 1); err != nil {
+		return
+	}
+	d.redis.Expire(ctx, key, 24*time.Hour)
+}
+
+// containsProfanity checks canonical text (see Normalize) against the
+// profanity lists.
+func (d *Detector) containsProfanity(canonical string) bool {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
-	lowerContent := strings.ToLower(content)
-	for _, word := range d.profanityWords {
-		if strings.Contains(lowerContent, strings.ToLower(word)) {
-			return true
-		}
-	}
-	return false
+	return d.profanity.matches(canonical)
 }
 
-func (d *Detector) hasExcessiveURLs(content string) bool {
-	urls := d.urlRegex.FindAllString(content, -1)
-	return len(urls) > d.maxURLsPerMessage
+// ContainsProfanity reports whether content contains profanity, after
+// normalization, regardless of whether the profanity rule is enabled.
+func (d *Detector) ContainsProfanity(content string) bool {
+	return d.containsProfanity(Normalize(content).Canonical)
 }
 
-func (d *Detector) checkDuplicateSpam(ctx context.Context, sessionID, content string) error {
-	// Create hash of the message content
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(content)))
-	key := fmt.Sprintf("spam:msg:%s:%s", sessionID, hash)
+// isDuplicate reports whether the session sent this message, or one nearly
+// identical to it, within the duplicate window, and records it for future
+// checks.
+func (d *Detector) isDuplicate(ctx context.Context, sessionID, content string) (bool, error) {
+	key := d.redis.Keys().Key("spam", "recent", sessionID)
+	window := time.Duration(d.duplicateWindowSeconds) * time.Second
+	fp := newFingerprint(content)
+	now := time.Now()
 
-	// Check if this exact message was sent recently
-	exists, err := d.redis.Exists(ctx, key)
+	// Check if a similar message was sent recently
+	matches, err := d.recentMatches(ctx, key, fp, now, window)
 	if err != nil {
-		return fmt.Errorf("failed to check duplicate: %w", err)
+		return false, fmt.Errorf("failed to check duplicate: %w", err)
 	}
 
-	if exists > 0 {
-		return fmt.Errorf("duplicate message detected (sent within %d seconds)", d.duplicateWindowSeconds)
+	if len(matches) > 0 {
+		return true, nil
 	}
 
-	// Store the message hash with TTL
-	ttl := time.Duration(d.duplicateWindowSeconds) * time.Second
-	if err := d.redis.Set(ctx, key, 1, ttl); err != nil {
-		return fmt.Errorf("failed to store message hash: %w", err)
+	// Store the message fingerprint for the rest of the window
+	if err := d.remember(ctx, key, fp, strconv.FormatInt(now.UnixNano(), 10), now, window); err != nil {
+		return false, fmt.Errorf("failed to store message fingerprint: %w", err)
 	}
 
-	return nil
+	return false, nil
 }
 
 func (d *Detector) IncrementViolation(ctx context.Context, sessionID string, violationType string) error {
-	key := fmt.Sprintf("spam:violations:%s", sessionID)
+	key := d.redis.Keys().Key("spam", "violations", sessionID)
 
 	// Increment violation count
 	if _, err := d.redis.HIncrBy(ctx, key, violationType, 1); err != nil {
 		return err
 	}
 
+	if d.reputation != nil {
+		if err := d.reputation.RecordViolation(ctx, sessionID); err != nil {
+			return err
+		}
+	}
+
 	// Set expiration (24 hours)
 	return d.redis.Expire(ctx, key, 24*time.Hour)
 }
 
+// ResetViolations clears the session's violation counts. Call it once a ban
+// has been applied for them, so ShouldBan does not fire again as soon as the
+// ban ends.
+func (d *Detector) ResetViolations(ctx context.Context, sessionID string) error {
+	return d.redis.Del(ctx, d.redis.Keys().Key("spam", "violations", sessionID))
+}
+
 func (d *Detector) GetViolationCount(ctx context.Context, sessionID string) (map[string]int64, error) {
-	key := fmt.Sprintf("spam:violations:%s", sessionID)
+	key := d.redis.Keys().Key("spam", "violations", sessionID)
 	violations, err := d.redis.HGetAll(ctx, key)
 	if err != nil {
 		return nil, err
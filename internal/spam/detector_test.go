@@ -0,0 +1,207 @@
+package spam
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/internal/storage"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+)
+
+func newTestDetector(t *testing.T) (*Detector, *storage.MemoryClient) {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	detector, err := NewDetector(redisClient, config.SpamConfig{
+		DuplicateWindowSeconds: 30,
+		SimilarityDistance:     10,
+		SimilarityMinLength:    20,
+		FloodWindowSeconds:     60,
+		FloodMinSenders:        3,
+		FloodAreaPrecision:     4,
+		FloodMaxTracked:        3,
+		MaxURLsPerMessage:      2,
+		LinkPolicy:             "any",
+		FlagScore:              40,
+		RejectScore:            70,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return detector, redisClient
+}
+
+// sent is a message in a test sequence and the reason it should be
+// rejected, if any.
+type sent struct {
+	sessionID string
+	geohash   string
+	content   string
+	want      ReasonCode
+}
+
+func runMessages(t *testing.T, detector *Detector, messages []sent) []*Decision {
+	t.Helper()
+
+	decisions := make([]*Decision, len(messages))
+	for i, msg := range messages {
+		decision, err := detector.Evaluate(context.Background(), msg.sessionID, msg.geohash, msg.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ReasonCode("")
+		if decision.Action == ActionReject {
+			got = decision.Reason()
+		}
+		if got != msg.want {
+			t.Errorf("message %d (%s: %q) rejected for %q, want %q", i+1, msg.sessionID, msg.content, got, msg.want)
+		}
+		decisions[i] = decision
+		// Messages are scored in milliseconds; keep them in order
+		time.Sleep(2 * time.Millisecond)
+	}
+	return decisions
+}
+
+func TestDuplicates(t *testing.T) {
+	const long = "anyone up for a pickup game at the park later today"
+
+	tests := []struct {
+		name     string
+		messages []sent
+	}{
+		{"exact repeat", []sent{
+			{"s1", "9q8yyk", "hello there", ""},
+			{"s1", "9q8yyk", "hello there", ReasonDuplicate},
+		}},
+		{"short messages must match exactly", []sent{
+			{"s1", "9q8yyk", "hello there", ""},
+			{"s1", "9q8yyk", "hello therr", ""},
+		}},
+		{"near repeat", []sent{
+			{"s1", "9q8yyk", long, ""},
+			{"s1", "9q8yyk", long + "!!", ReasonDuplicate},
+		}},
+		{"other session", []sent{
+			{"s1", "9q8yyk", "hello there", ""},
+			{"s2", "9q8yyk", "hello there", ""},
+		}},
+		{"only the newest are compared", []sent{
+			{"s1", "9q8yyk", "alpha", ""},
+			{"s1", "9q8yyk", "bravo", ""},
+			{"s1", "9q8yyk", "charlie", ""},
+			{"s1", "9q8yyk", "delta", ""},
+			{"s1", "9q8yyk", "alpha", ""},
+			{"s1", "9q8yyk", "delta", ReasonDuplicate},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, _ := newTestDetector(t)
+			runMessages(t, detector, tt.messages)
+		})
+	}
+}
+
+func TestAreaFlood(t *testing.T) {
+	const spam = "check out the amazing deals at my shop down the road"
+
+	detector, _ := newTestDetector(t)
+	decisions := runMessages(t, detector, []sent{
+		{"s1", "9q8yyk", spam, ""},
+		// Another area is counted separately
+		{"s9", "u4pruy", spam, ""},
+		{"s2", "9q8yym", spam + "!", ""},
+		{"s3", "9q8yyj", spam, ReasonFlood},
+		{"s4", "9q8yyj", "ok see you all there", ""},
+	})
+
+	var related []string
+	for _, verdict := range decisions[3].Verdicts {
+		if verdict.Reason == ReasonFlood {
+			related = verdict.Related
+		}
+	}
+	sort.Strings(related)
+	if strings.Join(related, ",") != "s1,s2" {
+		t.Errorf("flood related %v, want [s1 s2]", related)
+	}
+
+	// The earlier senders are only charged once per window
+	decisions = runMessages(t, detector, []sent{{"s5", "9q8yyk", spam, ReasonFlood}})
+	for _, verdict := range decisions[0].Verdicts {
+		if verdict.Reason == ReasonFlood && strings.Join(verdict.Related, ",") != "s3" {
+			t.Errorf("second flood related %v, want [s3]", verdict.Related)
+		}
+	}
+}
+
+func TestMaxTracked(t *testing.T) {
+	detector, redisClient := newTestDetector(t)
+	runMessages(t, detector, []sent{
+		{"s1", "9q8yyk", "one", ""},
+		{"s1", "9q8yyk", "two", ""},
+		{"s1", "9q8yyk", "three", ""},
+		{"s1", "9q8yyk", "four", ""},
+		{"s1", "9q8yyk", "five", ""},
+	})
+
+	key := redisClient.Keys().Key("spam", "recent", "s1")
+	count, err := redisClient.ZCard(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("%s has %d members, want 3", key, count)
+	}
+}
+
+// TestRuleNames checks config.SpamRuleNames, which SPAM_RULE_WEIGHTS is
+// validated against, names every rule.
+func TestRuleNames(t *testing.T) {
+	detector, _ := newTestDetector(t)
+
+	// The classifier rule is only added when the classifier is enabled
+	names := []string{(&classifierRule{}).Name()}
+	for _, rule := range detector.pipeline.rules {
+		names = append(names, rule.Name())
+	}
+	sort.Strings(names)
+
+	want := append([]string(nil), config.SpamRuleNames...)
+	sort.Strings(want)
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("rules are named %v, config.SpamRuleNames has %v", names, want)
+	}
+}
+
+// TestResetViolations checks the ban rules start over once the violations
+// that met them are reset.
+func TestResetViolations(t *testing.T) {
+	ctx := context.Background()
+	detector, _ := newTestDetector(t)
+
+	for i := 0; i < 3; i++ {
+		if err := detector.IncrementViolation(ctx, "s1", "profanity"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ban, _, _ := detector.ShouldBan(ctx, "s1"); !ban {
+		t.Fatal("no ban after 3 profanity violations")
+	}
+
+	if err := detector.ResetViolations(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := detector.IncrementViolation(ctx, "s1", "profanity"); err != nil {
+		t.Fatal(err)
+	}
+	if ban, reason, _ := detector.ShouldBan(ctx, "s1"); ban {
+		t.Errorf("banned again for %q after one violation", reason)
+	}
+}
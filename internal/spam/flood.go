@@ -0,0 +1,151 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FloodConfig controls near-duplicate matching and cross-session flood
+// detection.
+type FloodConfig struct {
+	// MaxDistance is the largest SimHash Hamming distance at which two
+	// messages count as near-identical
+	MaxDistance int
+	// MinLength is the shortest canonical text, in runes, matched by
+	// similarity; shorter messages must match exactly and are never
+	// counted towards a flood
+	MinLength int
+	// Window is how far back messages in an area are compared
+	Window time.Duration
+	// MinSenders is how many distinct sessions must send near-identical
+	// messages within the window before it counts as a flood
+	MinSenders int
+	// AreaPrecision is the geohash prefix length that defines an area
+	AreaPrecision int
+	// MaxTracked is how many of a session's or an area's most recent
+	// messages are kept for comparison, bounding the work per message
+	MaxTracked int
+}
+
+// recentMatches trims entries older than window from a sorted set of
+// "<fingerprint>:<rest>" members scored by time in milliseconds, and returns
+// the rest of every remaining member near fp. Only the newest MaxTracked
+// members are compared.
+func (d *Detector) recentMatches(ctx context.Context, key string, fp fingerprint, now time.Time, window time.Duration) ([]string, error) {
+	cutoff := strconv.FormatInt(now.Add(-window).UnixMilli(), 10)
+	if err := d.redis.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff); err != nil {
+		return nil, err
+	}
+
+	members, err := d.redis.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   cutoff,
+		Max:   "+inf",
+		Count: int64(d.flood.MaxTracked),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, member := range members {
+		other, rest, err := splitFingerprint(member)
+		if err != nil {
+			continue
+		}
+		if fp.near(other, d.flood.MinLength, d.flood.MaxDistance) {
+			matches = append(matches, rest)
+		}
+	}
+	return matches, nil
+}
+
+// remember adds a "<fingerprint>:<rest>" member to a sorted set scored by
+// time, drops all but the newest MaxTracked members and keeps the set alive
+// for window.
+func (d *Detector) remember(ctx context.Context, key string, fp fingerprint, rest string, now time.Time, window time.Duration) error {
+	member := fmt.Sprintf("%s:%s", fp, rest)
+	if err := d.redis.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixMilli()), Member: member}); err != nil {
+		return err
+	}
+	if err := d.redis.ZRemRangeByRank(ctx, key, 0, int64(-d.flood.MaxTracked-1)); err != nil {
+		return err
+	}
+	return d.redis.Expire(ctx, key, window)
+}
+
+// checkFlood records a message against its area and reports whether enough
+// distinct sessions sent near-identical messages there within the flood
+// window to count as a flood. It also returns the other sessions involved;
+// each is only returned once per window, so a flood counts one violation
+// against each sender rather than one per message. Short messages are
+// skipped, since many people saying "hi" at once is not a flood.
+func (d *Detector) checkFlood(ctx context.Context, msg *Message, content string) (bool, []string, error) {
+	if d.flood.MinSenders < 2 || len(msg.Geohash) < d.flood.AreaPrecision {
+		return false, nil, nil
+	}
+
+	fp := newFingerprint(content)
+	if fp.length < d.flood.MinLength {
+		return false, nil, nil
+	}
+
+	area := msg.Geohash[:d.flood.AreaPrecision]
+	key := d.redis.Keys().Key("spam", "area", area)
+	now := time.Now()
+
+	matches, err := d.recentMatches(ctx, key, fp, now, d.flood.Window)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to check area flood: %w", err)
+	}
+
+	// Members are "<fingerprint>:<session id>:<unix nanos>"
+	if err := d.remember(ctx, key, fp, fmt.Sprintf("%s:%d", msg.SessionID, now.UnixNano()), now, d.flood.Window); err != nil {
+		return false, nil, fmt.Errorf("failed to record message for area %s: %w", area, err)
+	}
+
+	senders := make(map[string]struct{})
+	for _, match := range matches {
+		sessionID := match[:strings.LastIndex(match, ":")]
+		if sessionID != msg.SessionID {
+			senders[sessionID] = struct{}{}
+		}
+	}
+	if len(senders)+1 < d.flood.MinSenders {
+		return false, nil, nil
+	}
+
+	var others []string
+	for sessionID := range senders {
+		reportedKey := d.redis.Keys().Key("spam", "flood", "reported", sessionID)
+		reported, err := d.redis.Exists(ctx, reportedKey)
+		if err != nil {
+			return false, nil, err
+		}
+		if reported > 0 {
+			continue
+		}
+		if err := d.redis.Set(ctx, reportedKey, area, d.flood.Window); err != nil {
+			return false, nil, err
+		}
+		others = append(others, sessionID)
+	}
+	return true, others, nil
+}
+
+// splitFingerprint splits a "<hash>:<length>:<rest>" member.
+func splitFingerprint(member string) (fingerprint, string, error) {
+	parts := strings.SplitN(member, ":", 3)
+	if len(parts) != 3 {
+		return fingerprint{}, "", fmt.Errorf("invalid member %q", member)
+	}
+	fp, err := parseFingerprint(parts[0] + ":" + parts[1])
+	if err != nil {
+		return fingerprint{}, "", err
+	}
+	return fp, parts[2], nil
+}
@@ -0,0 +1,216 @@
+package spam
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkPattern matches links with a scheme, "www." links and bare domains on
+// common top-level domains, so "example.com/promo" counts as much as
+// "https://example.com/promo". Bare domains are limited to known TLDs so
+// that "file.txt" or "3.50" are not mistaken for links.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://[^\s<>"]+|www\.[^\s<>"]+|(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+(?:com|net|org|info|biz|io|co|me|ly|gg|tv|xyz|top|site|online|shop|store|club|app|dev|link|click|live|pro|vip|win|bid|loan|tk|ml|ga|cf|gq|ru|cn|uk|de|fr|in|us|ca|au|br)\b(?:[/?#][^\s<>"]*)?)`)
+
+// LinkPolicy decides which links may be posted in an area.
+type LinkPolicy string
+
+const (
+	// LinkPolicyNone removes every link
+	LinkPolicyNone LinkPolicy = "none"
+	// LinkPolicyAllowlisted keeps only links to allowlisted domains
+	LinkPolicyAllowlisted LinkPolicy = "allowlisted"
+	// LinkPolicyAny keeps every link that is not blocklisted
+	LinkPolicyAny LinkPolicy = "any"
+)
+
+func ParseLinkPolicy(s string) (LinkPolicy, error) {
+	switch policy := LinkPolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case LinkPolicyNone, LinkPolicyAllowlisted, LinkPolicyAny:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid link policy %q (want none, allowlisted or any)", s)
+}
+
+// Link is a link found in a message.
+type Link struct {
+	Raw   string
+	Host  string
+	Start int
+	End   int
+}
+
+// ExtractLinks returns the links in text, skipping bare domains that are part
+// of an email address.
+func ExtractLinks(text string) []Link {
+	emails := emailPattern.FindAllStringIndex(text, -1)
+
+	var links []Link
+	for _, loc := range linkPattern.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		if !strings.Contains(text[start:end], "://") && insideAny(start, end, emails) {
+			continue
+		}
+
+		// Sentence punctuation after a link is not part of it
+		raw := strings.TrimRight(text[start:end], `.,;:!?)]}'"`)
+		end = start + len(raw)
+
+		links = append(links, Link{
+			Raw:   raw,
+			Host:  linkHost(raw),
+			Start: start,
+			End:   end,
+		})
+	}
+	return links
+}
+
+// linkHost returns the lowercase host of a link, without scheme, port or
+// credentials.
+func linkHost(raw string) string {
+	host := raw
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func insideAny(start, end int, spans [][]int) bool {
+	for _, span := range spans {
+		if start < span[1] && end > span[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// DomainList matches hosts against domain patterns. "example.com" matches
+// that domain and its "www." host; "*.example.com" matches the domain and
+// every subdomain of it.
+type DomainList struct {
+	exact    map[string]struct{}
+	suffixes []string
+}
+
+func NewDomainList(patterns []string) *DomainList {
+	list := &DomainList{exact: make(map[string]struct{})}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			list.exact[domain] = struct{}{}
+			list.suffixes = append(list.suffixes, "."+domain)
+			continue
+		}
+		list.exact[strings.TrimPrefix(pattern, "www.")] = struct{}{}
+	}
+	return list
+}
+
+// Contains reports whether host matches any pattern in the list.
+func (l *DomainList) Contains(host string) bool {
+	if _, ok := l.exact[strings.TrimPrefix(host, "www.")]; ok {
+		return true
+	}
+	for _, suffix := range l.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkRules holds the domain lists and link policies for every area.
+type LinkRules struct {
+	allowlist     *DomainList
+	blocklist     *DomainList
+	defaultPolicy LinkPolicy
+	areaPolicies  map[string]LinkPolicy
+	areaPrefixes  []string // longest first
+}
+
+// NewLinkRules builds link rules. areaPolicies maps geohash prefixes to the
+// policy for that area; the longest matching prefix wins.
+func NewLinkRules(allowlist, blocklist []string, defaultPolicy string, areaPolicies map[string]string) (*LinkRules, error) {
+	policy, err := ParseLinkPolicy(defaultPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &LinkRules{
+		allowlist:     NewDomainList(allowlist),
+		blocklist:     NewDomainList(blocklist),
+		defaultPolicy: policy,
+		areaPolicies:  make(map[string]LinkPolicy),
+	}
+
+	for prefix, value := range areaPolicies {
+		policy, err := ParseLinkPolicy(value)
+		if err != nil {
+			return nil, fmt.Errorf("area %s: %w", prefix, err)
+		}
+		rules.areaPolicies[prefix] = policy
+		rules.areaPrefixes = append(rules.areaPrefixes, prefix)
+	}
+	sort.Slice(rules.areaPrefixes, func(i, j int) bool {
+		return len(rules.areaPrefixes[i]) > len(rules.areaPrefixes[j])
+	})
+
+	return rules, nil
+}
+
+// PolicyFor returns the link policy for a geohash cell.
+func (r *LinkRules) PolicyFor(geohash string) LinkPolicy {
+	for _, prefix := range r.areaPrefixes {
+		if strings.HasPrefix(geohash, prefix) {
+			return r.areaPolicies[prefix]
+		}
+	}
+	return r.defaultPolicy
+}
+
+// Allowed reports whether a link to host may be posted under policy.
+// Blocklisted domains are never allowed.
+func (r *LinkRules) Allowed(host string, policy LinkPolicy) bool {
+	if r.blocklist.Contains(host) {
+		return false
+	}
+	switch policy {
+	case LinkPolicyNone:
+		return false
+	case LinkPolicyAllowlisted:
+		return r.allowlist.Contains(host)
+	}
+	return true
+}
+
+// Trusted reports whether host is allowlisted and not blocklisted.
+func (r *LinkRules) Trusted(host string) bool {
+	return r.allowlist.Contains(host) && !r.blocklist.Contains(host)
+}
+
+// rewriteLinks replaces the given links in text with linkPlaceholder.
+func rewriteLinks(text string, links []Link) string {
+	var b strings.Builder
+	last := 0
+	for _, link := range links {
+		b.WriteString(text[last:link.Start])
+		b.WriteString(linkPlaceholder)
+		last = link.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package spam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		text string
+		// want are the raw links found, and hosts their hosts
+		want  []string
+		hosts []string
+	}{
+		{"no links here", nil, nil},
+		{"see https://example.com/promo", []string{"https://example.com/promo"}, []string{"example.com"}},
+		{"visit www.Example.com, now", []string{"www.Example.com"}, []string{"www.example.com"}},
+		{"go to example.com/promo!", []string{"example.com/promo"}, []string{"example.com"}},
+		{"is it shop.example.co.uk?", []string{"shop.example.co.uk"}, []string{"shop.example.co.uk"}},
+		{"(see https://user:pw@Example.com:8080/x?y=1)", []string{"https://user:pw@Example.com:8080/x?y=1"}, []string{"example.com"}},
+		{`"http://example.net/a."`, []string{"http://example.net/a"}, []string{"example.net"}},
+		{"mail me at bob@example.com", nil, nil},
+		{"mail bob@example.com or see example.org.", []string{"example.org"}, []string{"example.org"}},
+		{"file.txt costs 3.50 and notes.md", nil, nil},
+		{"two: a.io and https://b.net/x;", []string{"a.io", "https://b.net/x"}, []string{"a.io", "b.net"}},
+	}
+
+	for _, tt := range tests {
+		links := ExtractLinks(tt.text)
+
+		var raw, hosts []string
+		for _, link := range links {
+			raw = append(raw, link.Raw)
+			hosts = append(hosts, link.Host)
+			if got := tt.text[link.Start:link.End]; got != link.Raw {
+				t.Errorf("%q: link %q spans %q", tt.text, link.Raw, got)
+			}
+		}
+		if !reflect.DeepEqual(raw, tt.want) {
+			t.Errorf("ExtractLinks(%q) = %q, want %q", tt.text, raw, tt.want)
+		}
+		if !reflect.DeepEqual(hosts, tt.hosts) {
+			t.Errorf("ExtractLinks(%q) hosts %q, want %q", tt.text, hosts, tt.hosts)
+		}
+	}
+}
+
+func TestDomainListContains(t *testing.T) {
+	list := NewDomainList([]string{"example.com", " WWW.News.org ", "*.trusted.net", ""})
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"shop.example.com", false},
+		{"notexample.com", false},
+		{"example.com.evil.io", false},
+		{"news.org", true},
+		{"www.news.org", true},
+		{"trusted.net", true},
+		{"www.trusted.net", true},
+		{"a.b.trusted.net", true},
+		{"untrusted.net", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := list.Contains(tt.host); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLinkRulesAllowed(t *testing.T) {
+	rules, err := NewLinkRules(
+		[]string{"*.example.com", "docs.org"},
+		[]string{"bad.example.com", "*.evil.io"},
+		"any", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host   string
+		policy LinkPolicy
+		want   bool
+	}{
+		{"example.com", LinkPolicyAllowlisted, true},
+		{"www.docs.org", LinkPolicyAllowlisted, true},
+		{"other.com", LinkPolicyAllowlisted, false},
+		{"other.com", LinkPolicyAny, true},
+		{"example.com", LinkPolicyNone, false},
+		// The blocklist wins over the allowlist, whatever the policy
+		{"bad.example.com", LinkPolicyAllowlisted, false},
+		{"bad.example.com", LinkPolicyAny, false},
+		{"cdn.evil.io", LinkPolicyAny, false},
+	}
+
+	for _, tt := range tests {
+		if got := rules.Allowed(tt.host, tt.policy); got != tt.want {
+			t.Errorf("Allowed(%q, %s) = %v, want %v", tt.host, tt.policy, got, tt.want)
+		}
+	}
+
+	for host, want := range map[string]bool{
+		"shop.example.com": true,
+		"bad.example.com":  false,
+		"other.com":        false,
+	} {
+		if got := rules.Trusted(host); got != want {
+			t.Errorf("Trusted(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestLinkRulesPolicyFor(t *testing.T) {
+	rules, err := NewLinkRules(nil, nil, "any", map[string]string{
+		"9q":     "allowlisted",
+		"9q8y":   "none",
+		"9q8yyk": "Any",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		geohash string
+		want    LinkPolicy
+	}{
+		{"u4pruy", LinkPolicyAny},
+		{"9q5ctr", LinkPolicyAllowlisted},
+		{"9q8yym", LinkPolicyNone},
+		{"9q8yyk", LinkPolicyAny},
+		{"9q8yykz", LinkPolicyAny},
+		{"9", LinkPolicyAny},
+	}
+
+	for _, tt := range tests {
+		if got := rules.PolicyFor(tt.geohash); got != tt.want {
+			t.Errorf("PolicyFor(%q) = %s, want %s", tt.geohash, got, tt.want)
+		}
+	}
+
+	if _, err := NewLinkRules(nil, nil, "any", map[string]string{"9q": "some"}); err == nil {
+		t.Error("NewLinkRules accepted an unknown area policy")
+	}
+	if _, err := NewLinkRules(nil, nil, "", nil); err == nil {
+		t.Error("NewLinkRules accepted an empty default policy")
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	text := "see example.com and https://b.net/x."
+	links := ExtractLinks(text)
+	want := "see [URL removed] and [URL removed]."
+	if got := rewriteLinks(text, links); got != want {
+		t.Errorf("rewriteLinks = %q, want %q", got, want)
+	}
+}
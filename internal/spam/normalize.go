@@ -0,0 +1,248 @@
+package spam
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalized holds the forms of a message that content rules inspect. The
+// message delivered to other users is never altered by normalization.
+type Normalized struct {
+	// Folded is NFKC-normalized with invisible characters and diacritics
+	// (but not the tilde of ñ) removed and look-alike letters folded to
+	// Latin. It keeps case, digits and punctuation, so structural checks
+	// (URLs, phones, caps) still work.
+	Folded string
+
+	// Canonical is Folded reduced to space-separated lowercase words with
+	// leetspeak decoded, spaced-out letters joined and long character runs
+	// shortened. Word-level checks (profanity, scam phrases) match it.
+	Canonical string
+}
+
+// Normalize prepares content for the content rules, undoing common tricks
+// used to slip past word filters.
+func Normalize(content string) Normalized {
+	folded := fold(content)
+	return Normalized{
+		Folded:    folded,
+		Canonical: strings.Join(canonicalTokens(folded), " "),
+	}
+}
+
+// fold applies NFKC, strips format characters (zero-width spaces and
+// joiners, soft hyphens, bidi controls) and combining marks, and maps
+// confusable Cyrillic and Greek letters to Latin. The tilde of ñ is kept:
+// it is a letter of its own, and without it "coño" would match "cono"
+// (cone).
+func fold(s string) string {
+	s = norm.NFKD.String(norm.NFKC.String(s))
+
+	var b strings.Builder
+	b.Grow(len(s))
+	var prev rune
+	for _, r := range s {
+		if r == '\u0303' && (prev == 'n' || prev == 'N') {
+			b.WriteRune(r)
+			prev = r
+			continue
+		}
+		if unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		prev = r
+		if latin, ok := confusables[r]; ok {
+			r = latin
+		}
+		b.WriteRune(r)
+	}
+
+	return norm.NFC.String(b.String())
+}
+
+// foldedText is fold's output with the offsets it came from in the
+// original, so a match found in the folded text can be replaced in the
+// original one.
+type foldedText struct {
+	text string
+
+	// folded and original are where each normalization segment starts in
+	// text and in the original, ending with both lengths
+	folded, original []int
+}
+
+// foldIndexed folds s one normalization segment at a time, recording where
+// each segment came from. Segments are independent under NFKC, so text is
+// what fold returns for all of s.
+func foldIndexed(s string) foldedText {
+	var f foldedText
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var iter norm.Iter
+	iter.InitString(norm.NFKC, s)
+	for !iter.Done() {
+		f.folded = append(f.folded, b.Len())
+		f.original = append(f.original, iter.Pos())
+		b.WriteString(fold(string(iter.Next())))
+	}
+	f.folded = append(f.folded, b.Len())
+	f.original = append(f.original, len(s))
+
+	f.text = b.String()
+	return f
+}
+
+// originalSpan returns the span of the original that text[start:end] was
+// folded from, widened to whole segments. Segments folded away entirely,
+// like zero-width spaces, are left out at either edge.
+func (f foldedText) originalSpan(start, end int) (int, int) {
+	// The last segment starting at or before start, and the first starting
+	// at or after end
+	i := sort.Search(len(f.folded), func(i int) bool { return f.folded[i] > start }) - 1
+	j := sort.SearchInts(f.folded, end)
+	return f.original[i], f.original[j]
+}
+
+// canonicalTokens lowercases folded text, decodes leetspeak, splits it into
+// words, joins runs of single characters ("p 0 r n") and shortens runs of
+// three or more repeated characters to two.
+func canonicalTokens(folded string) []string {
+	runes := []rune(strings.ToLower(folded))
+
+	// Symbols only count as letters next to a word, so "sh!t" and "a$$" are
+	// decoded but "hi!!!" keeps its punctuation
+	for i, r := range runes {
+		letter, ok := leetSymbols[r]
+		if !ok {
+			continue
+		}
+		prevWord := i > 0 && isWordRune(runes[i-1])
+		nextWord := i+1 < len(runes) && isWordRune(runes[i+1])
+		nextSymbol := i+1 < len(runes) && isLeetSymbol(runes[i+1])
+		if r == '!' {
+			// "!" is far more often punctuation, so it needs a letter after it
+			if nextWord {
+				runes[i] = letter
+			}
+			continue
+		}
+		if nextWord || nextSymbol || prevWord {
+			runes[i] = letter
+		}
+	}
+
+	words := strings.FieldsFunc(string(runes), func(r rune) bool {
+		return !isWordRune(r)
+	})
+
+	tokens := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		// Join three or more single characters in a row
+		j := i
+		for j < len(words) && len([]rune(words[j])) == 1 {
+			j++
+		}
+		if j-i >= 3 {
+			tokens = append(tokens, strings.Join(words[i:j], ""))
+			i = j
+			continue
+		}
+		tokens = append(tokens, words[i])
+		i++
+	}
+
+	for i, token := range tokens {
+		tokens[i] = collapseRepeats(decodeLeetDigits(token), 2)
+	}
+
+	return tokens
+}
+
+// decodeLeetDigits maps digits to letters in tokens that also contain
+// letters, leaving plain numbers alone.
+func decodeLeetDigits(token string) string {
+	hasLetter := strings.IndexFunc(token, unicode.IsLetter) >= 0
+	if !hasLetter {
+		return token
+	}
+	return strings.Map(func(r rune) rune {
+		if letter, ok := leetDigits[r]; ok {
+			return letter
+		}
+		return r
+	}, token)
+}
+
+// collapseRepeats shortens runs of the same character to at most max.
+func collapseRepeats(s string, max int) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var last rune
+	count := 0
+	for _, r := range s {
+		if r == last {
+			count++
+		} else {
+			last = r
+			count = 1
+		}
+		if count <= max {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+func isLeetSymbol(r rune) bool {
+	_, ok := leetSymbols[r]
+	return ok
+}
+
+var leetDigits = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'6': 'g',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+}
+
+var leetSymbols = map[rune]rune{
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+	'+': 't',
+	'€': 'e',
+	'£': 'l',
+}
+
+// confusables maps Cyrillic and Greek letters that render like Latin ones.
+// NFKC already handles fullwidth and mathematical alphanumeric forms.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'ɡ': 'g', 'ь': 'b',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I',
+	'Ј': 'J',
+	// Greek
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K',
+	'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
@@ -24,6 +24,13 @@ var (
 	scamPattern = regexp.MustCompile(`(?i)(click here|buy now|limited time|act now|guarantee|risk free|no obligation)`)
 )
 
+// Placeholders for content removed from messages
+const (
+	linkPlaceholder  = "[URL removed]"
+	emailPlaceholder = "[Email removed]"
+	phonePlaceholder = "[Phone removed]"
+)
+
 // Profanity/offensive words list (expand as needed)
 var profanityList = []string{
 	// Add common profanity words here
@@ -138,13 +145,13 @@ func hasExcessiveCaps(s string) bool {
 // SanitizeMessage removes or replaces suspicious content
 func SanitizeMessage(content string) string {
 	// Remove URLs
-	content = urlPattern.ReplaceAllString(content, "[URL removed]")
+	content = urlPattern.ReplaceAllString(content, linkPlaceholder)
 	
 	// Remove emails
-	content = emailPattern.ReplaceAllString(content, "[Email removed]")
+	content = emailPattern.ReplaceAllString(content, emailPlaceholder)
 	
 	// Remove phone numbers
-	content = phonePattern.ReplaceAllString(content, "[Phone removed]")
+	content = phonePattern.ReplaceAllString(content, phonePlaceholder)
 	
 	return strings.TrimSpace(content)
 }
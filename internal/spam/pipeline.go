@@ -0,0 +1,253 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Action is what should happen to a message. Stronger actions have higher
+// values, and a decision takes the strongest action any rule asked for.
+type Action int
+
+const (
+	ActionAllow Action = iota
+	ActionFlag
+	ActionSanitize
+	ActionReject
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionFlag:
+		return "flag"
+	case ActionSanitize:
+		return "sanitize"
+	case ActionReject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// ReasonCode is a stable, machine-readable identifier for why a rule fired.
+type ReasonCode string
+
+const (
+	ReasonEmpty         ReasonCode = "empty"
+	ReasonTooShort      ReasonCode = "too_short"
+	ReasonTooLong       ReasonCode = "too_long"
+	ReasonProfanity     ReasonCode = "profanity"
+	ReasonTooManyURLs   ReasonCode = "too_many_urls"
+	ReasonURL           ReasonCode = "contains_url"
+	ReasonLinkRemoved   ReasonCode = "link_removed"
+	ReasonEmail         ReasonCode = "contains_email"
+	ReasonPhone         ReasonCode = "contains_phone"
+	ReasonExcessiveCaps ReasonCode = "excessive_caps"
+	ReasonRepeatedChars ReasonCode = "excessive_repeated_chars"
+	ReasonScamPhrase    ReasonCode = "suspicious_promotional_content"
+	ReasonMoneyMention  ReasonCode = "suspicious_money_mention"
+	ReasonClassifier    ReasonCode = "classifier_spam"
+	ReasonDuplicate     ReasonCode = "duplicate"
+	ReasonFlood         ReasonCode = "area_flood"
+	ReasonSpamScore     ReasonCode = "spam_score"
+)
+
+// Message is a chat message as seen by the rules.
+type Message struct {
+	SessionID string
+	Geohash   string
+	Content   string
+
+	// ThresholdMultiplier scales the flag and reject scores for this
+	// message, e.g. by the sender's reputation. Zero means 1.
+	ThresholdMultiplier float64
+
+	// Normalized is derived from Content by the pipeline
+	Normalized Normalized
+}
+
+// Verdict is the outcome of a single rule.
+type Verdict struct {
+	Rule   string     `json:"rule"`
+	Reason ReasonCode `json:"reason"`
+	Action Action     `json:"action"`
+	Score  int        `json:"score"`
+	Detail string     `json:"detail,omitempty"`
+
+	// Related lists other sessions implicated by the verdict, such as the
+	// other senders of a flood
+	Related []string `json:"related,omitempty"`
+
+	// Sanitized replaces the content when Action is ActionSanitize
+	Sanitized string `json:"-"`
+}
+
+// Rule inspects a message and returns a verdict, or nil if it has nothing to
+// report.
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, msg *Message) (*Verdict, error)
+}
+
+// Decision is the combined outcome of all rules for a message.
+type Decision struct {
+	Action   Action    `json:"action"`
+	Score    int       `json:"score"`
+	Flagged  bool      `json:"flagged"`
+	Content  string    `json:"-"`
+	Verdicts []Verdict `json:"verdicts"`
+}
+
+// Reason returns the code of the verdict that decided the action.
+func (d *Decision) Reason() ReasonCode {
+	if v := d.decisive(); v != nil {
+		return v.Reason
+	}
+	return ""
+}
+
+// Message returns a human-readable explanation of the decision.
+func (d *Decision) Message() string {
+	if v := d.decisive(); v != nil && v.Detail != "" {
+		return v.Detail
+	}
+	return string(d.Reason())
+}
+
+// ViolationType is the violation bucket a rejected message counts towards.
+func (d *Decision) ViolationType() string {
+	if d.Reason() == ReasonProfanity {
+		return "profanity"
+	}
+	return "spam"
+}
+
+// RelatedSessions returns the other sessions implicated in the decision.
+func (d *Decision) RelatedSessions() []string {
+	if v := d.decisive(); v != nil {
+		return v.Related
+	}
+	return nil
+}
+
+func (d *Decision) decisive() *Verdict {
+	for i := range d.Verdicts {
+		if d.Verdicts[i].Action == d.Action {
+			return &d.Verdicts[i]
+		}
+	}
+	return nil
+}
+
+// PipelineConfig holds the scoring thresholds and per-rule weights.
+type PipelineConfig struct {
+	// FlagScore flags messages whose weighted score reaches it
+	FlagScore int
+	// RejectScore rejects messages whose weighted score reaches it
+	RejectScore int
+	// Weights scales each rule's score by rule name. Missing rules weigh 1
+	// and a weight of 0 disables the rule.
+	Weights map[string]float64
+}
+
+// Pipeline runs rules in order and combines their verdicts.
+type Pipeline struct {
+	rules  []Rule
+	config PipelineConfig
+}
+
+func NewPipeline(config PipelineConfig, rules ...Rule) *Pipeline {
+	return &Pipeline{
+		rules:  rules,
+		config: config,
+	}
+}
+
+// Evaluate runs every rule against the message. A rejecting rule stops the
+// pipeline; sanitizing rules rewrite the content seen by later rules.
+func (p *Pipeline) Evaluate(ctx context.Context, msg *Message) (*Decision, error) {
+	current := *msg
+	current.Normalized = Normalize(current.Content)
+	decision := &Decision{Action: ActionAllow}
+
+	for _, rule := range p.rules {
+		weight, ok := p.config.Weights[rule.Name()]
+		if !ok {
+			weight = 1
+		}
+		if weight == 0 {
+			continue
+		}
+
+		verdict, err := rule.Evaluate(ctx, &current)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name(), err)
+		}
+		if verdict == nil {
+			continue
+		}
+
+		verdict.Rule = rule.Name()
+		verdict.Score = int(float64(verdict.Score) * weight)
+		decision.Verdicts = append(decision.Verdicts, *verdict)
+		decision.Score += verdict.Score
+
+		if verdict.Action == ActionFlag {
+			decision.Flagged = true
+		}
+		if verdict.Action == ActionSanitize {
+			current.Content = verdict.Sanitized
+			current.Normalized = Normalize(current.Content)
+		}
+		if verdict.Action > decision.Action {
+			decision.Action = verdict.Action
+		}
+		if verdict.Action == ActionReject {
+			break
+		}
+	}
+
+	if decision.Score > 100 {
+		decision.Score = 100
+	}
+
+	rejectScore := scaleThreshold(p.config.RejectScore, msg.ThresholdMultiplier)
+	flagScore := scaleThreshold(p.config.FlagScore, msg.ThresholdMultiplier)
+
+	if decision.Action < ActionReject && rejectScore > 0 && decision.Score >= rejectScore {
+		decision.Action = ActionReject
+		decision.Verdicts = append(decision.Verdicts, Verdict{
+			Rule:   "score",
+			Reason: ReasonSpamScore,
+			Action: ActionReject,
+			Score:  decision.Score,
+			Detail: "message looks like spam",
+		})
+	} else if flagScore > 0 && decision.Score >= flagScore {
+		decision.Flagged = true
+	}
+
+	decision.Content = current.Content
+	return decision, nil
+}
+
+// scaleThreshold multiplies a score threshold, keeping it within 1-100. A
+// disabled (zero) threshold stays disabled.
+func scaleThreshold(threshold int, multiplier float64) int {
+	if threshold <= 0 || multiplier <= 0 {
+		return threshold
+	}
+	scaled := int(math.Round(float64(threshold) * multiplier))
+	if scaled < 1 {
+		return 1
+	}
+	if scaled > 100 {
+		return 100
+	}
+	return scaled
+}
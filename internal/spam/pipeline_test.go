@@ -0,0 +1,277 @@
+package spam
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// stubRule returns a fixed verdict, or none, and records its calls.
+type stubRule struct {
+	name    string
+	action  Action
+	score   int
+	reason  ReasonCode
+	rewrite string
+	// verdict is false for a rule with nothing to report
+	verdict bool
+
+	calls int
+	seen  string
+}
+
+func (r *stubRule) Name() string { return r.name }
+
+func (r *stubRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	r.calls++
+	r.seen = msg.Content
+	if !r.verdict {
+		return nil, nil
+	}
+	return &Verdict{Reason: r.reason, Action: r.action, Score: r.score, Sanitized: r.rewrite}, nil
+}
+
+func scored(name string, score int) *stubRule {
+	return &stubRule{name: name, action: ActionAllow, score: score, reason: ReasonCode(name), verdict: true}
+}
+
+func TestPipelineEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     PipelineConfig
+		rules      []*stubRule
+		multiplier float64
+
+		wantAction  Action
+		wantScore   int
+		wantFlagged bool
+		// wantReason is checked unless the message is allowed
+		wantReason ReasonCode
+		// skipped names rules that should not have been evaluated
+		skipped []string
+	}{
+		{
+			name:       "nothing to report",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:      []*stubRule{{name: "a"}},
+			wantAction: ActionAllow,
+		},
+		{
+			name:       "scores add up under the flag score",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:      []*stubRule{scored("a", 15), scored("b", 20)},
+			wantAction: ActionAllow,
+			wantScore:  35,
+		},
+		{
+			name:        "flag score",
+			config:      PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:       []*stubRule{scored("a", 20), scored("b", 20)},
+			wantAction:  ActionAllow,
+			wantScore:   40,
+			wantFlagged: true,
+		},
+		{
+			name:       "reject score",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:      []*stubRule{scored("a", 40), scored("b", 30)},
+			wantAction: ActionReject,
+			wantScore:  70,
+			wantReason: ReasonSpamScore,
+		},
+		{
+			name:        "weight scales a score",
+			config:      PipelineConfig{FlagScore: 40, RejectScore: 70, Weights: map[string]float64{"a": 2.5}},
+			rules:       []*stubRule{scored("a", 20)},
+			wantAction:  ActionAllow,
+			wantScore:   50,
+			wantFlagged: true,
+		},
+		{
+			name:       "weight below one",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 70, Weights: map[string]float64{"a": 0.5}},
+			rules:      []*stubRule{scored("a", 60), scored("b", 10)},
+			wantAction: ActionAllow,
+			wantScore:  40,
+			// 0.5 * 60 + 10 reaches the flag score
+			wantFlagged: true,
+		},
+		{
+			name:   "weight 0 disables a rule",
+			config: PipelineConfig{FlagScore: 40, RejectScore: 70, Weights: map[string]float64{"a": 0}},
+			rules: []*stubRule{
+				{name: "a", action: ActionReject, reason: ReasonProfanity, score: 100, verdict: true},
+				scored("b", 10),
+			},
+			wantAction: ActionAllow,
+			wantScore:  10,
+			skipped:    []string{"a"},
+		},
+		{
+			name:   "rejecting rule stops the pipeline",
+			config: PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules: []*stubRule{
+				{name: "a", action: ActionReject, reason: ReasonProfanity, score: 10, verdict: true},
+				scored("b", 80),
+			},
+			wantAction: ActionReject,
+			wantScore:  10,
+			wantReason: ReasonProfanity,
+			skipped:    []string{"b"},
+		},
+		{
+			name:        "flagging rule",
+			config:      PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:       []*stubRule{{name: "a", action: ActionFlag, reason: ReasonURL, score: 5, verdict: true}},
+			wantAction:  ActionFlag,
+			wantScore:   5,
+			wantFlagged: true,
+			wantReason:  ReasonURL,
+		},
+		{
+			name:       "score capped at 100",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 0},
+			rules:      []*stubRule{scored("a", 80), scored("b", 80)},
+			wantAction: ActionAllow,
+			wantScore:  100,
+			// The reject score is disabled
+			wantFlagged: true,
+		},
+		{
+			name:       "flag score disabled",
+			config:     PipelineConfig{FlagScore: 0, RejectScore: 70},
+			rules:      []*stubRule{scored("a", 50)},
+			wantAction: ActionAllow,
+			wantScore:  50,
+		},
+		{
+			name:       "lower thresholds for a distrusted sender",
+			config:     PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:      []*stubRule{scored("a", 35)},
+			multiplier: 0.5,
+			wantAction: ActionReject,
+			wantScore:  35,
+			wantReason: ReasonSpamScore,
+		},
+		{
+			name:        "higher thresholds for a trusted sender",
+			config:      PipelineConfig{FlagScore: 40, RejectScore: 70},
+			rules:       []*stubRule{scored("a", 90)},
+			multiplier:  2,
+			wantAction:  ActionAllow,
+			wantScore:   90,
+			wantFlagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := make([]Rule, len(tt.rules))
+			for i, rule := range tt.rules {
+				rules[i] = rule
+			}
+			pipeline := NewPipeline(tt.config, rules...)
+
+			decision, err := pipeline.Evaluate(context.Background(), &Message{
+				Content:             "hello",
+				ThresholdMultiplier: tt.multiplier,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decision.Action != tt.wantAction {
+				t.Errorf("action %s, want %s", decision.Action, tt.wantAction)
+			}
+			if decision.Score != tt.wantScore {
+				t.Errorf("score %d, want %d", decision.Score, tt.wantScore)
+			}
+			if decision.Flagged != tt.wantFlagged {
+				t.Errorf("flagged %v, want %v", decision.Flagged, tt.wantFlagged)
+			}
+			// An allowed message has no deciding reason worth checking
+			if got := decision.Reason(); tt.wantAction != ActionAllow && got != tt.wantReason {
+				t.Errorf("reason %q, want %q", got, tt.wantReason)
+			}
+
+			for _, rule := range tt.rules {
+				skipped := false
+				for _, name := range tt.skipped {
+					skipped = skipped || name == rule.name
+				}
+				if skipped && rule.calls != 0 {
+					t.Errorf("rule %s evaluated, want skipped", rule.name)
+				}
+				if !skipped && rule.calls != 1 {
+					t.Errorf("rule %s evaluated %d times, want once", rule.name, rule.calls)
+				}
+			}
+		})
+	}
+}
+
+// TestPipelineSanitize checks a sanitizing rule's content is what later
+// rules see and what the decision carries.
+func TestPipelineSanitize(t *testing.T) {
+	sanitize := &stubRule{name: "a", action: ActionSanitize, reason: ReasonEmail, rewrite: "[email removed]", verdict: true}
+	after := scored("b", 0)
+	pipeline := NewPipeline(PipelineConfig{FlagScore: 40, RejectScore: 70}, sanitize, after)
+
+	decision, err := pipeline.Evaluate(context.Background(), &Message{Content: "mail me at a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Action != ActionSanitize {
+		t.Errorf("action %s, want sanitize", decision.Action)
+	}
+	if after.seen != "[email removed]" {
+		t.Errorf("later rule saw %q, want the sanitized content", after.seen)
+	}
+	if decision.Content != "[email removed]" {
+		t.Errorf("decision content %q, want the sanitized content", decision.Content)
+	}
+	if len(decision.Verdicts) != 2 || decision.Verdicts[0].Rule != "a" || decision.Verdicts[1].Rule != "b" {
+		t.Errorf("verdicts %+v, want one per rule named after it", decision.Verdicts)
+	}
+}
+
+func TestScaleThreshold(t *testing.T) {
+	tests := []struct {
+		threshold  int
+		multiplier float64
+		want       int
+	}{
+		{70, 1, 70},
+		{70, 0, 70},
+		{70, -1, 70},
+		{70, 0.5, 35},
+		{40, 1.5, 60},
+		{70, 2, 100},
+		{1, 0.1, 1},
+		{3, 0.5, 2},
+		{0, 2, 0},
+		{-1, 2, -1},
+	}
+
+	for _, tt := range tests {
+		if got := scaleThreshold(tt.threshold, tt.multiplier); got != tt.want {
+			t.Errorf("scaleThreshold(%d, %g) = %d, want %d", tt.threshold, tt.multiplier, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineRuleError(t *testing.T) {
+	pipeline := NewPipeline(PipelineConfig{}, failingRule{})
+	_, err := pipeline.Evaluate(context.Background(), &Message{Content: "hello"})
+	if err == nil || !strings.Contains(err.Error(), "rule failing") {
+		t.Errorf("error %v, want it to name the rule", err)
+	}
+}
+
+type failingRule struct{}
+
+func (failingRule) Name() string { return "failing" }
+
+func (failingRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	return nil, context.DeadlineExceeded
+}
@@ -1,13 +1,283 @@
 package spam
 
-// loadProfanityList returns a basic list of profanity words
-// In production, you should load this from a configurable external source
-func loadProfanityList() []string {
-	return []string{
-		// Add your profanity words here
-		// This is intentionally kept minimal for the example
-		"porn",
-		"dick",
-		// We can expand this list or load from a file/database
-	}
-}
\ No newline at end of file
+import (
+	"bufio"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+//go:embed wordlists/*.txt
+var defaultWordLists embed.FS
+
+// profanityMatcher matches whole words and multi-word phrases.
+type profanityMatcher struct {
+	phrases   map[string][][]string // first token -> phrases starting with it
+	allowlist map[string][][]string
+	size      int
+}
+
+func newProfanityMatcher() *profanityMatcher {
+	return &profanityMatcher{
+		phrases:   make(map[string][][]string),
+		allowlist: make(map[string][][]string),
+	}
+}
+
+func (m *profanityMatcher) add(phrase string) {
+	if tokens := canonicalTokens(fold(phrase)); len(tokens) > 0 {
+		m.phrases[tokens[0]] = append(m.phrases[tokens[0]], tokens)
+		m.size++
+	}
+}
+
+func (m *profanityMatcher) allow(phrase string) {
+	if tokens := canonicalTokens(fold(phrase)); len(tokens) > 0 {
+		m.allowlist[tokens[0]] = append(m.allowlist[tokens[0]], tokens)
+	}
+}
+
+// matches reports whether canonical text (see Normalize) contains a listed
+// phrase on word boundaries that is not covered by an allowlisted phrase.
+func (m *profanityMatcher) matches(canonical string) bool {
+	tokens := strings.Fields(canonical)
+	collapsed := make([]string, len(tokens))
+	for i, token := range tokens {
+		collapsed[i] = collapseRepeats(token, 1)
+	}
+
+	allowed := make([]bool, len(tokens))
+	for i := range tokens {
+		for _, phrase := range m.candidates(m.allowlist, tokens[i], collapsed[i]) {
+			if hasPhraseAt(tokens, collapsed, i, phrase) {
+				for j := range phrase {
+					allowed[i+j] = true
+				}
+			}
+		}
+	}
+
+	for i := range tokens {
+		for _, phrase := range m.candidates(m.phrases, tokens[i], collapsed[i]) {
+			if hasPhraseAt(tokens, collapsed, i, phrase) && !allCovered(allowed[i:i+len(phrase)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// candidates returns the phrases starting with token, or with token's
+// collapsed form so "fuuuck" finds "fuck" without "as" finding "ass".
+func (m *profanityMatcher) candidates(index map[string][][]string, token, collapsed string) [][]string {
+	if collapsed == token {
+		return index[token]
+	}
+	return append(index[token][:len(index[token]):len(index[token])], index[collapsed]...)
+}
+
+func hasPhraseAt(tokens, collapsed []string, i int, phrase []string) bool {
+	if i+len(phrase) > len(tokens) {
+		return false
+	}
+	for j, word := range phrase {
+		if tokens[i+j] != word && collapsed[i+j] != word {
+			return false
+		}
+	}
+	return true
+}
+
+func allCovered(flags []bool) bool {
+	for _, f := range flags {
+		if !f {
+			return false
+		}
+	}
+	return true
+}
+
+// loadProfanityMatcher builds a matcher from per-language word list files
+// and an allowlist file. Without configured files it falls back to the word
+// lists embedded in the binary.
+func loadProfanityMatcher(files map[string]string, allowlistFile string) (*profanityMatcher, error) {
+	matcher := newProfanityMatcher()
+
+	if len(files) == 0 {
+		entries, err := defaultWordLists.ReadDir("wordlists")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Name() == "allowlist.txt" {
+				continue
+			}
+			if err := readDefaultWordList(entry.Name(), matcher.add); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Load languages in a stable order so errors are reproducible
+	languages := make([]string, 0, len(files))
+	for lang := range files {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	for _, lang := range languages {
+		if err := readWordListFile(files[lang], matcher.add); err != nil {
+			return nil, fmt.Errorf("failed to load %s profanity list: %w", lang, err)
+		}
+	}
+
+	if allowlistFile != "" {
+		if err := readWordListFile(allowlistFile, matcher.allow); err != nil {
+			return nil, fmt.Errorf("failed to load profanity allowlist: %w", err)
+		}
+	} else if len(files) == 0 {
+		if err := readDefaultWordList("allowlist.txt", matcher.allow); err != nil {
+			return nil, err
+		}
+	}
+
+	return matcher, nil
+}
+
+func readWordListFile(path string, add func(string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readWordList(file, add)
+}
+
+func readDefaultWordList(name string, add func(string)) error {
+	file, err := defaultWordLists.Open("wordlists/" + name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readWordList(file, add)
+}
+
+// readWordList calls add for every non-empty, non-comment line.
+func readWordList(r io.Reader, add func(string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		add(line)
+	}
+	return scanner.Err()
+}
+
+// ReloadProfanity reloads the profanity word lists and allowlist from disk.
+// The current lists stay in place if loading fails.
+func (d *Detector) ReloadProfanity() error {
+	matcher, err := loadProfanityMatcher(d.profanityFiles, d.allowlistFile)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.profanity = matcher
+	d.mu.Unlock()
+
+	return nil
+}
+
+// WatchProfanityLists reloads the profanity lists whenever one of the files
+// changes or the process receives SIGHUP, until ctx is cancelled.
+func (d *Detector) WatchProfanityLists(ctx context.Context, log logger.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+
+	watched := d.watchedFiles()
+	if len(watched) > 0 {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Error("Failed to watch profanity lists", "error", err)
+		} else {
+			defer watcher.Close()
+			// Watch directories rather than files so editors that replace
+			// files by renaming are picked up too
+			for dir := range watchedDirs(watched) {
+				if err := watcher.Add(dir); err != nil {
+					log.Error("Failed to watch profanity list directory", "dir", dir, "error", err)
+				}
+			}
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	// Editors often emit several events per save, so reloads are debounced
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	reload := func(trigger string) {
+		if err := d.ReloadProfanity(); err != nil {
+			log.Error("Failed to reload profanity lists", "trigger", trigger, "error", err)
+			return
+		}
+		d.mu.RLock()
+		size := d.profanity.size
+		d.mu.RUnlock()
+		log.Info("Reloaded profanity lists", "trigger", trigger, "entries", size)
+	}
+
+	for {
+		select {
+		case <-hup:
+			reload("SIGHUP")
+		case event := <-events:
+			if _, ok := watched[filepath.Clean(event.Name)]; ok {
+				debounce.Reset(500 * time.Millisecond)
+			}
+		case err := <-errs:
+			log.Error("Profanity list watcher error", "error", err)
+		case <-debounce.C:
+			reload("file change")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Detector) watchedFiles() map[string]struct{} {
+	files := make(map[string]struct{})
+	for _, path := range d.profanityFiles {
+		files[filepath.Clean(path)] = struct{}{}
+	}
+	if d.allowlistFile != "" {
+		files[filepath.Clean(d.allowlistFile)] = struct{}{}
+	}
+	return files
+}
+
+func watchedDirs(files map[string]struct{}) map[string]struct{} {
+	dirs := make(map[string]struct{})
+	for path := range files {
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	return dirs
+}
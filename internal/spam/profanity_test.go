@@ -0,0 +1,144 @@
+package spam
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/config"
+	"github.com/askwhyharsh/neartalk/pkg/logger"
+)
+
+// TestEvasionCorpus checks the embedded word lists catch every corpus entry
+// labeled "profanity" and leave every "clean" one alone.
+func TestEvasionCorpus(t *testing.T) {
+	detector, err := NewDetector(nil, config.SpamConfig{
+		ProfanityEnabled: true,
+		LinkPolicy:       "any",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readCorpus(t, "testdata/evasion_corpus.jsonl")
+	if len(entries) == 0 {
+		t.Fatal("corpus is empty")
+	}
+
+	for _, entry := range entries {
+		t.Run(entry.Note, func(t *testing.T) {
+			if entry.Label != "profanity" && entry.Label != "clean" {
+				t.Fatalf("%q: unknown label %q", entry.Text, entry.Label)
+			}
+			want := entry.Label == "profanity"
+			if got := detector.ContainsProfanity(entry.Text); got != want {
+				t.Errorf("ContainsProfanity(%q) = %v, want %v (canonical %q)",
+					entry.Text, got, want, Normalize(entry.Text).Canonical)
+			}
+		})
+	}
+}
+
+// writeWordList writes a word list file into dir and returns its path.
+func writeWordList(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newFileDetector(t *testing.T, dir string) *Detector {
+	t.Helper()
+
+	detector, err := NewDetector(nil, config.SpamConfig{
+		ProfanityEnabled: true,
+		ProfanityFiles: map[string]string{
+			"en": writeWordList(t, dir, "en.txt", "# English\nflip\nbloody hell\n"),
+			"es": writeWordList(t, dir, "es.txt", "caramba\n"),
+		},
+		ProfanityAllowlistFile: writeWordList(t, dir, "allowlist.txt", "flip flop\n"),
+		LinkPolicy:             "any",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return detector
+}
+
+func TestProfanityFiles(t *testing.T) {
+	detector := newFileDetector(t, t.TempDir())
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"oh flip", true},
+		{"FLIP!", true},
+		// Whole words only
+		{"flipper", false},
+		// Phrases match as a whole
+		{"bloody hell", true},
+		{"bloody nice", false},
+		// Every language's list is loaded
+		{"ay caramba", true},
+		// The allowlist covers the phrase, but not the word on its own
+		{"flip flop", false},
+		{"flip flop flip", true},
+		// Configured files replace the embedded lists
+		{"bastard", false},
+	}
+
+	for _, tt := range tests {
+		if got := detector.ContainsProfanity(tt.text); got != tt.want {
+			t.Errorf("ContainsProfanity(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	_, err := NewDetector(nil, config.SpamConfig{
+		ProfanityFiles: map[string]string{"en": filepath.Join(t.TempDir(), "missing.txt")},
+		LinkPolicy:     "any",
+	}, nil)
+	if err == nil {
+		t.Error("NewDetector accepted a missing word list")
+	}
+}
+
+func TestProfanityReload(t *testing.T) {
+	dir := t.TempDir()
+	detector := newFileDetector(t, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go detector.WatchProfanityLists(ctx, logger.Discard)
+
+	// Give the watcher time to start before the file changes
+	time.Sleep(100 * time.Millisecond)
+	writeWordList(t, dir, "en.txt", "flip\nfrak\n")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !detector.ContainsProfanity("what the frak") {
+		if time.Now().After(deadline) {
+			t.Fatal("new word not matched after the file changed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if detector.ContainsProfanity("bloody hell") {
+		t.Error("word removed from the file still matched after the reload")
+	}
+
+	// A list that fails to load leaves the current one in place
+	if err := os.Remove(filepath.Join(dir, "es.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := detector.ReloadProfanity(); err == nil {
+		t.Error("ReloadProfanity succeeded with a missing word list")
+	}
+	if !detector.ContainsProfanity("frak") || !detector.ContainsProfanity("caramba") {
+		t.Error("failed reload replaced the loaded lists")
+	}
+}
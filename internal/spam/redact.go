@@ -0,0 +1,155 @@
+package spam
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// RedactionMode controls how personal contact info is masked.
+type RedactionMode string
+
+const (
+	// RedactionOff leaves contact info untouched
+	RedactionOff RedactionMode = "off"
+	// RedactionPlaceholder replaces contact info with placeholders, like
+	// SanitizeMessage
+	RedactionPlaceholder RedactionMode = "placeholder"
+	// RedactionPartial keeps just enough to recognise the contact, e.g.
+	// "j***@example.com" or "********67"
+	RedactionPartial RedactionMode = "partial"
+)
+
+func ParseRedactionMode(s string) (RedactionMode, error) {
+	switch mode := RedactionMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case RedactionOff, RedactionPlaceholder, RedactionPartial:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid redaction mode %q (want off, placeholder or partial)", s)
+}
+
+// Kinds of personal info the redactor masks.
+const (
+	PIIEmail = "email"
+	PIIPhone = "phone"
+)
+
+// Redactor masks email addresses and phone numbers in chat messages.
+type Redactor struct {
+	mode RedactionMode
+}
+
+// NewRedactor creates a redactor.
+func NewRedactor(mode string) (*Redactor, error) {
+	parsed, err := ParseRedactionMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	return &Redactor{mode: parsed}, nil
+}
+
+// Redact returns content with contact info masked and the kinds of info that
+// were found, or content unchanged and nil if nothing was redacted.
+//
+// Contacts are looked for in the folded text (see Normalize), so fullwidth
+// digits, look-alike letters and zero-width characters do not hide them, and
+// masked where they came from in content. Partial masks show the folded
+// form. Contacts spelled out in words ("john at example dot com") are not
+// caught.
+func (r *Redactor) Redact(content string) (string, []string) {
+	if r.mode == RedactionOff {
+		return content, nil
+	}
+
+	folded := foldIndexed(content)
+	text := folded.text
+
+	var kinds []string
+	var matches []piiMatch
+
+	emails := emailPattern.FindAllStringIndex(text, -1)
+	if len(emails) > 0 {
+		kinds = append(kinds, PIIEmail)
+	}
+	// Emails are blanked out first, so the phone pattern does not take
+	// digits inside them
+	blanked := []byte(text)
+	for _, loc := range emails {
+		matches = append(matches, piiMatch{loc[0], loc[1], r.maskEmail(text[loc[0]:loc[1]])})
+		for i := loc[0]; i < loc[1]; i++ {
+			blanked[i] = 0
+		}
+	}
+
+	phones := phonePattern.FindAllIndex(blanked, -1)
+	if len(phones) > 0 {
+		kinds = append(kinds, PIIPhone)
+	}
+	for _, loc := range phones {
+		matches = append(matches, piiMatch{loc[0], loc[1], r.maskPhone(text[loc[0]:loc[1]])})
+	}
+
+	if len(matches) == 0 {
+		return content, nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := folded.originalSpan(match.start, match.end)
+		if start < last {
+			// Widened into the segment of the match before it
+			continue
+		}
+		b.WriteString(content[last:start])
+		b.WriteString(match.mask)
+		last = end
+	}
+	b.WriteString(content[last:])
+
+	return b.String(), kinds
+}
+
+// piiMatch is contact info found in folded text and what replaces it.
+type piiMatch struct {
+	start, end int
+	mask       string
+}
+
+func (r *Redactor) maskEmail(email string) string {
+	if r.mode == RedactionPlaceholder {
+		return emailPlaceholder
+	}
+	local, domain, _ := strings.Cut(email, "@")
+	first := []rune(local)[0]
+	return string(first) + "***@" + domain
+}
+
+// maskPhone hides every digit of a phone number but the last two.
+func (r *Redactor) maskPhone(phone string) string {
+	if r.mode == RedactionPlaceholder {
+		return phonePlaceholder
+	}
+
+	digits := 0
+	for _, c := range phone {
+		if unicode.IsDigit(c) {
+			digits++
+		}
+	}
+
+	var b strings.Builder
+	seen := 0
+	for _, c := range phone {
+		if unicode.IsDigit(c) {
+			seen++
+			if seen <= digits-2 {
+				c = '*'
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
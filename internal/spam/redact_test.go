@@ -0,0 +1,77 @@
+package spam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		mode      string
+		content   string
+		want      string
+		wantKinds []string
+	}{
+		{"placeholder", "hello there", "hello there", nil},
+		{"placeholder", "mail john@example.com", "mail [Email removed]", []string{PIIEmail}},
+		{"placeholder", "call 555-123-4567 now", "call [Phone removed] now", []string{PIIPhone}},
+		{"placeholder", "john@example.com or 555.123.4567",
+			"[Email removed] or [Phone removed]", []string{PIIEmail, PIIPhone}},
+		// The digits belong to the email, which is masked first
+		{"placeholder", "bob5551234567@example.com", "[Email removed]", []string{PIIEmail}},
+
+		{"partial", "mail john@example.com", "mail j***@example.com", []string{PIIEmail}},
+		{"partial", "call 555-123-4567", "call ***-***-**67", []string{PIIPhone}},
+		{"partial", "call +1 (555) 123-4567", "call +* (***) ***-**67", []string{PIIPhone}},
+		{"partial", "bob5551234567@example.com", "b***@example.com", []string{PIIEmail}},
+		{"partial", "a@b.io and 5551234567", "a***@b.io and ********67", []string{PIIEmail, PIIPhone}},
+
+		// Obfuscated contacts are found in the folded text and masked
+		// where they are in the original
+		{"placeholder", "mail john\u200b@example.com now", "mail [Email removed] now", []string{PIIEmail}},
+		{"placeholder", "mail јohn@еxample.com", "mail [Email removed]", []string{PIIEmail}},
+		{"placeholder", "café: call ５５５-１２３-４５６７", "café: call [Phone removed]", []string{PIIPhone}},
+		{"placeholder", "call 555\u200b-123\u200b-4567\u200b!", "call [Phone removed]\u200b!", []string{PIIPhone}},
+		{"partial", "mail јohn@еxample.com", "mail j***@example.com", []string{PIIEmail}},
+		{"partial", "call ５５５１２３４５６７ now", "call ********67 now", []string{PIIPhone}},
+		// Spelled out contacts are not caught
+		{"placeholder", "john at example dot com", "john at example dot com", nil},
+
+		{"off", "mail john@example.com or 555-123-4567", "mail john@example.com or 555-123-4567", nil},
+		{" Partial ", "5551234567", "********67", []string{PIIPhone}},
+	}
+
+	for _, tt := range tests {
+		redactor, err := NewRedactor(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, kinds := redactor.Redact(tt.content)
+		if got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.mode, tt.content, got, tt.want)
+		}
+		if !reflect.DeepEqual(kinds, tt.wantKinds) {
+			t.Errorf("%s: Redact(%q) kinds %v, want %v", tt.mode, tt.content, kinds, tt.wantKinds)
+		}
+	}
+}
+
+func TestParseRedactionMode(t *testing.T) {
+	if _, err := NewRedactor("mask"); err == nil {
+		t.Error("NewRedactor accepted an unknown mode")
+	}
+	if _, err := NewRedactor(""); err == nil {
+		t.Error("NewRedactor accepted an empty mode")
+	}
+}
+
+// TestFoldIndexed checks folding segment by segment gives the same text as
+// folding the whole message, which Redact relies on.
+func TestFoldIndexed(t *testing.T) {
+	for _, entry := range readCorpus(t, "testdata/evasion_corpus.jsonl") {
+		if got, want := foldIndexed(entry.Text).text, fold(entry.Text); got != want {
+			t.Errorf("foldIndexed(%q) = %q, want %q", entry.Text, got, want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// lengthRule rejects empty and oversized messages.
+type lengthRule struct {
+	maxLength int
+}
+
+func (r *lengthRule) Name() string { return "length" }
+
+func (r *lengthRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if len(msg.Content) < 1 {
+		return &Verdict{Reason: ReasonTooShort, Action: ActionReject, Detail: "message too short"}, nil
+	}
+	if len(msg.Content) > r.maxLength {
+		return &Verdict{Reason: ReasonTooLong, Action: ActionReject, Detail: fmt.Sprintf("message too long (max %d characters)", r.maxLength)}, nil
+	}
+	if strings.TrimSpace(msg.Content) == "" {
+		return &Verdict{Reason: ReasonEmpty, Action: ActionReject, Detail: "message cannot be empty"}, nil
+	}
+	return nil, nil
+}
+
+// profanityRule rejects messages containing words from the detector's list.
+type profanityRule struct {
+	detector *Detector
+}
+
+func (r *profanityRule) Name() string { return "profanity" }
+
+func (r *profanityRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if !r.detector.profanityEnabled || !r.detector.containsProfanity(msg.Normalized.Canonical) {
+		return nil, nil
+	}
+	return &Verdict{Reason: ReasonProfanity, Action: ActionReject, Score: 10, Detail: "message contains profanity"}, nil
+}
+
+// linkRule applies the area's link policy. Disallowed links are replaced
+// with a placeholder rather than rejecting the message, and the message is
+// only rejected if too many allowed links remain. Links to allowlisted
+// domains are not scored.
+type linkRule struct {
+	detector *Detector
+}
+
+func (r *linkRule) Name() string { return "urls" }
+
+func (r *linkRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	links := ExtractLinks(msg.Content)
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	rules := r.detector.links
+	policy := rules.PolicyFor(msg.Geohash)
+
+	var removed []Link
+	kept, untrusted := 0, 0
+	for _, link := range links {
+		if !rules.Allowed(link.Host, policy) {
+			removed = append(removed, link)
+			continue
+		}
+		kept++
+		if !rules.Trusted(link.Host) {
+			untrusted++
+		}
+	}
+
+	if kept > r.detector.maxURLsPerMessage {
+		return &Verdict{Reason: ReasonTooManyURLs, Action: ActionReject, Score: 30, Detail: fmt.Sprintf("too many URLs in message (max %d)", r.detector.maxURLsPerMessage)}, nil
+	}
+
+	score := 0
+	if untrusted > 0 {
+		score = 30
+	}
+
+	if len(removed) > 0 {
+		return &Verdict{
+			Reason:    ReasonLinkRemoved,
+			Action:    ActionSanitize,
+			Score:     score,
+			Detail:    fmt.Sprintf("links are not allowed here (policy: %s)", policy),
+			Sanitized: rewriteLinks(msg.Content, removed),
+		}, nil
+	}
+	if score > 0 {
+		return &Verdict{Reason: ReasonURL, Action: ActionAllow, Score: score}, nil
+	}
+	return nil, nil
+}
+
+// emailRule scores email addresses.
+type emailRule struct{}
+
+func (r *emailRule) Name() string { return "emails" }
+
+func (r *emailRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if !emailPattern.MatchString(msg.Normalized.Folded) {
+		return nil, nil
+	}
+	return &Verdict{Reason: ReasonEmail, Action: ActionAllow, Score: 25}, nil
+}
+
+// phoneRule scores phone numbers.
+type phoneRule struct{}
+
+func (r *phoneRule) Name() string { return "phones" }
+
+func (r *phoneRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if !phonePattern.MatchString(msg.Normalized.Folded) {
+		return nil, nil
+	}
+	return &Verdict{Reason: ReasonPhone, Action: ActionAllow, Score: 25}, nil
+}
+
+// capsRule flags shouting.
+type capsRule struct{}
+
+func (r *capsRule) Name() string { return "caps" }
+
+func (r *capsRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if !hasExcessiveCaps(msg.Normalized.Folded) {
+		return nil, nil
+	}
+	return &Verdict{Reason: ReasonExcessiveCaps, Action: ActionFlag, Score: 15}, nil
+}
+
+// repeatedCharsRule flags runs like "!!!!!!" or "heyyyyyy".
+type repeatedCharsRule struct {
+	maxRepeats int
+}
+
+func (r *repeatedCharsRule) Name() string { return "repeated_chars" }
+
+func (r *repeatedCharsRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	if !hasExcessiveRepeatedChars(msg.Normalized.Folded, r.maxRepeats) {
+		return nil, nil
+	}
+	return &Verdict{Reason: ReasonRepeatedChars, Action: ActionFlag, Score: 20}, nil
+}
+
+// scamRule flags promotional phrases and money or prize mentions.
+type scamRule struct{}
+
+func (r *scamRule) Name() string { return "scam" }
+
+func (r *scamRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	scam := scamPattern.MatchString(msg.Normalized.Canonical)
+	money := moneyPattern.MatchString(msg.Normalized.Folded)
+
+	switch {
+	case scam && money:
+		return &Verdict{Reason: ReasonScamPhrase, Action: ActionFlag, Score: 35}, nil
+	case scam:
+		return &Verdict{Reason: ReasonScamPhrase, Action: ActionFlag, Score: 20}, nil
+	case money:
+		return &Verdict{Reason: ReasonMoneyMention, Action: ActionFlag, Score: 15}, nil
+	}
+	return nil, nil
+}
+
+// classifierRule scores messages by the naive Bayes classifier's spam
+// probability. Messages the classifier considers more likely ham than spam,
+// or that it has too little training data to judge, are not scored.
+type classifierRule struct {
+	classifier *Classifier
+}
+
+func (r *classifierRule) Name() string { return "classifier" }
+
+func (r *classifierRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	probability, ok := r.classifier.SpamProbability(msg.Content)
+	if !ok || probability <= 0.5 {
+		return nil, nil
+	}
+	// Scale 0.5-1.0 onto 0-50, so a confident classifier alone flags a
+	// message but needs another rule to push it over the reject score
+	score := int(math.Round((probability - 0.5) * 100))
+	return &Verdict{
+		Reason: ReasonClassifier,
+		Action: ActionAllow,
+		Score:  score,
+		Detail: fmt.Sprintf("spam probability %.2f", probability),
+	}, nil
+}
+
+// duplicateRule rejects a session repeating itself within the duplicate
+// window. It compares SimHash fingerprints of canonical text, so messages
+// varied by a character or two still count as repeats. It records the
+// message, so it runs after the content rules.
+type duplicateRule struct {
+	detector *Detector
+}
+
+func (r *duplicateRule) Name() string { return "duplicates" }
+
+func (r *duplicateRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	duplicate, err := r.detector.isDuplicate(ctx, msg.SessionID, similarityText(msg))
+	if err != nil {
+		return nil, err
+	}
+	if !duplicate {
+		return nil, nil
+	}
+	return &Verdict{
+		Reason: ReasonDuplicate,
+		Action: ActionReject,
+		Score:  20,
+		Detail: fmt.Sprintf("duplicate message detected (sent within %d seconds)", r.detector.duplicateWindowSeconds),
+	}, nil
+}
+
+// floodRule rejects messages that several sessions in the same area are
+// sending near-identical copies of. The verdict lists the other senders so
+// they can be charged a violation too.
+type floodRule struct {
+	detector *Detector
+}
+
+func (r *floodRule) Name() string { return "flood" }
+
+func (r *floodRule) Evaluate(ctx context.Context, msg *Message) (*Verdict, error) {
+	flood, others, err := r.detector.checkFlood(ctx, msg, similarityText(msg))
+	if err != nil {
+		return nil, err
+	}
+	if !flood {
+		return nil, nil
+	}
+	return &Verdict{
+		Reason:  ReasonFlood,
+		Action:  ActionReject,
+		Score:   40,
+		Detail:  "similar messages are being sent by several users in this area",
+		Related: others,
+	}, nil
+}
+
+// similarityText is the text compared by the duplicate and flood rules.
+// Messages without words (e.g. only emoji) fall back to the folded text.
+func similarityText(msg *Message) string {
+	if msg.Normalized.Canonical != "" {
+		return msg.Normalized.Canonical
+	}
+	return msg.Normalized.Folded
+}
@@ -0,0 +1,92 @@
+package spam
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// shingleSize is the length in runes of the overlapping character windows a
+// SimHash is built from. Four keeps one-character edits close while unrelated
+// sentences that share a few words stay far apart.
+const shingleSize = 4
+
+// simhash computes a 64-bit SimHash of text. Texts that differ by a few
+// characters produce hashes a small Hamming distance apart.
+func simhash(text string) uint64 {
+	runes := []rune(text)
+	size := shingleSize
+	if len(runes) < size {
+		size = len(runes)
+	}
+
+	var weights [64]int
+	for i := 0; i+size <= len(runes); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i : i+size])))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// hammingDistance counts the bits that differ between two hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// fingerprint identifies a message's content for near-duplicate checks.
+type fingerprint struct {
+	hash   uint64
+	length int // in runes
+}
+
+func newFingerprint(text string) fingerprint {
+	return fingerprint{hash: simhash(text), length: len([]rune(text))}
+}
+
+// near reports whether two fingerprints are within maxDistance bits. Texts
+// shorter than minLength only match exactly, since a handful of shingles is
+// too little to judge similarity on.
+func (f fingerprint) near(other fingerprint, minLength, maxDistance int) bool {
+	if f.length < minLength || other.length < minLength {
+		return f.hash == other.hash
+	}
+	return hammingDistance(f.hash, other.hash) <= maxDistance
+}
+
+// String encodes the fingerprint as "<hash>:<length>" for storage.
+func (f fingerprint) String() string {
+	return strconv.FormatUint(f.hash, 16) + ":" + strconv.Itoa(f.length)
+}
+
+func parseFingerprint(s string) (fingerprint, error) {
+	hash, length, ok := strings.Cut(s, ":")
+	if !ok {
+		return fingerprint{}, fmt.Errorf("invalid fingerprint %q", s)
+	}
+	h, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	return fingerprint{hash: h, length: n}, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyFamilies are the first parts of every key and pub/sub channel the
+// server uses. Keep it in step with the packages' key builders; the key
+// migration renames only these families.
+var KeyFamilies = []string{
+	"analytics",
+	"ban",
+	"cell",
+	"chat",
+	"location",
+	"locations",
+	"messages",
+	"ratelimit",
+	"reputation",
+	"session",
+	"spam",
+	"ws",
+}
+
+// keyspaceNamePattern keeps prefixes and tenants free of the separator, hash
+// tag braces and glob characters, so keys still hash by their own tags and
+// SCAN patterns need no escaping.
+var keyspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Keyspace builds Redis keys and channel names under an optional
+// deployment prefix and tenant, as "<prefix>:<tenant>:<part>:<part>...", so
+// deployments can share a Redis. The zero Keyspace adds nothing.
+type Keyspace struct {
+	root string
+}
+
+// NewKeyspace returns the keyspace for prefix and tenant, either of which
+// may be empty.
+func NewKeyspace(prefix, tenant string) (Keyspace, error) {
+	var parts []string
+	for _, name := range []struct{ kind, value string }{{"prefix", prefix}, {"tenant", tenant}} {
+		if name.value == "" {
+			continue
+		}
+		if !keyspaceNamePattern.MatchString(name.value) {
+			return Keyspace{}, fmt.Errorf("invalid Redis key %s %q: use letters, digits, '_', '.' or '-'", name.kind, name.value)
+		}
+		parts = append(parts, name.value)
+	}
+
+	if len(parts) == 0 {
+		return Keyspace{}, nil
+	}
+	return Keyspace{root: strings.Join(parts, ":") + ":"}, nil
+}
+
+// Key joins parts with ":" under the keyspace.
+func (k Keyspace) Key(parts ...string) string {
+	return k.root + strings.Join(parts, ":")
+}
+
+// HashTag joins parts with ":" in braces. Redis Cluster hashes a key that
+// contains a tag by the tag alone, so keys sharing a tag share a slot and
+// can be used together in scripts and transactions.
+func HashTag(parts ...string) string {
+	return "{" + strings.Join(parts, ":") + "}"
+}
+
+// CellTag is the hash tag of a geohash cell's keys: its parent cell, so the
+// 32 cells in a parent share a slot. Neighbouring cells on either side of a
+// parent's edge have different parents and do not, so in a cluster one
+// script cannot use the keys of both.
+func CellTag(geohash string) string {
+	if geohash == "" {
+		return HashTag("cell")
+	}
+	return HashTag("cell", geohash[:len(geohash)-1])
+}
+
+// Root is what every key in the keyspace starts with: empty, or the prefix
+// and tenant followed by ":".
+func (k Keyspace) Root() string {
+	return k.root
+}
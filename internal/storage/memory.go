@@ -0,0 +1,948 @@
+package storage
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// memorySweepInterval is how often expired keys are removed from memory.
+// Expired keys are never visible; sweeping only frees them.
+const memorySweepInterval = 10 * time.Second
+
+// Errors as Redis reports them.
+var (
+	errWrongType  = replyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = replyError("ERR value is not an integer or out of range")
+	errNotFloat   = replyError("ERR min or max is not a float")
+)
+
+// replyError is an error Redis sends as a reply. Like go-redis's own it
+// satisfies redis.Error, so callers can tell a refused command from an
+// unreachable server (see Unavailable).
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+func (replyError) RedisError() {}
+
+type memoryKind int
+
+const (
+	kindString memoryKind = iota
+	kindHash
+	kindSet
+	kindZSet
+	kindHLL
+)
+
+type memoryEntry struct {
+	kind      memoryKind
+	str       string
+	hash      map[string]string
+	set       map[string]struct{} // sets and HyperLogLogs
+	zset      *sortedSet
+	expiresAt time.Time // zero when the key does not expire
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// empty reports whether a collection has lost its last member; Redis
+// deletes such keys.
+func (e *memoryEntry) empty() bool {
+	switch e.kind {
+	case kindHash:
+		return len(e.hash) == 0
+	case kindSet:
+		return len(e.set) == 0
+	case kindZSet:
+		return len(e.zset.scores) == 0
+	}
+	return false
+}
+
+// MemoryClient is a RedisClient kept in process memory, for tests and for
+// running a single node without Redis. It follows Redis's semantics for the
+// commands it supports: keys expire, collections that lose their last member
+// are deleted, and commands against a key of the wrong type fail with
+// WRONGTYPE. Scripts run through implementations registered with
+// RegisterScript.
+type MemoryClient struct {
+	mu          sync.Mutex
+	entries     map[string]*memoryEntry
+	subscribers map[string]map[*memorySubscription]struct{}
+	closed      bool
+	done        chan struct{}
+	keys        Keyspace
+}
+
+// NewMemoryClient creates an empty in-memory client. Close stops its
+// background sweep.
+func NewMemoryClient() *MemoryClient {
+	m := &MemoryClient{
+		entries:     make(map[string]*memoryEntry),
+		subscribers: make(map[string]map[*memorySubscription]struct{}),
+		done:        make(chan struct{}),
+	}
+	go m.sweep()
+	return m
+}
+
+func (m *MemoryClient) sweep() {
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			now := time.Now()
+			for key, entry := range m.entries {
+				if entry.expired(now) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// lock takes the client lock, failing once the client is closed.
+func (m *MemoryClient) lock() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return redis.ErrClosed
+	}
+	return nil
+}
+
+// lookup returns the live entry for key, or nil.
+func (m *MemoryClient) lookup(key string) *memoryEntry {
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.expired(time.Now()) {
+		delete(m.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// lookupKind returns the live entry for key if it holds kind, nil if there
+// is none, or WRONGTYPE.
+func (m *MemoryClient) lookupKind(key string, kind memoryKind) (*memoryEntry, error) {
+	entry := m.lookup(key)
+	if entry != nil && entry.kind != kind {
+		return nil, errWrongType
+	}
+	return entry, nil
+}
+
+// upsert returns the entry for key, creating an empty one of kind if there
+// is none.
+func (m *MemoryClient) upsert(key string, kind memoryKind) (*memoryEntry, error) {
+	entry, err := m.lookupKind(key, kind)
+	if err != nil || entry != nil {
+		return entry, err
+	}
+
+	entry = &memoryEntry{kind: kind}
+	switch kind {
+	case kindHash:
+		entry.hash = make(map[string]string)
+	case kindSet, kindHLL:
+		entry.set = make(map[string]struct{})
+	case kindZSet:
+		entry.zset = newSortedSet()
+	}
+	m.entries[key] = entry
+	return entry, nil
+}
+
+// dropIfEmpty deletes key if its collection is empty.
+func (m *MemoryClient) dropIfEmpty(key string, entry *memoryEntry) {
+	if entry.empty() {
+		delete(m.entries, key)
+	}
+}
+
+// Strings and keys
+
+func (m *MemoryClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	str, err := formatArg(value)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.set(key, str, expiration)
+	return nil
+}
+
+// set stores a string. A positive expiration sets the TTL, redis.KeepTTL
+// keeps the current one and zero clears it.
+func (m *MemoryClient) set(key, value string, expiration time.Duration) {
+	entry := &memoryEntry{kind: kindString, str: value}
+	if expiration == redis.KeepTTL {
+		if previous := m.lookup(key); previous != nil {
+			entry.expiresAt = previous.expiresAt
+		}
+	} else if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+	m.entries[key] = entry
+}
+
+func (m *MemoryClient) Get(ctx context.Context, key string) (string, error) {
+	if err := m.lock(); err != nil {
+		return "", err
+	}
+	defer m.mu.Unlock()
+
+	return m.get(key)
+}
+
+// MGet returns each key's string value, or nil where the key is missing or
+// not a string.
+func (m *MemoryClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, err := m.get(key); err == nil {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryClient) get(key string) (string, error) {
+	entry, err := m.lookupKind(key, kindString)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", redis.Nil
+	}
+	return entry.str, nil
+}
+
+func (m *MemoryClient) Del(ctx context.Context, keys ...string) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.del(keys...)
+	return nil
+}
+
+func (m *MemoryClient) del(keys ...string) int64 {
+	var deleted int64
+	for _, key := range keys {
+		if m.lookup(key) != nil {
+			delete(m.entries, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Rename moves key to newKey with its TTL, replacing newKey.
+func (m *MemoryClient) Rename(ctx context.Context, key, newKey string) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	entry := m.lookup(key)
+	if entry == nil {
+		return replyError("ERR no such key")
+	}
+	delete(m.entries, key)
+	m.entries[newKey] = entry
+	return nil
+}
+
+// Exists counts the keys that exist; a key given twice counts twice.
+func (m *MemoryClient) Exists(ctx context.Context, keys ...string) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, key := range keys {
+		if m.lookup(key) != nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryClient) Incr(ctx context.Context, key string) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	return m.incrBy(key, 1)
+}
+
+// incrBy adds to an integer string, keeping its TTL.
+func (m *MemoryClient) incrBy(key string, by int64) (int64, error) {
+	entry, err := m.lookupKind(key, kindString)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		entry = &memoryEntry{kind: kindString, str: "0"}
+		m.entries[key] = entry
+	}
+
+	value, err := strconv.ParseInt(entry.str, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	value += by
+	entry.str = strconv.FormatInt(value, 10)
+	return value, nil
+}
+
+// Expire sets a key's TTL. As with the Redis client, TTLs under a second
+// are rounded up to one, and a TTL that is not positive deletes the key.
+func (m *MemoryClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.expire(key, expireSeconds(expiration))
+	return nil
+}
+
+// expireSeconds rounds an EXPIRE TTL to whole seconds as the Redis client
+// does.
+func expireSeconds(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < time.Second {
+		return time.Second
+	}
+	return ttl.Truncate(time.Second)
+}
+
+// expire sets key's TTL, deleting it if ttl is not positive. It reports
+// whether the key exists.
+func (m *MemoryClient) expire(key string, ttl time.Duration) bool {
+	entry := m.lookup(key)
+	if entry == nil {
+		return false
+	}
+	if ttl <= 0 {
+		delete(m.entries, key)
+		return true
+	}
+	entry.expiresAt = time.Now().Add(ttl)
+	return true
+}
+
+// pttl returns key's TTL in milliseconds, -1 if it does not expire or -2
+// if it does not exist, as PTTL does.
+func (m *MemoryClient) pttl(key string) int64 {
+	entry := m.lookup(key)
+	if entry == nil {
+		return -2
+	}
+	if entry.expiresAt.IsZero() {
+		return -1
+	}
+	return time.Until(entry.expiresAt).Milliseconds()
+}
+
+// Scan returns every key matching the glob pattern in one page, with a zero
+// cursor, so iterators stop after it.
+func (m *MemoryClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	if err := m.lock(); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	defer m.mu.Unlock()
+
+	keys := []string{}
+	for key := range m.entries {
+		if m.lookup(key) != nil && (match == "" || matchGlob(match, key)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return redis.NewScanCmdResult(keys, 0, nil)
+}
+
+// Hashes
+
+// HSet takes field-value pairs, a map or a flat slice of pairs, like the
+// Redis client.
+func (m *MemoryClient) HSet(ctx context.Context, key string, values ...interface{}) error {
+	pairs, err := flattenPairs(values)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.hset(key, pairs...)
+}
+
+func (m *MemoryClient) hset(key string, pairs ...string) error {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return replyError("ERR wrong number of arguments for 'hset' command")
+	}
+	entry, err := m.upsert(key, kindHash)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		entry.hash[pairs[i]] = pairs[i+1]
+	}
+	return nil
+}
+
+func (m *MemoryClient) HGet(ctx context.Context, key, field string) (string, error) {
+	if err := m.lock(); err != nil {
+		return "", err
+	}
+	defer m.mu.Unlock()
+
+	return m.hget(key, field)
+}
+
+func (m *MemoryClient) hget(key, field string) (string, error) {
+	entry, err := m.lookupKind(key, kindHash)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", redis.Nil
+	}
+	value, ok := entry.hash[field]
+	if !ok {
+		return "", redis.Nil
+	}
+	return value, nil
+}
+
+func (m *MemoryClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.hgetall(key)
+}
+
+func (m *MemoryClient) hgetall(key string) (map[string]string, error) {
+	entry, err := m.lookupKind(key, kindHash)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	if entry != nil {
+		for field, value := range entry.hash {
+			result[field] = value
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryClient) HIncrBy(ctx context.Context, key, field string, incr int64) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	return m.hincrby(key, field, incr)
+}
+
+func (m *MemoryClient) hincrby(key, field string, incr int64) (int64, error) {
+	entry, err := m.upsert(key, kindHash)
+	if err != nil {
+		return 0, err
+	}
+	value := int64(0)
+	if current, ok := entry.hash[field]; ok {
+		if value, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, replyError("ERR hash value is not an integer")
+		}
+	}
+	value += incr
+	entry.hash[field] = strconv.FormatInt(value, 10)
+	return value, nil
+}
+
+// Sets
+
+func (m *MemoryClient) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	values, err := formatArgs(members)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.sadd(key, values...)
+}
+
+func (m *MemoryClient) sadd(key string, members ...string) error {
+	entry, err := m.upsert(key, kindSet)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		entry.set[member] = struct{}{}
+	}
+	m.dropIfEmpty(key, entry)
+	return nil
+}
+
+func (m *MemoryClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.smembers(key)
+}
+
+func (m *MemoryClient) smembers(key string) ([]string, error) {
+	entry, err := m.lookupKind(key, kindSet)
+	if err != nil {
+		return nil, err
+	}
+	members := []string{}
+	if entry != nil {
+		for member := range entry.set {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *MemoryClient) SRem(ctx context.Context, key string, members ...interface{}) error {
+	values, err := formatArgs(members)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.srem(key, values...)
+}
+
+func (m *MemoryClient) srem(key string, members ...string) error {
+	entry, err := m.lookupKind(key, kindSet)
+	if err != nil || entry == nil {
+		return err
+	}
+	for _, member := range members {
+		delete(entry.set, member)
+	}
+	m.dropIfEmpty(key, entry)
+	return nil
+}
+
+func (m *MemoryClient) SCard(ctx context.Context, key string) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	entry, err := m.lookupKind(key, kindSet)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+	return int64(len(entry.set)), nil
+}
+
+// HyperLogLogs are kept as exact sets, so counts are exact rather than
+// estimates.
+
+func (m *MemoryClient) PFAdd(ctx context.Context, key string, elements ...interface{}) error {
+	values, err := formatArgs(elements)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	entry, err := m.upsert(key, kindHLL)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		entry.set[value] = struct{}{}
+	}
+	return nil
+}
+
+// PFCount counts the distinct elements across keys.
+func (m *MemoryClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	union := make(map[string]struct{})
+	for _, key := range keys {
+		entry, err := m.lookupKind(key, kindHLL)
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			continue
+		}
+		for value := range entry.set {
+			union[value] = struct{}{}
+		}
+	}
+	return int64(len(union)), nil
+}
+
+// Pub/sub
+
+// memorySubscriptionSize is how many messages a subscriber may fall behind
+// before further messages to it are dropped, as with the Redis client.
+const memorySubscriptionSize = 100
+
+type memorySubscription struct {
+	client   *MemoryClient
+	channels []string
+	messages chan *redis.Message
+	closed   bool
+}
+
+func (s *memorySubscription) Channel() <-chan *redis.Message {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+
+	s.client.unsubscribe(s)
+	return nil
+}
+
+// unsubscribe removes s and closes its channel. The client lock must be
+// held.
+func (m *MemoryClient) unsubscribe(s *memorySubscription) {
+	if s.closed {
+		return
+	}
+	for _, channel := range s.channels {
+		delete(m.subscribers[channel], s)
+		if len(m.subscribers[channel]) == 0 {
+			delete(m.subscribers, channel)
+		}
+	}
+	s.closed = true
+	close(s.messages)
+}
+
+func (m *MemoryClient) Publish(ctx context.Context, channel string, message interface{}) error {
+	payload, err := formatArg(message)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for subscription := range m.subscribers[channel] {
+		select {
+		case subscription.messages <- &redis.Message{Channel: channel, Payload: payload}:
+		default:
+			// Subscriber is too far behind
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to channels. After Close, the subscription's channel
+// is closed straight away.
+func (m *MemoryClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	subscription := &memorySubscription{
+		client:   m,
+		channels: channels,
+		messages: make(chan *redis.Message, memorySubscriptionSize),
+	}
+
+	if err := m.lock(); err != nil {
+		subscription.closed = true
+		close(subscription.messages)
+		return subscription
+	}
+	defer m.mu.Unlock()
+
+	for _, channel := range channels {
+		if m.subscribers[channel] == nil {
+			m.subscribers[channel] = make(map[*memorySubscription]struct{})
+		}
+		m.subscribers[channel][subscription] = struct{}{}
+	}
+	return subscription
+}
+
+// Connection
+
+func (m *MemoryClient) Keys() Keyspace {
+	return m.keys
+}
+
+// Clustered is false: every key is in one store.
+func (m *MemoryClient) Clustered() bool {
+	return false
+}
+
+func (m *MemoryClient) Ping(ctx context.Context) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Close stops the client; later commands fail with redis.ErrClosed and
+// subscriptions are closed.
+func (m *MemoryClient) Close() error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.closed = true
+	close(m.done)
+	for _, subscriptions := range m.subscribers {
+		for subscription := range subscriptions {
+			m.unsubscribe(subscription)
+		}
+	}
+	return nil
+}
+
+// formatArg turns a command argument into the string Redis would store,
+// accepting the same types as the Redis client.
+func formatArg(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		return string(data), err
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", value)
+	}
+}
+
+func formatArgs(values []interface{}) ([]string, error) {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		switch v := value.(type) {
+		case []string:
+			result = append(result, v...)
+			continue
+		case []interface{}:
+			nested, err := formatArgs(v)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, nested...)
+			continue
+		}
+
+		str, err := formatArg(value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, str)
+	}
+	return result, nil
+}
+
+// flattenPairs turns HSet arguments into field-value pairs.
+func flattenPairs(values []interface{}) ([]string, error) {
+	if len(values) == 1 {
+		switch v := values[0].(type) {
+		case map[string]interface{}:
+			pairs := make([]string, 0, len(v)*2)
+			for field, value := range v {
+				str, err := formatArg(value)
+				if err != nil {
+					return nil, err
+				}
+				pairs = append(pairs, field, str)
+			}
+			return pairs, nil
+		case map[string]string:
+			pairs := make([]string, 0, len(v)*2)
+			for field, value := range v {
+				pairs = append(pairs, field, value)
+			}
+			return pairs, nil
+		}
+	}
+	return formatArgs(values)
+}
+
+// matchGlob reports whether str matches a Redis glob pattern: * and ? match
+// any run or single character, [abc], [^abc] and [a-z] match classes, and a
+// backslash escapes the next character.
+func matchGlob(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if matchGlob(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				// Unterminated class matches literally
+				if str[0] != '[' {
+					return false
+				}
+				str = str[1:]
+				pattern = pattern[1:]
+				continue
+			}
+			if !matchClass(pattern[1:end], str[0]) {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || str[0] != pattern[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(str) == 0
+}
+
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			low, high := class[i], class[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (c >= low && c <= high)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// memoryPipeline queues commands and runs them together, with the client
+// locked, on Exec.
+type memoryPipeline struct {
+	client *MemoryClient
+	queued []func()
+}
+
+func (m *MemoryClient) Pipeline() Pipeline {
+	return &memoryPipeline{client: m}
+}
+
+func (p *memoryPipeline) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx, "get", key)
+	p.queued = append(p.queued, func() {
+		value, err := p.client.get(key)
+		cmd.SetVal(value)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "set", key, value)
+	str, err := formatArg(value)
+	p.queued = append(p.queued, func() {
+		if err != nil {
+			cmd.SetErr(err)
+			return
+		}
+		p.client.set(key, str, expiration)
+		cmd.SetVal("OK")
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "del")
+	p.queued = append(p.queued, func() {
+		cmd.SetVal(p.client.del(keys...))
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	cmd := redis.NewBoolCmd(ctx, "expire", key)
+	p.queued = append(p.queued, func() {
+		cmd.SetVal(p.client.expire(key, expireSeconds(expiration)))
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "sadd", key)
+	values, err := formatArgs(members)
+	p.queued = append(p.queued, func() {
+		if err == nil {
+			err = p.client.sadd(key, values...)
+		}
+		cmd.SetVal(int64(len(values)))
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "srem", key)
+	values, err := formatArgs(members)
+	p.queued = append(p.queued, func() {
+		if err == nil {
+			err = p.client.srem(key, values...)
+		}
+		cmd.SetVal(int64(len(values)))
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	cmd := redis.NewStringSliceCmd(ctx, "smembers", key)
+	p.queued = append(p.queued, func() {
+		members, err := p.client.smembers(key)
+		cmd.SetVal(members)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd {
+	cmd := redis.NewMapStringStringCmd(ctx, "hgetall", key)
+	p.queued = append(p.queued, func() {
+		fields, err := p.client.hgetall(key)
+		cmd.SetVal(fields)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "hincrby", key, field, incr)
+	p.queued = append(p.queued, func() {
+		value, err := p.client.hincrby(key, field, incr)
+		cmd.SetVal(value)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "zadd", key)
+	p.queued = append(p.queued, func() {
+		cmd.SetVal(int64(len(members)))
+		cmd.SetErr(p.client.zadd(key, members...))
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "zrem", key)
+	values, err := formatArgs(members)
+	p.queued = append(p.queued, func() {
+		removed := int64(0)
+		if err == nil {
+			removed, err = p.client.zrem(key, values...)
+		}
+		cmd.SetVal(removed)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
+	cmd := redis.NewStringSliceCmd(ctx, "zrangebyscore", key, opt.Min, opt.Max)
+	low, high, err := parseScoreRange(opt.Min, opt.Max)
+	p.queued = append(p.queued, func() {
+		var members []string
+		if err == nil {
+			members, err = p.client.zrangeByScoreLimit(key, low, high, opt, false)
+		}
+		cmd.SetVal(members)
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "geoadd", key)
+	members, err := geoMembers(geoLocation)
+	p.queued = append(p.queued, func() {
+		if err == nil {
+			err = p.client.zadd(key, members...)
+		}
+		cmd.SetVal(int64(len(members)))
+		cmd.SetErr(err)
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Exec(ctx context.Context) error {
+	if err := p.client.lock(); err != nil {
+		return err
+	}
+	defer p.client.mu.Unlock()
+
+	for _, run := range p.queued {
+		run()
+	}
+	p.queued = nil
+	return nil
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ScriptFunc runs a Lua script's logic against a MemoryClient. Arguments
+// arrive as strings, as the script sees them, and results should follow
+// Redis's conversion of Lua values: integers as int64, tables as
+// []interface{}.
+type ScriptFunc func(tx *MemoryTx, keys, args []string) (interface{}, error)
+
+var (
+	memoryScriptsMu sync.RWMutex
+	memoryScripts   = make(map[string]ScriptFunc)
+)
+
+// RegisterScript provides the in-memory implementation of script. Packages
+// that run scripts register them from init.
+func RegisterScript(script *redis.Script, fn ScriptFunc) {
+	memoryScriptsMu.Lock()
+	defer memoryScriptsMu.Unlock()
+
+	memoryScripts[script.Hash()] = fn
+}
+
+// RunScript runs the script's registered implementation with the client
+// locked, so it is atomic like the script.
+func (m *MemoryClient) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	memoryScriptsMu.RLock()
+	fn, ok := memoryScripts[script.Hash()]
+	memoryScriptsMu.RUnlock()
+	if !ok {
+		return nil, replyError(fmt.Sprintf("NOSCRIPT no in-memory implementation of script %s", script.Hash()))
+	}
+
+	values, err := formatArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	result, err := fn(&MemoryTx{client: m}, keys, values)
+	var reply redis.Error
+	if err != nil && !errors.As(err, &reply) {
+		// Redis replies with the errors a script raises
+		err = replyError("ERR " + err.Error())
+	}
+	return result, err
+}
+
+// MemoryTx gives a ScriptFunc the commands scripts call, on the locked
+// client.
+type MemoryTx struct {
+	client *MemoryClient
+}
+
+// Get returns a string value, or redis.Nil.
+func (tx *MemoryTx) Get(key string) (string, error) {
+	return tx.client.get(key)
+}
+
+// Set stores a string, expiring after ttl if it is positive.
+func (tx *MemoryTx) Set(key, value string, ttl time.Duration) {
+	tx.client.set(key, value, ttl)
+}
+
+func (tx *MemoryTx) Del(keys ...string) int64 {
+	return tx.client.del(keys...)
+}
+
+func (tx *MemoryTx) Incr(key string) (int64, error) {
+	return tx.client.incrBy(key, 1)
+}
+
+// PExpire sets key's TTL, deleting it if ttl is not positive.
+func (tx *MemoryTx) PExpire(key string, ttl time.Duration) bool {
+	return tx.client.expire(key, ttl)
+}
+
+// PTTL returns the TTL in milliseconds, -1 if key does not expire or -2 if
+// it does not exist.
+func (tx *MemoryTx) PTTL(key string) int64 {
+	return tx.client.pttl(key)
+}
+
+// HGet returns a hash field, or redis.Nil.
+func (tx *MemoryTx) HGet(key, field string) (string, error) {
+	return tx.client.hget(key, field)
+}
+
+// HSet sets field-value pairs.
+func (tx *MemoryTx) HSet(key string, pairs ...string) error {
+	return tx.client.hset(key, pairs...)
+}
+
+func (tx *MemoryTx) ZAdd(key string, score float64, member string) error {
+	return tx.client.zadd(key, redis.Z{Score: score, Member: member})
+}
+
+func (tx *MemoryTx) ZCard(key string) (int64, error) {
+	return tx.client.zcard(key)
+}
+
+// ZRemRangeByScore removes members scored from min to max, inclusive.
+func (tx *MemoryTx) ZRemRangeByScore(key string, min, max float64) (int64, error) {
+	return tx.client.zremRangeByScore(key, scoreBound{value: min}, scoreBound{value: max})
+}
+
+// ZRangeWithScores returns members by rank, as ZRANGE ... WITHSCORES does.
+func (tx *MemoryTx) ZRangeWithScores(key string, start, stop int64) ([]redis.Z, error) {
+	return tx.client.zrange(key, start, stop, false)
+}
+
+// ZScore returns a member's score, or redis.Nil.
+func (tx *MemoryTx) ZScore(key, member string) (float64, error) {
+	entry, err := tx.client.lookupKind(key, kindZSet)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, redis.Nil
+	}
+	score, ok := entry.zset.scores[member]
+	if !ok {
+		return 0, redis.Nil
+	}
+	return score, nil
+}
+
+func (tx *MemoryTx) ZRem(key string, members ...string) (int64, error) {
+	return tx.client.zrem(key, members...)
+}
+
+// Exists reports whether key exists.
+func (tx *MemoryTx) Exists(key string) bool {
+	return tx.client.lookup(key) != nil
+}
@@ -0,0 +1,530 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestClient(t *testing.T) *MemoryClient {
+	t.Helper()
+
+	m := NewMemoryClient()
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// pttl reads a key's TTL in milliseconds, as PTTL reports it.
+func pttl(m *MemoryClient, key string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pttl(key)
+}
+
+func TestMemoryTTL(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		setup func(m *MemoryClient)
+		wait  time.Duration
+		// wantTTL is the PTTL expected after wait: -2 when the key is gone,
+		// -1 when it does not expire, otherwise an upper bound
+		wantTTL int64
+	}{
+		{
+			name: "set without expiry",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 0)
+			},
+			wantTTL: -1,
+		},
+		{
+			name: "set expires",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 20*time.Millisecond)
+			},
+			wait:    40 * time.Millisecond,
+			wantTTL: -2,
+		},
+		{
+			name: "set before expiry",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", time.Minute)
+			},
+			wantTTL: time.Minute.Milliseconds(),
+		},
+		{
+			name: "set replaces expiry",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 20*time.Millisecond)
+				m.Set(ctx, "key", "value", 0)
+			},
+			wait:    40 * time.Millisecond,
+			wantTTL: -1,
+		},
+		{
+			name: "set keeps expiry",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 20*time.Millisecond)
+				m.Set(ctx, "key", "other", redis.KeepTTL)
+			},
+			wait:    40 * time.Millisecond,
+			wantTTL: -2,
+		},
+		{
+			name: "expire rounds up to a second",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 0)
+				m.Expire(ctx, "key", 20*time.Millisecond)
+			},
+			wait:    40 * time.Millisecond,
+			wantTTL: time.Second.Milliseconds(),
+		},
+		{
+			name: "expire of zero deletes",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", "value", 0)
+				m.Expire(ctx, "key", 0)
+			},
+			wantTTL: -2,
+		},
+		{
+			name: "incr keeps expiry",
+			setup: func(m *MemoryClient) {
+				m.Set(ctx, "key", 1, 20*time.Millisecond)
+				m.Incr(ctx, "key")
+			},
+			wait:    40 * time.Millisecond,
+			wantTTL: -2,
+		},
+		{
+			name: "collections expire",
+			setup: func(m *MemoryClient) {
+				m.SAdd(ctx, "key", "member")
+				m.Expire(ctx, "key", time.Second)
+			},
+			wantTTL: time.Second.Milliseconds(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestClient(t)
+			tt.setup(m)
+			time.Sleep(tt.wait)
+
+			got := pttl(m, "key")
+			switch {
+			case tt.wantTTL < 0 && got != tt.wantTTL:
+				t.Errorf("PTTL = %d, want %d", got, tt.wantTTL)
+			case tt.wantTTL >= 0 && (got <= 0 || got > tt.wantTTL):
+				t.Errorf("PTTL = %d, want 1 to %d", got, tt.wantTTL)
+			}
+
+			exists, err := m.Exists(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.wantTTL != -2; (exists == 1) != want {
+				t.Errorf("Exists = %d, want exists %v", exists, want)
+			}
+		})
+	}
+}
+
+func TestMemoryScan(t *testing.T) {
+	ctx := context.Background()
+	m := newTestClient(t)
+
+	for _, key := range []string{
+		"cell:{cell:9q8y}:9q8yy",
+		"cell:{cell:9q8y}:9q8yz",
+		"h?llo",
+		"hallo",
+		"location:{a}",
+		"session:a",
+		"session:b",
+		"session:ab",
+	} {
+		m.Set(ctx, key, "value", 0)
+	}
+	m.Set(ctx, "session:expired", "value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"session:*", []string{"session:a", "session:ab", "session:b"}},
+		{"session:?", []string{"session:a", "session:b"}},
+		{"*:a", []string{"session:a"}},
+		{"cell:*", []string{"cell:{cell:9q8y}:9q8yy", "cell:{cell:9q8y}:9q8yz"}},
+		{"cell:{cell:9q8y}:*z", []string{"cell:{cell:9q8y}:9q8yz"}},
+		{"[lh]*", []string{"h?llo", "hallo", "location:{a}"}},
+		{"[^chs]*", []string{"location:{a}"}},
+		{"session:[a-b]", []string{"session:a", "session:b"}},
+		{`h\?llo`, []string{"h?llo"}},
+		{"h?llo", []string{"h?llo", "hallo"}},
+		{"nothing:*", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			keys, cursor, err := m.Scan(ctx, 0, tt.pattern, 10).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cursor != 0 {
+				t.Errorf("cursor = %d, want 0", cursor)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("Scan(%q) = %q, want %q", tt.pattern, keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemorySortedSet(t *testing.T) {
+	ctx := context.Background()
+
+	seed := func(m *MemoryClient) {
+		m.ZAdd(ctx, "zset",
+			&redis.Z{Score: 1, Member: "a"},
+			&redis.Z{Score: 2, Member: "b"},
+			&redis.Z{Score: 2, Member: "c"},
+			&redis.Z{Score: 3, Member: "d"},
+			&redis.Z{Score: 5, Member: "e"},
+		)
+	}
+
+	tests := []struct {
+		name string
+		run  func(m *MemoryClient) ([]string, error)
+		want []string
+	}{
+		{
+			name: "range by score",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRangeByScore(ctx, "zset", &redis.ZRangeBy{Min: "2", Max: "3"})
+			},
+			want: []string{"b", "c", "d"},
+		},
+		{
+			name: "range by exclusive score",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRangeByScore(ctx, "zset", &redis.ZRangeBy{Min: "(1", Max: "(3"})
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "range by infinite score",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRangeByScore(ctx, "zset", &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+			},
+			want: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name: "range by score with limit",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRangeByScore(ctx, "zset", &redis.ZRangeBy{Min: "-inf", Max: "+inf", Offset: 1, Count: 2})
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "reverse range by score with count",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRevRangeByScore(ctx, "zset", &redis.ZRangeBy{Min: "2", Max: "+inf", Count: 3})
+			},
+			want: []string{"e", "d", "c"},
+		},
+		{
+			name: "reverse range by rank",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRevRange(ctx, "zset", 0, 1)
+			},
+			want: []string{"e", "d"},
+		},
+		{
+			name: "reverse range by negative rank",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRevRange(ctx, "zset", -2, -1)
+			},
+			want: []string{"b", "a"},
+		},
+		{
+			name: "re-adding updates the score",
+			run: func(m *MemoryClient) ([]string, error) {
+				m.ZAdd(ctx, "zset", &redis.Z{Score: 10, Member: "a"})
+				return m.ZRevRange(ctx, "zset", 0, 0)
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "remove members",
+			run: func(m *MemoryClient) ([]string, error) {
+				if err := m.ZRem(ctx, "zset", "b", "d", "missing"); err != nil {
+					return nil, err
+				}
+				return m.ZRevRange(ctx, "zset", 0, -1)
+			},
+			want: []string{"e", "c", "a"},
+		},
+		{
+			name: "remove by score",
+			run: func(m *MemoryClient) ([]string, error) {
+				if err := m.ZRemRangeByScore(ctx, "zset", "-inf", "(3"); err != nil {
+					return nil, err
+				}
+				return m.ZRevRange(ctx, "zset", 0, -1)
+			},
+			want: []string{"e", "d"},
+		},
+		{
+			name: "remove by rank keeps the newest",
+			run: func(m *MemoryClient) ([]string, error) {
+				if err := m.ZRemRangeByRank(ctx, "zset", 0, -3); err != nil {
+					return nil, err
+				}
+				return m.ZRevRange(ctx, "zset", 0, -1)
+			},
+			want: []string{"e", "d"},
+		},
+		{
+			name: "missing key",
+			run: func(m *MemoryClient) ([]string, error) {
+				return m.ZRangeByScore(ctx, "missing", &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestClient(t)
+			seed(m)
+
+			got, err := tt.run(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemorySortedSetEmptied(t *testing.T) {
+	ctx := context.Background()
+	m := newTestClient(t)
+
+	m.ZAdd(ctx, "zset", &redis.Z{Score: 1, Member: "a"}, &redis.Z{Score: 2, Member: "b"})
+	if err := m.ZRemRangeByScore(ctx, "zset", "-inf", "+inf"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis deletes collections that lose their last member
+	if exists, _ := m.Exists(ctx, "zset"); exists != 0 {
+		t.Errorf("emptied sorted set still exists")
+	}
+	if count, _ := m.ZCard(ctx, "zset"); count != 0 {
+		t.Errorf("ZCard = %d, want 0", count)
+	}
+}
+
+func TestMemoryWrongType(t *testing.T) {
+	ctx := context.Background()
+	m := newTestClient(t)
+	m.Set(ctx, "key", "value", 0)
+
+	if err := m.ZAdd(ctx, "key", &redis.Z{Score: 1, Member: "a"}); err == nil {
+		t.Error("ZAdd on a string succeeded")
+	}
+	if _, err := m.SMembers(ctx, "key"); err == nil {
+		t.Error("SMembers on a string succeeded")
+	}
+	if _, err := m.HGetAll(ctx, "key"); err == nil {
+		t.Error("HGetAll on a string succeeded")
+	}
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	ctx := context.Background()
+	m := newTestClient(t)
+
+	sub := m.Subscribe(ctx, "chat:a", "chat:b")
+	other := m.Subscribe(ctx, "chat:b")
+
+	m.Publish(ctx, "chat:a", "first")
+	m.Publish(ctx, "chat:c", "unheard")
+	m.Publish(ctx, "chat:b", "second")
+
+	receive := func(s Subscription) *redis.Message {
+		t.Helper()
+		select {
+		case msg := <-s.Channel():
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message received")
+			return nil
+		}
+	}
+
+	tests := []struct {
+		sub         Subscription
+		wantChannel string
+		wantPayload string
+	}{
+		{sub, "chat:a", "first"},
+		{sub, "chat:b", "second"},
+		{other, "chat:b", "second"},
+	}
+	for _, tt := range tests {
+		msg := receive(tt.sub)
+		if msg.Channel != tt.wantChannel || msg.Payload != tt.wantPayload {
+			t.Errorf("got %s %q, want %s %q", msg.Channel, msg.Payload, tt.wantChannel, tt.wantPayload)
+		}
+	}
+
+	// Closing a subscription closes its channel and stops delivery to it
+	sub.Close()
+	if _, ok := <-sub.Channel(); ok {
+		t.Error("closed subscription delivered a message")
+	}
+	m.Publish(ctx, "chat:b", "third")
+	if msg := receive(other); msg.Payload != "third" {
+		t.Errorf("got %q, want %q", msg.Payload, "third")
+	}
+
+	// Closing the client closes the rest
+	m.Close()
+	if _, ok := <-other.Channel(); ok {
+		t.Error("subscription still open after the client closed")
+	}
+	if err := m.Publish(ctx, "chat:b", "late"); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("Publish after Close = %v, want %v", err, redis.ErrClosed)
+	}
+}
+
+func TestMemoryPipeline(t *testing.T) {
+	ctx := context.Background()
+	m := newTestClient(t)
+	m.Set(ctx, "string", "value", 0)
+
+	pipe := m.Pipeline()
+	missing := pipe.Get(ctx, "missing")
+	set := pipe.Set(ctx, "new", "value", time.Minute)
+	wrongType := pipe.HGetAll(ctx, "string")
+	incr := pipe.HIncrBy(ctx, "hash", "field", 2)
+
+	// Nothing runs until Exec
+	if exists, _ := m.Exists(ctx, "new"); exists != 0 {
+		t.Fatal("pipelined command ran before Exec")
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		t.Fatalf("Exec = %v", err)
+	}
+
+	if err := missing.Err(); !errors.Is(err, redis.Nil) {
+		t.Errorf("missing key: err = %v, want redis.Nil", err)
+	}
+	if err := set.Err(); err != nil {
+		t.Errorf("set: %v", err)
+	}
+	if err := wrongType.Err(); err == nil {
+		t.Error("HGETALL on a string succeeded")
+	}
+	if incr.Val() != 2 {
+		t.Errorf("HINCRBY = %d, want 2", incr.Val())
+	}
+	if value, _ := m.Get(ctx, "new"); value != "value" {
+		t.Errorf("Get(new) = %q, want %q", value, "value")
+	}
+}
+
+func TestNewKeyspace(t *testing.T) {
+	tests := []struct {
+		prefix, tenant string
+		wantKey        string
+		wantErr        bool
+	}{
+		{"", "", "session:a", false},
+		{"neartalk", "", "neartalk:session:a", false},
+		{"", "acme", "acme:session:a", false},
+		{"neartalk", "acme-1.eu_west", "neartalk:acme-1.eu_west:session:a", false},
+		{"near:talk", "", "", true},
+		{"", "{acme}", "", true},
+		{"near*", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"/"+tt.tenant, func(t *testing.T) {
+			keys, err := NewKeyspace(tt.prefix, tt.tenant)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKeyspace err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got := keys.Key("session", "a"); got != tt.wantKey {
+					t.Errorf("Key = %q, want %q", got, tt.wantKey)
+				}
+			}
+		})
+	}
+}
+
+func TestCellTag(t *testing.T) {
+	tests := []struct {
+		geohash string
+		want    string
+	}{
+		{"9q8yyk", "{cell:9q8yy}"},
+		{"9q8yyj", "{cell:9q8yy}"},
+		{"9", "{cell:}"},
+		{"", "{cell}"},
+	}
+
+	for _, tt := range tests {
+		if got := CellTag(tt.geohash); got != tt.want {
+			t.Errorf("CellTag(%q) = %q, want %q", tt.geohash, got, tt.want)
+		}
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"closed", redis.ErrClosed, true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"loading", replyError("LOADING Redis is loading the dataset in memory"), true},
+		{"cluster down", fmt.Errorf("wrapped: %w", replyError("CLUSTERDOWN The cluster is down")), true},
+		{"miss", redis.Nil, false},
+		{"wrong type", errWrongType, false},
+		{"cross slot", redis.ErrCrossSlot, false},
+		{"script error", replyError("ERR user_script:1: attempt to compare nil with number"), false},
+		{"wrapped script error", fmt.Errorf("failed to check rate limit: %w", replyError("ERR bad")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unavailable(tt.err); got != tt.want {
+				t.Errorf("Unavailable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
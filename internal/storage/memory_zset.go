@@ -0,0 +1,555 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sortedSet is a sorted set's scores. It is ordered on read, by score and
+// then member, as Redis orders it.
+type sortedSet struct {
+	scores map[string]float64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64)}
+}
+
+func (s *sortedSet) ordered() []redis.Z {
+	members := make([]redis.Z, 0, len(s.scores))
+	for member, score := range s.scores {
+		members = append(members, redis.Z{Score: score, Member: member})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member.(string) < members[j].Member.(string)
+	})
+	return members
+}
+
+// scoreBound is a ZRANGEBYSCORE bound: a number, -inf or +inf, exclusive
+// when prefixed with "(".
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, error) {
+	var bound scoreBound
+	if strings.HasPrefix(s, "(") {
+		bound.exclusive = true
+		s = s[1:]
+	}
+
+	switch strings.ToLower(s) {
+	case "-inf":
+		bound.value = math.Inf(-1)
+	case "+inf", "inf":
+		bound.value = math.Inf(1)
+	default:
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(value) {
+			return bound, errNotFloat
+		}
+		bound.value = value
+	}
+	return bound, nil
+}
+
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return b.value > score
+	}
+	return b.value >= score
+}
+
+func parseScoreRange(min, max string) (scoreBound, scoreBound, error) {
+	low, err := parseScoreBound(min)
+	if err != nil {
+		return low, low, err
+	}
+	high, err := parseScoreBound(max)
+	return low, high, err
+}
+
+func (m *MemoryClient) ZAdd(ctx context.Context, key string, members ...*redis.Z) error {
+	values := make([]redis.Z, len(members))
+	for i, member := range members {
+		values[i] = *member
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.zadd(key, values...)
+}
+
+func (m *MemoryClient) zadd(key string, members ...redis.Z) error {
+	names := make([]string, len(members))
+	for i, member := range members {
+		if math.IsNaN(member.Score) {
+			return errNotFloat
+		}
+		name, err := formatArg(member.Member)
+		if err != nil {
+			return err
+		}
+		names[i] = name
+	}
+
+	entry, err := m.upsert(key, kindZSet)
+	if err != nil {
+		return err
+	}
+	for i, member := range members {
+		entry.zset.scores[names[i]] = member.Score
+	}
+	m.dropIfEmpty(key, entry)
+	return nil
+}
+
+// zrangeByScore returns the members within bounds in score order, reversed
+// if rev.
+func (m *MemoryClient) zrangeByScore(key string, low, high scoreBound, rev bool) ([]redis.Z, error) {
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var members []redis.Z
+	for _, member := range entry.zset.ordered() {
+		if low.below(member.Score) && high.above(member.Score) {
+			members = append(members, member)
+		}
+	}
+	if rev {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	return members, nil
+}
+
+func (m *MemoryClient) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) ([]string, error) {
+	return m.zrangeByScoreOpt(key, opt.Min, opt.Max, opt, false)
+}
+
+func (m *MemoryClient) ZRevRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) ([]string, error) {
+	return m.zrangeByScoreOpt(key, opt.Min, opt.Max, opt, true)
+}
+
+func (m *MemoryClient) zrangeByScoreOpt(key, min, max string, opt *redis.ZRangeBy, rev bool) ([]string, error) {
+	low, high, err := parseScoreRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.zrangeByScoreLimit(key, low, high, opt, rev)
+}
+
+// zrangeByScoreLimit is zrangeByScore with opt's LIMIT applied.
+func (m *MemoryClient) zrangeByScoreLimit(key string, low, high scoreBound, opt *redis.ZRangeBy, rev bool) ([]string, error) {
+	members, err := m.zrangeByScore(key, low, high, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Redis client sends LIMIT when either is set; a negative count
+	// means all
+	if opt.Offset != 0 || opt.Count != 0 {
+		if opt.Offset < 0 || opt.Offset >= int64(len(members)) {
+			members = nil
+		} else {
+			members = members[opt.Offset:]
+		}
+		if opt.Count >= 0 && opt.Count < int64(len(members)) {
+			members = members[:opt.Count]
+		}
+	}
+	return zmembers(members), nil
+}
+
+func (m *MemoryClient) ZRevRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	members, err := m.zrange(key, start, stop, true)
+	return zmembers(members), err
+}
+
+// zrange returns members by rank, from start to stop inclusive, counting
+// from the end for negative ranks, as ZRANGE does.
+func (m *MemoryClient) zrange(key string, start, stop int64, rev bool) ([]redis.Z, error) {
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	members := entry.zset.ordered()
+	if rev {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+
+	length := int64(len(members))
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	return members[start : stop+1], nil
+}
+
+func (m *MemoryClient) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	values, err := formatArgs(members)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	_, err = m.zrem(key, values...)
+	return err
+}
+
+func (m *MemoryClient) zrem(key string, members ...string) (int64, error) {
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	removed := int64(0)
+	for _, member := range members {
+		if _, ok := entry.zset.scores[member]; ok {
+			delete(entry.zset.scores, member)
+			removed++
+		}
+	}
+	m.dropIfEmpty(key, entry)
+	return removed, nil
+}
+
+func (m *MemoryClient) ZRemRangeByScore(ctx context.Context, key, min, max string) error {
+	low, high, err := parseScoreRange(min, max)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	_, err = m.zremRangeByScore(key, low, high)
+	return err
+}
+
+func (m *MemoryClient) zremRangeByScore(key string, low, high scoreBound) (int64, error) {
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var removed int64
+	for member, score := range entry.zset.scores {
+		if low.below(score) && high.above(score) {
+			delete(entry.zset.scores, member)
+			removed++
+		}
+	}
+	m.dropIfEmpty(key, entry)
+	return removed, nil
+}
+
+func (m *MemoryClient) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	members, err := m.zrange(key, start, stop, false)
+	if err != nil {
+		return err
+	}
+	_, err = m.zrem(key, zmembers(members)...)
+	return err
+}
+
+func (m *MemoryClient) ZCard(ctx context.Context, key string) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	return m.zcard(key)
+}
+
+func (m *MemoryClient) zcard(key string) (int64, error) {
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+	return int64(len(entry.zset.scores)), nil
+}
+
+func zmembers(members []redis.Z) []string {
+	names := make([]string, len(members))
+	for i, member := range members {
+		names[i] = member.Member.(string)
+	}
+	return names
+}
+
+// Geo indexes are sorted sets scored by 52-bit interleaved geohashes, as in
+// Redis, so they can be read with the sorted set commands too.
+
+const (
+	geoStep      = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	earthRadiusM = 6372797.560856
+)
+
+func (m *MemoryClient) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) error {
+	members, err := geoMembers(geoLocation)
+	if err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.zadd(key, members...)
+}
+
+// geoMembers converts locations to the sorted set members GEOADD adds.
+func geoMembers(geoLocation []*redis.GeoLocation) ([]redis.Z, error) {
+	members := make([]redis.Z, len(geoLocation))
+	for i, location := range geoLocation {
+		if location.Longitude < geoLonMin || location.Longitude > geoLonMax ||
+			location.Latitude < geoLatMin || location.Latitude > geoLatMax {
+			return nil, replyError("ERR invalid longitude,latitude pair")
+		}
+		members[i] = redis.Z{
+			Score:  float64(geoEncode(location.Latitude, location.Longitude)),
+			Member: location.Name,
+		}
+	}
+	return members, nil
+}
+
+// GeoRadius finds members within the radius, supporting the query's unit,
+// WITH options, COUNT and sort order. Unsorted results come in index order.
+func (m *MemoryClient) GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *redis.GeoRadiusQuery) ([]redis.GeoLocation, error) {
+	if query.Store != "" || query.StoreDist != "" {
+		return nil, errors.New("GeoRadius does not support Store or StoreDist")
+	}
+
+	unit, err := geoUnit(query.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return geoSearch(entry.zset, latitude, longitude, query.Radius, unit, geoSearchOptions{
+		withCoord: query.WithCoord,
+		withDist:  query.WithDist,
+		withHash:  query.WithGeoHash,
+		sort:      query.Sort,
+		count:     query.Count,
+	}), nil
+}
+
+// GeoSearchLocation finds members within a radius of a member or a point.
+// Searching by box and ANY are not supported.
+func (m *MemoryClient) GeoSearchLocation(ctx context.Context, key string, query *redis.GeoSearchLocationQuery) ([]redis.GeoLocation, error) {
+	if query.BoxWidth > 0 || query.BoxHeight > 0 || query.CountAny {
+		return nil, errors.New("GeoSearchLocation supports only BYRADIUS without ANY")
+	}
+
+	unit, err := geoUnit(query.RadiusUnit)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	entry, err := m.lookupKind(key, kindZSet)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	latitude, longitude := query.Latitude, query.Longitude
+	if query.Member != "" {
+		score, ok := entry.zset.scores[query.Member]
+		if !ok {
+			return nil, replyError("ERR could not decode requested zset member")
+		}
+		latitude, longitude = geoDecode(uint64(score))
+	}
+
+	return geoSearch(entry.zset, latitude, longitude, query.Radius, unit, geoSearchOptions{
+		withCoord: query.WithCoord,
+		withDist:  query.WithDist,
+		withHash:  query.WithHash,
+		sort:      query.Sort,
+		count:     query.Count,
+	}), nil
+}
+
+type geoSearchOptions struct {
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	sort      string
+	count     int
+}
+
+// geoSearch returns the members within radius units of the point. Unsorted
+// results come in index order.
+func geoSearch(zset *sortedSet, latitude, longitude, radius, unit float64, opts geoSearchOptions) []redis.GeoLocation {
+	var locations []redis.GeoLocation
+	for _, member := range zset.ordered() {
+		lat, lon := geoDecode(uint64(member.Score))
+		dist := geoDistance(latitude, longitude, lat, lon)
+		if dist > radius*unit {
+			continue
+		}
+
+		location := redis.GeoLocation{Name: member.Member.(string)}
+		if opts.withCoord {
+			location.Latitude, location.Longitude = lat, lon
+		}
+		if opts.withDist {
+			location.Dist = math.Round(dist/unit*10000) / 10000
+		}
+		if opts.withHash {
+			location.GeoHash = int64(member.Score)
+		}
+		locations = append(locations, location)
+	}
+
+	switch strings.ToUpper(opts.sort) {
+	case "ASC", "DESC":
+		desc := strings.ToUpper(opts.sort) == "DESC"
+		sort.SliceStable(locations, func(i, j int) bool {
+			di := geoDistanceTo(latitude, longitude, zset.scores[locations[i].Name])
+			dj := geoDistanceTo(latitude, longitude, zset.scores[locations[j].Name])
+			if desc {
+				return di > dj
+			}
+			return di < dj
+		})
+	}
+	if opts.count > 0 && opts.count < len(locations) {
+		locations = locations[:opts.count]
+	}
+	return locations
+}
+
+// geoUnit returns meters per unit; the Redis client defaults to km.
+func geoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km", "":
+		return 1000, nil
+	case "mi":
+		return 1609.34, nil
+	case "ft":
+		return 0.3048, nil
+	}
+	return 0, replyError("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func geoEncode(lat, lon float64) uint64 {
+	latBits := uint32((lat - geoLatMin) / (geoLatMax - geoLatMin) * (1 << geoStep))
+	lonBits := uint32((lon - geoLonMin) / (geoLonMax - geoLonMin) * (1 << geoStep))
+	return interleave(latBits, lonBits)
+}
+
+// geoDecode returns the center of a geohash's cell.
+func geoDecode(hash uint64) (float64, float64) {
+	latBits, lonBits := deinterleave(hash)
+	cells := float64(uint64(1) << geoStep)
+	lat := geoLatMin + (float64(latBits)+0.5)/cells*(geoLatMax-geoLatMin)
+	lon := geoLonMin + (float64(lonBits)+0.5)/cells*(geoLonMax-geoLonMin)
+	return lat, lon
+}
+
+// interleave puts x's bits in the even positions and y's in the odd ones.
+func interleave(x, y uint32) uint64 {
+	var hash uint64
+	for i := 0; i < geoStep; i++ {
+		hash |= uint64(x>>i&1) << (2 * i)
+		hash |= uint64(y>>i&1) << (2*i + 1)
+	}
+	return hash
+}
+
+func deinterleave(hash uint64) (uint32, uint32) {
+	var x, y uint32
+	for i := 0; i < geoStep; i++ {
+		x |= uint32(hash>>(2*i)&1) << i
+		y |= uint32(hash>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+// geoDistance is the haversine distance in meters, with Redis's earth
+// radius.
+func geoDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1r, lat2r := lat1*math.Pi/180, lat2*math.Pi/180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func geoDistanceTo(lat, lon, score float64) float64 {
+	memberLat, memberLon := geoDecode(uint64(score))
+	return geoDistance(lat, lon, memberLat, memberLon)
+}
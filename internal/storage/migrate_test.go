@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fakeDB stands in for Postgres: it keeps schema_migrations in memory and
+// records the migration SQL run, applying a transaction's changes only on
+// commit.
+type fakeDB struct {
+	mu      sync.Mutex
+	applied map[int]appliedMigration
+	ran     []string
+	// failOn makes running this migration SQL fail
+	failOn string
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+
+	fake := &fakeDB{applied: make(map[int]appliedMigration)}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return fake, db
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+// fakeTx buffers a transaction's changes until Commit.
+type fakeTx struct {
+	conn    *fakeConn
+	ran     []string
+	insert  map[int]appliedMigration
+	deletes []int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakeConn: prepared statements not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.tx = &fakeTx{conn: c, insert: make(map[int]appliedMigration)}
+	return c.tx, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	trimmed := strings.TrimSpace(query)
+	switch {
+	case strings.HasPrefix(trimmed, "SELECT pg_advisory"),
+		strings.HasPrefix(trimmed, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		return driver.RowsAffected(0), nil
+	case c.tx == nil:
+		return nil, errors.New("fakeConn: migration SQL outside a transaction")
+	case strings.HasPrefix(trimmed, "INSERT INTO schema_migrations"):
+		c.tx.insert[int(args[0].Value.(int64))] = appliedMigration{
+			name:      args[1].Value.(string),
+			checksum:  args[2].Value.(string),
+			appliedAt: time.Now(),
+		}
+	case strings.HasPrefix(trimmed, "DELETE FROM schema_migrations"):
+		c.tx.deletes = append(c.tx.deletes, int(args[0].Value.(int64)))
+	default:
+		if query == c.db.failOn {
+			return nil, errors.New("syntax error")
+		}
+		c.tx.ran = append(c.tx.ran, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	rows := &fakeRows{}
+	for version, row := range c.db.applied {
+		rows.values = append(rows.values, []driver.Value{int64(version), row.name, row.checksum, row.appliedAt})
+	}
+	return rows, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.ran = append(db.ran, tx.ran...)
+	for version, row := range tx.insert {
+		db.applied[version] = row
+	}
+	for _, version := range tx.deletes {
+		delete(db.applied, version)
+	}
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version", "name", "checksum", "applied_at"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// testMigrations has versions out of name order, a migration without a down
+// file and a file that is not a migration.
+var testMigrations = fstest.MapFS{
+	"0010_add_index.up.sql":      {Data: []byte("CREATE INDEX c")},
+	"0010_add_index.down.sql":    {Data: []byte("DROP INDEX c")},
+	"0002_add_column.up.sql":     {Data: []byte("ALTER TABLE b")},
+	"0001_create_table.up.sql":   {Data: []byte("CREATE TABLE a")},
+	"0001_create_table.down.sql": {Data: []byte("DROP TABLE a")},
+	"README.md":                  {Data: []byte("not a migration")},
+}
+
+// newTestMigrator returns a migrator for fsys on a fake database.
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *fakeDB) {
+	t.Helper()
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake, db := newFakeDB(t)
+	return &Migrator{db: db, migrations: migrations}, fake
+}
+
+func versions(migrations []Migration) []int {
+	var got []int
+	for _, migration := range migrations {
+		got = append(got, migration.Version)
+	}
+	return got
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "create_table", Up: "CREATE TABLE a", Down: "DROP TABLE a"},
+		{Version: 2, Name: "add_column", Up: "ALTER TABLE b"},
+		{Version: 10, Name: "add_index", Up: "CREATE INDEX c", Down: "DROP INDEX c"},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Errorf("LoadMigrations = %+v, want %+v", migrations, want)
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"two names", fstest.MapFS{
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a")},
+			"0001_b.up.sql": {Data: []byte("CREATE TABLE b")},
+		}, "has two names"},
+		{"no up file", fstest.MapFS{
+			"0001_a.up.sql":   {Data: []byte("CREATE TABLE a")},
+			"0002_b.down.sql": {Data: []byte("DROP TABLE b")},
+		}, "has no up file"},
+	}
+
+	for _, tt := range tests {
+		if _, err := LoadMigrations(tt.fsys); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: LoadMigrations error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrator, err := NewMigrator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(migrator.migrations); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("embedded migrations %v, want 1 and 2", got)
+	}
+	for _, migration := range migrator.migrations {
+		if migration.Down == "" {
+			t.Errorf("migration %d (%s) has no down file", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestMigratorUp(t *testing.T) {
+	ctx := context.Background()
+	migrator, fake := newTestMigrator(t, testMigrations)
+
+	done, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{1, 2, 10}) {
+		t.Errorf("Up applied %v, want 1, 2 and 10", got)
+	}
+	if want := []string{"CREATE TABLE a", "ALTER TABLE b", "CREATE INDEX c"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q, want %q", fake.ran, want)
+	}
+
+	// Applied migrations are not run again
+	done, err = migrator.Up(ctx)
+	if err != nil || len(done) != 0 {
+		t.Errorf("second Up applied %v, %v, want nothing", versions(done), err)
+	}
+	pending, err := migrator.Pending(ctx)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("Pending = %v, %v, want none", versions(pending), err)
+	}
+}
+
+func TestMigratorUpFailure(t *testing.T) {
+	ctx := context.Background()
+	migrator, fake := newTestMigrator(t, testMigrations)
+	fake.failOn = "ALTER TABLE b"
+
+	done, err := migrator.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (add_column) failed") {
+		t.Errorf("Up error %v, want migration 2 to fail", err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Up applied %v, want only 1", got)
+	}
+
+	// The failed migration is not recorded and is still pending
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !reflect.DeepEqual(got, []int{2, 10}) {
+		t.Errorf("Pending = %v, want 2 and 10", got)
+	}
+}
+
+// TestMigratorRefusesChanges checks nothing runs once the database and the
+// migration files disagree.
+func TestMigratorRefusesChanges(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		change func(m *Migrator)
+		want   string
+	}{
+		{"modified", func(m *Migrator) {
+			m.migrations[0].Up = "CREATE TABLE a (id INT)"
+		}, "migration 1 (create_table) was changed after it was applied"},
+		{"unknown", func(m *Migrator) {
+			m.migrations = m.migrations[1:]
+		}, "database has migration 1 (create_table), which this build does not know"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrator, fake := newTestMigrator(t, testMigrations)
+			// Only the first migration is applied, so Up would have work to do
+			migrator.migrations = migrator.migrations[:1]
+			if _, err := migrator.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+			all, _ := LoadMigrations(testMigrations)
+			migrator.migrations = all
+			tt.change(migrator)
+			fake.ran = nil
+
+			if _, err := migrator.Up(ctx); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Up error %v, want %q", err, tt.want)
+			}
+			if _, err := migrator.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Down error %v, want %q", err, tt.want)
+			}
+			if _, err := migrator.Pending(ctx); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Pending error %v, want %q", err, tt.want)
+			}
+			if len(fake.ran) != 0 {
+				t.Errorf("ran %q, want nothing", fake.ran)
+			}
+		})
+	}
+
+	// Status still reports a modified migration rather than failing
+	migrator, _ := newTestMigrator(t, testMigrations)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrator.migrations[0].Up = "CREATE TABLE a (id INT)"
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.Modified != (status.Version == 1) {
+			t.Errorf("status of %d: applied %v, modified %v", status.Version, status.Applied, status.Modified)
+		}
+	}
+}
+
+func TestMigratorDown(t *testing.T) {
+	ctx := context.Background()
+	migrator, fake := newTestMigrator(t, testMigrations)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.ran = nil
+
+	// Newest first, and no more than asked
+	done, err := migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{10}) {
+		t.Errorf("Down(1) reverted %v, want 10", got)
+	}
+	if want := []string{"DROP INDEX c"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q, want %q", fake.ran, want)
+	}
+
+	// Migration 2 has no down file, so reverting it fails and it stays applied
+	done, err = migrator.Down(ctx, 2)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (add_column) cannot be reverted") {
+		t.Errorf("Down(2) error %v, want migration 2 to be irreversible", err)
+	}
+	if len(done) != 0 {
+		t.Errorf("Down(2) reverted %v, want nothing", versions(done))
+	}
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !reflect.DeepEqual(got, []int{10}) {
+		t.Errorf("Pending = %v, want only 10", got)
+	}
+
+	// Steps skip migrations that are not applied
+	migrator.migrations[1].Down = "ALTER TABLE b DROP"
+	done, err = migrator.Down(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("Down(5) reverted %v, want 2 then 1", got)
+	}
+	if want := []string{"DROP INDEX c", "ALTER TABLE b DROP", "DROP TABLE a"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q, want %q", fake.ran, want)
+	}
+}
@@ -0,0 +1,12 @@
+CREATE TABLE bans (
+	id BIGSERIAL PRIMARY KEY,
+	session_id VARCHAR(255),
+	ip_address VARCHAR(45),
+	reason TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_bans_session_id ON bans (session_id);
+CREATE INDEX idx_bans_ip_address ON bans (ip_address);
+CREATE INDEX idx_bans_expires_at ON bans (expires_at);
@@ -0,0 +1,9 @@
+CREATE TABLE analytics (
+	id BIGSERIAL PRIMARY KEY,
+	date DATE NOT NULL UNIQUE,
+	total_sessions INT NOT NULL DEFAULT 0,
+	total_messages INT NOT NULL DEFAULT 0,
+	unique_locations INT NOT NULL DEFAULT 0,
+	avg_radius DOUBLE PRECISION NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
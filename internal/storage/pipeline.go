@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pipeline queues commands and sends them in one round trip on Exec. The
+// returned commands hold their results once Exec returns.
+type Pipeline interface {
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) *redis.IntCmd
+
+	// Exec sends the queued commands. A missing key (redis.Nil) is left on
+	// its command rather than returned, so Exec only fails for real errors.
+	Exec(ctx context.Context) error
+}
+
+type redisPipeline struct {
+	redis.Pipeliner
+}
+
+func (p redisPipeline) Get(ctx context.Context, key string) *redis.StringCmd {
+	return p.Pipeliner.Get(ctx, key)
+}
+
+func (p redisPipeline) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return p.Pipeliner.Set(ctx, key, value, expiration)
+}
+
+func (p redisPipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return p.Pipeliner.Del(ctx, keys...)
+}
+
+func (p redisPipeline) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	return p.Pipeliner.Expire(ctx, key, expiration)
+}
+
+func (p redisPipeline) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	return p.Pipeliner.SAdd(ctx, key, members...)
+}
+
+func (p redisPipeline) SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	return p.Pipeliner.SRem(ctx, key, members...)
+}
+
+func (p redisPipeline) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	return p.Pipeliner.SMembers(ctx, key)
+}
+
+func (p redisPipeline) HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd {
+	return p.Pipeliner.HGetAll(ctx, key)
+}
+
+func (p redisPipeline) HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd {
+	return p.Pipeliner.HIncrBy(ctx, key, field, incr)
+}
+
+func (p redisPipeline) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
+	return p.Pipeliner.ZAdd(ctx, key, members...)
+}
+
+func (p redisPipeline) ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	return p.Pipeliner.ZRem(ctx, key, members...)
+}
+
+func (p redisPipeline) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
+	return p.Pipeliner.ZRangeByScore(ctx, key, opt)
+}
+
+func (p redisPipeline) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) *redis.IntCmd {
+	return p.Pipeliner.GeoAdd(ctx, key, geoLocation...)
+}
+
+func (p redisPipeline) Exec(ctx context.Context) error {
+	cmds, err := p.Pipeliner.Exec(ctx)
+	if !errors.Is(err, redis.Nil) {
+		return err
+	}
+	// go-redis returns the first failed command's error, which may be a miss
+	// queued before a real failure
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pipeline starts a pipeline. In cluster mode its commands are routed to
+// their slots' nodes.
+func (r *redisClient) Pipeline() Pipeline {
+	return redisPipeline{r.client.Pipeline()}
+}
+
+// MGet returns each key's value, or nil where the key is missing. In cluster
+// mode the keys are fetched with pipelined GETs, since they may be in
+// different slots.
+func (r *redisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return []interface{}{}, nil
+	}
+	if r.cluster == nil {
+		return r.client.MGet(ctx, keys...).Result()
+	}
+
+	pipe := r.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		if cmd.Err() == nil {
+			values[i] = cmd.Val()
+		}
+	}
+	return values, nil
+}
@@ -31,6 +31,8 @@ type AnalyticsRecord struct {
 	AvgRadius       float64
 }
 
+// NewPostgresClient connects to Postgres. The schema is managed by
+// migrations; see Migrator.
 func NewPostgresClient(connStr string) (*PostgresClient, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -38,46 +40,16 @@ func NewPostgresClient(connStr string) (*PostgresClient, error) {
 	}
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	client := &PostgresClient{db: db}
-
-	// Initialize schema
-	if err := client.initSchema(); err != nil {
-		return nil, err
-	}
-
-	return client, nil
+	return &PostgresClient{db: db}, nil
 }
 
-func (p *PostgresClient) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS bans (
-		id SERIAL PRIMARY KEY,
-		session_id VARCHAR(255),
-		ip_address VARCHAR(45),
-		reason TEXT,
-		expires_at TIMESTAMP,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		INDEX idx_session_id (session_id),
-		INDEX idx_ip_address (ip_address),
-		INDEX idx_expires_at (expires_at)
-	);
-
-	CREATE TABLE IF NOT EXISTS analytics (
-		id SERIAL PRIMARY KEY,
-		date DATE UNIQUE,
-		total_sessions INT DEFAULT 0,
-		total_messages INT DEFAULT 0,
-		unique_locations INT DEFAULT 0,
-		avg_radius FLOAT DEFAULT 0,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	_, err := p.db.Exec(schema)
-	return err
+// Migrator returns a migrator for the embedded migrations.
+func (p *PostgresClient) Migrator() (*Migrator, error) {
+	return NewMigrator(p.db)
 }
 
 func (p *PostgresClient) Close() error {
@@ -91,11 +63,12 @@ func (p *PostgresClient) AddBan(ctx context.Context, sessionID, ipAddress, reaso
 		VALUES ($1, $2, $3, $4)
 	`
 	expiresAt := time.Now().Add(duration)
-	_, err := p.db.ExecContext(ctx, query, sessionID, ipAddress, reason, expiresAt)
+	_, err := p.db.ExecContext(ctx, query, nullString(sessionID), nullString(ipAddress), reason, expiresAt)
 	return err
 }
 
 func (p *PostgresClient) IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, error) {
+	// Missing values are NULL and never match
 	query := `
 		SELECT COUNT(*) FROM bans
 		WHERE (session_id = $1 OR ip_address = $2)
@@ -103,7 +76,7 @@ func (p *PostgresClient) IsBanned(ctx context.Context, sessionID, ipAddress stri
 	`
 
 	var count int
-	err := p.db.QueryRowContext(ctx, query, sessionID, ipAddress).Scan(&count)
+	err := p.db.QueryRowContext(ctx, query, nullString(sessionID), nullString(ipAddress)).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -121,7 +94,7 @@ func (p *PostgresClient) GetBanReason(ctx context.Context, sessionID, ipAddress
 	`
 
 	var reason string
-	err := p.db.QueryRowContext(ctx, query, sessionID, ipAddress).Scan(&reason)
+	err := p.db.QueryRowContext(ctx, query, nullString(sessionID), nullString(ipAddress)).Scan(&reason)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
@@ -132,6 +105,11 @@ func (p *PostgresClient) GetBanReason(ctx context.Context, sessionID, ipAddress
 	return reason, nil
 }
 
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 func (p *PostgresClient) CleanupExpiredBans(ctx context.Context) error {
 	query := `DELETE FROM bans WHERE expires_at <= NOW()`
 	_, err := p.db.ExecContext(ctx, query)
@@ -139,21 +117,30 @@ func (p *PostgresClient) CleanupExpiredBans(ctx context.Context) error {
 }
 
 // Analytics operations
+
+// RecordDailyStats stores the totals for date's day, replacing any recorded
+// before, so the same day can be written repeatedly as it goes on.
 func (p *PostgresClient) RecordDailyStats(ctx context.Context, date time.Time, sessions, messages, locations int, avgRadius float64) error {
 	query := `
 		INSERT INTO analytics (date, total_sessions, total_messages, unique_locations, avg_radius)
 		VALUES ($1, $2, $3, $4, $5)
 		ON CONFLICT (date) DO UPDATE SET
-			total_sessions = analytics.total_sessions + EXCLUDED.total_sessions,
-			total_messages = analytics.total_messages + EXCLUDED.total_messages,
+			total_sessions = EXCLUDED.total_sessions,
+			total_messages = EXCLUDED.total_messages,
 			unique_locations = EXCLUDED.unique_locations,
 			avg_radius = EXCLUDED.avg_radius
 	`
 
-	_, err := p.db.ExecContext(ctx, query, date, sessions, messages, locations, avgRadius)
+	_, err := p.db.ExecContext(ctx, query, pgDate(date), sessions, messages, locations, avgRadius)
 	return err
 }
 
+// pgDate formats t as a DATE, so the day does not depend on the session
+// time zone.
+func pgDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
 func (p *PostgresClient) GetAnalytics(ctx context.Context, startDate, endDate time.Time) ([]AnalyticsRecord, error) {
 	query := `
 		SELECT id, date, total_sessions, total_messages, unique_locations, avg_radius
@@ -162,7 +149,7 @@ func (p *PostgresClient) GetAnalytics(ctx context.Context, startDate, endDate ti
 		ORDER BY date DESC
 	`
 
-	rows, err := p.db.QueryContext(ctx, query, startDate, endDate)
+	rows, err := p.db.QueryContext(ctx, query, pgDate(startDate), pgDate(endDate))
 	if err != nil {
 		return nil, err
 	}
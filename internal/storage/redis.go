@@ -2,6 +2,12 @@ package storage
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/askwhyharsh/neartalk/internal/config"
@@ -9,24 +15,34 @@ import (
 )
 
 type RedisClient interface {
+	// Keys builds the keys and channel names of the configured keyspace
+	Keys() Keyspace
+	// Clustered reports whether keys are spread over Redis Cluster slots, so
+	// a script or transaction may only use keys that share a hash tag
+	Clustered() bool
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
 	SCard(ctx context.Context, key string) (int64, error)
 	Get(ctx context.Context, key string) (string, error)
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
 	Del(ctx context.Context, keys ...string) error
 	Exists(ctx context.Context, keys ...string) (int64, error)
+	Rename(ctx context.Context, key, newKey string) error
 	Incr(ctx context.Context, key string) (int64, error)
 	Expire(ctx context.Context, key string, expiration time.Duration) error
 	ZAdd(ctx context.Context, key string, members ...*redis.Z) error
 	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) ([]string, error)
 	ZRevRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) ([]string, error)
 	ZRevRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	ZRem(ctx context.Context, key string, members ...interface{}) error
 	ZRemRangeByScore(ctx context.Context, key, min, max string) error
+	ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error
 	ZCard(ctx context.Context, key string) (int64, error)
 	Publish(ctx context.Context, channel string, message interface{}) error
-	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+	Subscribe(ctx context.Context, channels ...string) Subscription
 	GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) error
 	GeoRadius(ctx context.Context, key string, longitude, latitude float64, query *redis.GeoRadiusQuery) ([]redis.GeoLocation, error)
+	GeoSearchLocation(ctx context.Context, key string, query *redis.GeoSearchLocationQuery) ([]redis.GeoLocation, error)
 	HSet(ctx context.Context, key string, values ...interface{}) error
 	HGet(ctx context.Context, key, field string) (string, error)
 	HGetAll(ctx context.Context, key string) (map[string]string, error)
@@ -34,32 +50,153 @@ type RedisClient interface {
 	SAdd(ctx context.Context, key string, members ...interface{}) error
 	SMembers(ctx context.Context, key string) ([]string, error)
 	SRem(ctx context.Context, key string, members ...interface{}) error
+	PFAdd(ctx context.Context, key string, elements ...interface{}) error
+	PFCount(ctx context.Context, keys ...string) (int64, error)
+	RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error)
+	Pipeline() Pipeline
 	Ping(ctx context.Context) error
 	Close() error
 }
 
+// Subscription delivers messages published to the channels subscribed to.
+type Subscription interface {
+	Channel() <-chan *redis.Message
+	Close() error
+}
+
 type redisClient struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// cluster is set in cluster mode, where multi-key commands must not
+	// span slots and SCAN must visit every master
+	cluster *redis.ClusterClient
+	keys    Keyspace
 }
 
+// NewClient creates the client for the configured storage: Redis, or memory
+// for a single node.
+func NewClient(cfg *config.Config) (RedisClient, error) {
+	switch cfg.Redis.Storage {
+	case "redis", "":
+		return NewRedisClient(cfg)
+	case "memory":
+		keys, err := NewKeyspace(cfg.Redis.KeyPrefix, cfg.Redis.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		m := NewMemoryClient()
+		m.keys = keys
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown storage %q (want redis or memory)", cfg.Redis.Storage)
+}
+
+// NewRedisClient connects to a single Redis server, a Sentinel-managed
+// master or a Redis Cluster, depending on cfg.Redis.Mode.
 func NewRedisClient(cfg *config.Config) (RedisClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr(),
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	})
+	tlsConfig, err := redisTLSConfig(cfg.Redis.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := NewKeyspace(cfg.Redis.KeyPrefix, cfg.Redis.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &redisClient{keys: keys}
+	switch cfg.Redis.Mode {
+	case "standalone", "":
+		r.client = redis.NewClient(&redis.Options{
+			Addr:      cfg.RedisAddr(),
+			Username:  cfg.Redis.Username,
+			Password:  cfg.Redis.Password,
+			DB:        cfg.Redis.DB,
+			TLSConfig: tlsConfig,
+		})
+	case "sentinel":
+		if cfg.Redis.MasterName == "" {
+			return nil, fmt.Errorf("sentinel mode needs REDIS_MASTER_NAME")
+		}
+		r.client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.Redis.MasterName,
+			SentinelAddrs:    cfg.RedisAddrs(),
+			SentinelUsername: cfg.Redis.SentinelUsername,
+			SentinelPassword: cfg.Redis.SentinelPassword,
+			Username:         cfg.Redis.Username,
+			Password:         cfg.Redis.Password,
+			DB:               cfg.Redis.DB,
+			TLSConfig:        tlsConfig,
+		})
+	case "cluster":
+		if cfg.Redis.DB != 0 {
+			return nil, fmt.Errorf("cluster mode only supports database 0, not %d", cfg.Redis.DB)
+		}
+		r.cluster = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     cfg.RedisAddrs(),
+			Username:  cfg.Redis.Username,
+			Password:  cfg.Redis.Password,
+			TLSConfig: tlsConfig,
+		})
+		r.client = r.cluster
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q (want standalone, sentinel or cluster)", cfg.Redis.Mode)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		r.client.Close()
 		return nil, err
 	}
 
-	return &redisClient{client: client}, nil
+	return r, nil
+}
+
+// redisTLSConfig builds the TLS config, or returns nil when TLS is off.
+func redisTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Redis CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (r *redisClient) Keys() Keyspace {
+	return r.keys
+}
+
+func (r *redisClient) Clustered() bool {
+	return r.cluster != nil
 }
 
-func (r *redisClient) Raw() *redis.Client {
+func (r *redisClient) Raw() redis.UniversalClient {
 	return r.client
 }
 
@@ -71,12 +208,45 @@ func (r *redisClient) Get(ctx context.Context, key string) (string, error) {
 	return r.client.Get(ctx, key).Result()
 }
 
+// Del deletes keys. In cluster mode each key is deleted separately, in one
+// pipeline, since the keys may be in different slots.
 func (r *redisClient) Del(ctx context.Context, keys ...string) error {
-	return r.client.Del(ctx, keys...).Err()
+	if r.cluster == nil || len(keys) < 2 {
+		return r.client.Del(ctx, keys...).Err()
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
 }
 
+// Exists counts the keys that exist, checking each separately in cluster
+// mode as Del does.
 func (r *redisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
-	return r.client.Exists(ctx, keys...).Result()
+	if r.cluster == nil || len(keys) < 2 {
+		return r.client.Exists(ctx, keys...).Result()
+	}
+
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for _, cmd := range cmds {
+		count += cmd.Val()
+	}
+	return count, nil
 }
 
 func (r *redisClient) Incr(ctx context.Context, key string) (int64, error) {
@@ -99,10 +269,18 @@ func (r *redisClient) ZRangeByScore(ctx context.Context, key string, opt *redis.
 	return r.client.ZRangeByScore(ctx, key, opt).Result()
 }
 
+func (r *redisClient) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	return r.client.ZRem(ctx, key, members...).Err()
+}
+
 func (r *redisClient) ZRemRangeByScore(ctx context.Context, key, min, max string) error {
 	return r.client.ZRemRangeByScore(ctx, key, min, max).Err()
 }
 
+func (r *redisClient) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	return r.client.ZRemRangeByRank(ctx, key, start, stop).Err()
+}
+
 func (r *redisClient) ZRevRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) ([]string, error) {
 	return r.client.ZRevRangeByScore(ctx, key, opt).Result()
 }
@@ -119,8 +297,20 @@ func (r *redisClient) Publish(ctx context.Context, channel string, message inter
 	return r.client.Publish(ctx, channel, message).Err()
 }
 
-func (r *redisClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
-	return r.client.Subscribe(ctx, channels...)
+func (r *redisClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	return redisSubscription{r.client.Subscribe(ctx, channels...)}
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+}
+
+func (s redisSubscription) Channel() <-chan *redis.Message {
+	return s.pubsub.Channel()
+}
+
+func (s redisSubscription) Close() error {
+	return s.pubsub.Close()
 }
 
 func (r *redisClient) GeoAdd(ctx context.Context, key string, geoLocation ...*redis.GeoLocation) error {
@@ -131,6 +321,10 @@ func (r *redisClient) GeoRadius(ctx context.Context, key string, longitude, lati
 	return r.client.GeoRadius(ctx, key, longitude, latitude, query).Result()
 }
 
+func (r *redisClient) GeoSearchLocation(ctx context.Context, key string, query *redis.GeoSearchLocationQuery) ([]redis.GeoLocation, error) {
+	return r.client.GeoSearchLocation(ctx, key, query).Result()
+}
+
 func (r *redisClient) HSet(ctx context.Context, key string, values ...interface{}) error {
 	return r.client.HSet(ctx, key, values...).Err()
 }
@@ -159,6 +353,41 @@ func (r *redisClient) SRem(ctx context.Context, key string, members ...interface
 	return r.client.SRem(ctx, key, members...).Err()
 }
 
+func (r *redisClient) PFAdd(ctx context.Context, key string, elements ...interface{}) error {
+	return r.client.PFAdd(ctx, key, elements...).Err()
+}
+
+func (r *redisClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	return r.client.PFCount(ctx, keys...).Result()
+}
+
+// RunScript runs a Lua script with EVALSHA, falling back to EVAL the first
+// time the server sees it.
+func (r *redisClient) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.Run(ctx, r.client, keys, args...).Result()
+}
+
+// Unavailable reports whether err means Redis cannot serve requests right
+// now: it could not be reached, or it replied that it is loading, failing
+// over or without a master. Errors Redis replies with for the command
+// itself, such as a script error, a wrong type or keys in different cluster
+// slots, would recur however healthy Redis is, so they are not.
+func Unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var reply redis.Error
+	if !errors.As(err, &reply) {
+		return true
+	}
+	for _, prefix := range []string{"LOADING", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "READONLY", "max number of clients"} {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *redisClient) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
@@ -167,10 +396,51 @@ func (r *redisClient) Close() error {
 	return r.client.Close()
 }
 
+// Rename moves key to newKey, replacing it. In cluster mode the keys may be
+// in different slots, so the value is copied with DUMP and RESTORE instead.
+func (r *redisClient) Rename(ctx context.Context, key, newKey string) error {
+	if r.cluster == nil {
+		return r.client.Rename(ctx, key, newKey).Err()
+	}
+
+	dump, err := r.client.Dump(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	ttl, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	if err := r.client.RestoreReplace(ctx, newKey, ttl, dump).Err(); err != nil {
+		return err
+	}
+	return r.client.Del(ctx, key).Err()
+}
+
 func (r *redisClient) SCard(ctx context.Context, key string) (int64, error) {
 	return r.client.SCard(ctx, key).Result()
 }
 
+// Scan scans the keyspace. In cluster mode every master is scanned in full
+// and the keys come back in one page with a zero cursor.
 func (r *redisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
-	return r.client.Scan(ctx, cursor, match, count)
+	if r.cluster == nil {
+		return r.client.Scan(ctx, cursor, match, count)
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	err := r.cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		iter := node.Scan(ctx, 0, match, count).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	return redis.NewScanCmdResult(keys, 0, err)
 }
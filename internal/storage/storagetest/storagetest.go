@@ -0,0 +1,18 @@
+// Package storagetest provides Redis clients for tests.
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/askwhyharsh/neartalk/internal/storage"
+)
+
+// NewClient returns an in-memory RedisClient that is closed when the test
+// ends.
+func NewClient(tb testing.TB) *storage.MemoryClient {
+	tb.Helper()
+
+	redisClient := storage.NewMemoryClient()
+	tb.Cleanup(func() { redisClient.Close() })
+	return redisClient
+}
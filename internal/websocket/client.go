@@ -3,9 +3,9 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
@@ -27,6 +27,7 @@ type Client struct {
 	send      chan *Message
 	sessionID string
 	username  string
+	ip        string
 	geohash   string
 	radius    int
 	ctx       context.Context
@@ -35,7 +36,7 @@ type Client struct {
 
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, sessionID, username, geohash string, radius int, handler MessageHandler) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, sessionID, username, ip, geohash string, radius int, handler MessageHandler) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
 		hub:       hub,
@@ -43,6 +44,7 @@ func NewClient(hub *Hub, conn *websocket.Conn, sessionID, username, geohash stri
 		send:      make(chan *Message, 256),
 		sessionID: sessionID,
 		username:  username,
+		ip:        ip,
 		geohash:   geohash,
 		radius:    radius,
 		ctx:       ctx,
@@ -67,7 +69,6 @@ func (c *Client) ReadPump() {
 
 	for {
 		_, message, err := c.conn.ReadMessage()
-		fmt.Println("message received", message)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
@@ -81,7 +82,6 @@ func (c *Client) ReadPump() {
 			continue
 		}
 
-		fmt.Println("msg type", msg.Type, msg.Content)
 	
 		// Handle message based on type
 		switch msg.Type {
@@ -179,7 +179,7 @@ func (c *Client) shouldReceiveMessage(msg *Message) bool {
 	}
 
 	// Simple proximity check - in production, use proper distance calculation
-	return c.geohash[:4] == msg.Geohash[:4]
+	return roomPrefix(c.geohash) == roomPrefix(msg.Geohash)
 }
 
 func (c *Client) UpdateLocation(geohash string, radius int) {
@@ -192,14 +192,38 @@ func (c *Client) UpdateUsername(username string) {
 }
 
 func (c *Client) SendError(errMsg string, code string) {
-	msg := &Message{
-		Type:      MessageTypeError,
-		Content:   errMsg,
-		ErrorCode: code,
-		Timestamp: time.Now().Unix(),
-	}
+	c.Send(NewErrorMessage(errMsg, code))
+}
+
+// Send queues a message for the client, dropping it if the buffer is full.
+func (c *Client) Send(msg *Message) {
 	select {
 	case c.send <- msg:
 	default:
 	}
-}
\ No newline at end of file
+}
+
+// Disconnect sends a close frame with the given code and reason, then closes
+// the connection. ReadPump notices the closed connection and unregisters.
+func (c *Client) Disconnect(code int, reason string) {
+	deadline := time.Now().Add(writeWait)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, closeReason(reason)), deadline)
+	c.conn.Close()
+}
+
+// maxCloseReason is the longest close reason: close frame payloads are
+// limited to 125 bytes, 2 of which hold the code.
+const maxCloseReason = 123
+
+// closeReason cuts reason to fit a close frame, at a rune boundary so it
+// stays valid UTF-8 as the protocol requires.
+func closeReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	end := maxCloseReason
+	for end > 0 && !utf8.RuneStart(reason[end]) {
+		end--
+	}
+	return reason[:end]
+}
@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{"short", "banned", "banned"},
+		{"exactly the limit", strings.Repeat("a", 123), strings.Repeat("a", 123)},
+		{"ascii cut", strings.Repeat("a", 130), strings.Repeat("a", 123)},
+		// "é" is 2 bytes, starting at byte 122
+		{"cut inside a rune", strings.Repeat("a", 122) + "é" + "b", strings.Repeat("a", 122)},
+		// "€" is 3 bytes; 41 of them are exactly 123
+		{"runes ending at the limit", strings.Repeat("€", 42), strings.Repeat("€", 41)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := closeReason(tt.reason)
+			if got != tt.want {
+				t.Errorf("closeReason = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("closeReason = %q, not valid UTF-8", got)
+			}
+		})
+	}
+}
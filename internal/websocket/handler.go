@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/askwhyharsh/neartalk/internal/clientip"
 	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/ratelimit"
 	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/spam"
 	"github.com/askwhyharsh/neartalk/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -30,17 +35,44 @@ type Handler struct {
 	sessionGetter  session.SessionService
 	locationGetter location.LocationService
 	spamDetector   SpamDetector
+	bans           BanChecker
+	redactor       Redactor
 	rateLimiter    RateLimiter
+	reputation     Reputation
+	analytics      Analytics
 	messageTTL     time.Duration
 }
 
 type SpamDetector interface {
-	ValidateMessage(ctx context.Context, sessionID, content string) error
+	EvaluateScaled(ctx context.Context, sessionID, geohash, content string, multiplier float64) (*spam.Decision, error)
 	IncrementViolation(ctx context.Context, sessionID, violationType string) error
+	ShouldBan(ctx context.Context, sessionID string) (bool, string, error)
+	ResetViolations(ctx context.Context, sessionID string) error
+}
+
+type BanChecker interface {
+	Enforce(ctx context.Context, sessionID, ipAddress, reason string) (spam.BanAction, time.Duration, error)
+	IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, string, error)
+	IsShadowBanned(ctx context.Context, sessionID, ipAddress string) (bool, error)
+}
+
+type Redactor interface {
+	Redact(content string) (string, []string)
 }
 
 type RateLimiter interface {
-	AllowMessage(ctx context.Context, sessionID string) (bool, error)
+	AllowScaled(ctx context.Context, action ratelimit.Action, id string, multiplier float64) (*ratelimit.Result, error)
+	AllowAreaMessage(ctx context.Context, sessionID string, cells []string) (*ratelimit.AreaResult, error)
+}
+
+// Reputation scales a sender's message limit and spam thresholds. It is
+// looked up once per message and shared by both checks.
+type Reputation interface {
+	Multiplier(ctx context.Context, sessionID string) (float64, error)
+}
+
+type Analytics interface {
+	RecordMessage(ctx context.Context) error
 }
 
 type SessionData struct {
@@ -48,20 +80,23 @@ type SessionData struct {
 	Username string
 }
 
-func NewHandler(hub *Hub, redis storage.RedisClient, sessionGetter session.SessionService, locationGetter location.LocationService, spamDetector SpamDetector, rateLimiter RateLimiter, messageTTL time.Duration) *Handler {
+func NewHandler(hub *Hub, redis storage.RedisClient, sessionGetter session.SessionService, locationGetter location.LocationService, spamDetector SpamDetector, bans BanChecker, redactor Redactor, rateLimiter RateLimiter, reputation Reputation, analytics Analytics, messageTTL time.Duration) *Handler {
 	return &Handler{
 		hub:            hub,
 		redis:          redis,
 		sessionGetter:  sessionGetter,
 		locationGetter: locationGetter,
 		spamDetector:   spamDetector,
+		bans:           bans,
+		redactor:       redactor,
 		rateLimiter:    rateLimiter,
+		reputation:     reputation,
+		analytics:      analytics,
 		messageTTL:     messageTTL,
 	}
 }
 
 func (h *Handler) HandleWebSocket(c *gin.Context) {
-	fmt.Println("in websocket handler")
 	sessionID := c.Query("session_id")
 	if sessionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id required"})
@@ -69,6 +104,7 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
+	ip := clientip.FromContext(c)
 
 	// Get session data
 	session, err := h.sessionGetter.Get(ctx, sessionID)
@@ -77,13 +113,23 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// Refuse banned sessions and IPs
+	banned, reason, err := h.bans.IsBanned(ctx, sessionID, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check ban"})
+		return
+	}
+	if banned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned: " + reason, "code": "BANNED"})
+		return
+	}
+
 	// Get location data
 	geohash, radius, err := h.locationGetter.GetGeohash(ctx, sessionID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "location not set"})
 		return
 	}
-	fmt.Println("geohash of ", sessionID)
 
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -92,21 +138,14 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	fmt.Println("create client ", sessionID)
 	// Create client
-	client := NewClient(h.hub, conn, sessionID, session.Username, geohash, radius, h)
-	fmt.Printf("create client  %s\n", sessionID)
+	client := NewClient(h.hub, conn, sessionID, session.Username, ip, geohash, radius, h)
 
 	// Register client
 	h.hub.register <- client
-	fmt.Println("client registered")
 
 	// Start goroutines
-	fmt.Println("starting go routines")
 	go client.WritePump()
-	fmt.Println("WritePump started")
-
-	fmt.Println("Starting ReadPump")
 	go client.ReadPump()
 }
 
@@ -114,25 +153,73 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 func (h *Handler) handleChatMessage(client *Client, incoming *IncomingMessage) {
 	ctx := context.Background()
 
+	multiplier, err := h.reputation.Multiplier(ctx, client.sessionID)
+	if err != nil {
+		multiplier = 1
+	}
+
 	// Rate limiting
-	allowed, err := h.rateLimiter.AllowMessage(ctx, client.sessionID)
-	if err != nil || !allowed {
+	limit, err := h.rateLimiter.AllowScaled(ctx, ratelimit.ActionMessage, client.sessionID, multiplier)
+	if err != nil {
 		client.SendError("Rate limit exceeded", "RATE_LIMIT")
 		return
 	}
+	if !limit.Allowed {
+		client.Send(NewRateLimitMessage("Rate limit exceeded", "RATE_LIMIT", limit.RetryAfter))
+		return
+	}
+
+	// Shadow-banned senders only ever see their own messages
+	shadowBanned, err := h.bans.IsShadowBanned(ctx, client.sessionID, client.ip)
+	if err != nil {
+		log.Printf("Failed to check shadow ban: %v", err)
+	}
+	if shadowBanned {
+		h.echoShadowMessage(ctx, client, incoming)
+		return
+	}
+
+	// Busy areas get a message ceiling and slow mode
+	if !h.allowAreaMessage(ctx, client) {
+		return
+	}
 
 	// Spam detection
-	if err := h.spamDetector.ValidateMessage(ctx, client.sessionID, incoming.Content); err != nil {
-		client.SendError(err.Error(), "SPAM_DETECTED")
-		h.spamDetector.IncrementViolation(ctx, client.sessionID, "spam")
+	decision, err := h.spamDetector.EvaluateScaled(ctx, client.sessionID, client.geohash, incoming.Content, multiplier)
+	if err != nil {
+		log.Printf("Failed to check message for spam: %v", err)
+		client.SendError("Failed to send message", "INTERNAL_ERROR")
 		return
 	}
+	if decision.Action == spam.ActionReject {
+		rejection := NewErrorMessage(decision.Message(), "SPAM_DETECTED")
+		rejection.Reason = string(decision.Reason())
+		client.Send(rejection)
+		h.recordViolation(ctx, client, decision.ViolationType())
+		for _, sessionID := range decision.RelatedSessions() {
+			h.recordRelatedViolation(ctx, sessionID, decision.ViolationType())
+		}
+		return
+	}
+
+	if decision.Action == spam.ActionSanitize {
+		client.Send(NewNoticeMessage("Parts of your message were removed: "+decision.Message(), "CONTENT_SANITIZED"))
+	}
+
+	// Mask contact info before the message is stored or fanned out
+	content, redacted := h.redactor.Redact(decision.Content)
+	if len(redacted) > 0 {
+		client.Send(NewNoticeMessage(
+			fmt.Sprintf("Your message was sent with the %s redacted", strings.Join(redacted, " and ")),
+			"PII_REDACTED",
+		))
+	}
 
 	// Create message
 	message := NewChatMessage(
 		client.sessionID,
 		client.username,
-		incoming.Content,
+		content,
 		client.geohash,
 		"", // Distance will be calculated per recipient
 	)
@@ -145,12 +232,109 @@ func (h *Handler) handleChatMessage(client *Client, incoming *IncomingMessage) {
 	}
 
 	// Broadcast to hub
-	fmt.Println("starting broadcast", client.geohash, incoming.Content)
 	h.hub.broadcast <- message
+
+	if err := h.analytics.RecordMessage(ctx); err != nil {
+		log.Printf("Failed to record message analytics: %v", err)
+	}
+}
+
+// allowAreaMessage checks a message against the limits of the area it
+// reaches, telling the sender if it is refused and the area if slow mode
+// changed.
+func (h *Handler) allowAreaMessage(ctx context.Context, client *Client) bool {
+	area, err := h.rateLimiter.AllowAreaMessage(ctx, client.sessionID, location.CoverageCells(client.geohash))
+	if err != nil {
+		log.Printf("Failed to check area rate limit: %v", err)
+		return true
+	}
+
+	if area.SlowModeChanged {
+		h.hub.BroadcastToGeohash(client.geohash, NewSlowModeMessage(area.SlowMode))
+	}
+
+	switch area.Refusal {
+	case ratelimit.AreaCeiling:
+		client.Send(NewRateLimitMessage("This area is too busy right now", "AREA_RATE_LIMIT", area.RetryAfter))
+	case ratelimit.AreaSlowMode:
+		client.Send(NewRateLimitMessage(fmt.Sprintf("Slow mode is on: one message every %s", area.SlowMode), "SLOW_MODE", area.RetryAfter))
+	}
+	return area.Allowed
+}
+
+// echoShadowMessage sends a shadow-banned client's message back to that
+// client only. It is kept in a per-session history so the sender's own view
+// stays consistent, but never reaches the area's fan-out or history.
+func (h *Handler) echoShadowMessage(ctx context.Context, client *Client, incoming *IncomingMessage) {
+	message := NewChatMessage(
+		client.sessionID,
+		client.username,
+		incoming.Content,
+		client.geohash,
+		"",
+	)
+
+	key := h.shadowMessagesKey(client.sessionID, client.geohash)
+	if err := h.storeMessageAt(ctx, key, message); err != nil {
+		log.Printf("Failed to store shadow message: %v", err)
+	}
+
+	client.Send(message)
+}
+
+// recordViolation counts a violation against the client's session and, once
+// the spam detector's ban rules are met, bans the session and IP and
+// disconnects the client.
+func (h *Handler) recordViolation(ctx context.Context, client *Client, violationType string) {
+	if err := h.spamDetector.IncrementViolation(ctx, client.sessionID, violationType); err != nil {
+		log.Printf("Failed to record violation: %v", err)
+		return
+	}
+
+	shouldBan, reason, err := h.spamDetector.ShouldBan(ctx, client.sessionID)
+	if err != nil {
+		log.Printf("Failed to evaluate ban rules: %v", err)
+		return
+	}
+	if !shouldBan {
+		return
+	}
+
+	action, duration, err := h.bans.Enforce(ctx, client.sessionID, client.ip, reason)
+	if err != nil {
+		log.Printf("Failed to ban session %s: %v", client.sessionID, err)
+		return
+	}
+	// The ban answers these violations; later ones start a new count
+	if err := h.spamDetector.ResetViolations(ctx, client.sessionID); err != nil {
+		log.Printf("Failed to reset violations for session %s: %v", client.sessionID, err)
+	}
+
+	log.Printf("Applied %s ban to session %s (ip %s) for %s: %s", action, client.sessionID, client.ip, duration, reason)
+	if action == spam.BanActionHard {
+		client.Disconnect(websocket.ClosePolicyViolation, "banned: "+reason)
+	}
+}
+
+// recordRelatedViolation charges a violation to another session implicated
+// in a rejected message, such as a fellow sender in an area flood. Sessions
+// connected to this server go through the full ban flow; others only have
+// the violation counted.
+func (h *Handler) recordRelatedViolation(ctx context.Context, sessionID, violationType string) {
+	if client, ok := h.hub.GetClient(sessionID); ok {
+		h.recordViolation(ctx, client, violationType)
+		return
+	}
+	if err := h.spamDetector.IncrementViolation(ctx, sessionID, violationType); err != nil {
+		log.Printf("Failed to record violation for session %s: %v", sessionID, err)
+	}
 }
 
 func (h *Handler) storeMessage(ctx context.Context, msg *Message) error {
-	key := fmt.Sprintf("messages:%s", msg.Geohash)
+	return h.storeMessageAt(ctx, h.messagesKey(msg.Geohash), msg)
+}
+
+func (h *Handler) storeMessageAt(ctx context.Context, key string, msg *Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
@@ -168,9 +352,32 @@ func (h *Handler) storeMessage(ctx context.Context, msg *Message) error {
 	return h.redis.Expire(ctx, key, h.messageTTL)
 }
 
-func (h *Handler) GetRecentMessages(ctx context.Context, geohash string, limit int64) ([]*Message, error) {
-	key := fmt.Sprintf("messages:%s", geohash)
+// GetRecentMessages returns the most recent messages in a geohash cell as seen
+// by viewerSessionID, newest first. A shadow-banned viewer also sees their own
+// hidden messages.
+func (h *Handler) GetRecentMessages(ctx context.Context, viewerSessionID, geohash string, limit int64) ([]*Message, error) {
+	messages, err := h.loadMessages(ctx, h.messagesKey(geohash), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	shadow, err := h.loadMessages(ctx, h.shadowMessagesKey(viewerSessionID, geohash), limit)
+	if err != nil || len(shadow) == 0 {
+		return messages, nil
+	}
+
+	messages = append(messages, shadow...)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp > messages[j].Timestamp
+	})
+	if int64(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
 
+func (h *Handler) loadMessages(ctx context.Context, key string, limit int64) ([]*Message, error) {
 	// Get recent messages
 	results, err := h.redis.ZRevRange(ctx, key, 0, limit-1)
 	if err != nil {
@@ -186,7 +393,13 @@ func (h *Handler) GetRecentMessages(ctx context.Context, geohash string, limit i
 		messages = append(messages, &msg)
 	}
 
-	// return messages of type chat messge
-
 	return messages, nil
 }
+
+func (h *Handler) messagesKey(geohash string) string {
+	return h.redis.Keys().Key("messages", geohash)
+}
+
+func (h *Handler) shadowMessagesKey(sessionID, geohash string) string {
+	return h.redis.Keys().Key("messages", "shadow", sessionID, geohash)
+}
@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askwhyharsh/neartalk/internal/location"
+	"github.com/askwhyharsh/neartalk/internal/ratelimit"
+	"github.com/askwhyharsh/neartalk/internal/session"
+	"github.com/askwhyharsh/neartalk/internal/spam"
+	"github.com/askwhyharsh/neartalk/internal/storage/storagetest"
+	"github.com/gin-gonic/gin"
+	gorillaws "github.com/gorilla/websocket"
+)
+
+// allowAll lets every message through the rate limits, reputation, ban and
+// spam checks, passing the content on unchanged.
+type allowAll struct{}
+
+func (allowAll) AllowScaled(ctx context.Context, action ratelimit.Action, id string, multiplier float64) (*ratelimit.Result, error) {
+	return &ratelimit.Result{Allowed: true}, nil
+}
+
+func (allowAll) AllowAreaMessage(ctx context.Context, sessionID string, cells []string) (*ratelimit.AreaResult, error) {
+	return &ratelimit.AreaResult{Allowed: true}, nil
+}
+
+func (allowAll) Multiplier(ctx context.Context, sessionID string) (float64, error) {
+	return 1, nil
+}
+
+func (allowAll) RecordMessage(ctx context.Context) error {
+	return nil
+}
+
+func (allowAll) EvaluateScaled(ctx context.Context, sessionID, geohash, content string, multiplier float64) (*spam.Decision, error) {
+	return &spam.Decision{Action: spam.ActionAllow, Content: content}, nil
+}
+
+func (allowAll) IncrementViolation(ctx context.Context, sessionID, violationType string) error {
+	return nil
+}
+
+func (allowAll) ShouldBan(ctx context.Context, sessionID string) (bool, string, error) {
+	return false, "", nil
+}
+
+func (allowAll) ResetViolations(ctx context.Context, sessionID string) error {
+	return nil
+}
+
+func (allowAll) Enforce(ctx context.Context, sessionID, ipAddress, reason string) (spam.BanAction, time.Duration, error) {
+	return "", 0, nil
+}
+
+func (allowAll) IsBanned(ctx context.Context, sessionID, ipAddress string) (bool, string, error) {
+	return false, "", nil
+}
+
+func (allowAll) IsShadowBanned(ctx context.Context, sessionID, ipAddress string) (bool, error) {
+	return false, nil
+}
+
+func TestChatMessageRedaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		content     string
+		wantContent string
+		// wantNotice is the notice the sender gets, empty for none
+		wantNotice string
+	}{
+		{"nothing to redact", "placeholder", "hello there", "hello there", ""},
+		{"email", "placeholder", "mail me at bob@example.com", "mail me at [Email removed]",
+			"Your message was sent with the email redacted"},
+		{"email and phone", "partial", "bob@example.com or 555-123-4567", "b***@example.com or ***-***-**67",
+			"Your message was sent with the email and phone redacted"},
+		{"redaction off", "off", "bob@example.com", "bob@example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisClient := storagetest.NewClient(t)
+
+			redactor, err := spam.NewRedactor(tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hub := NewHub(context.Background(), redisClient)
+			handler := NewHandler(hub, redisClient, nil, nil, allowAll{}, allowAll{}, redactor, allowAll{}, allowAll{}, allowAll{}, time.Minute)
+			client := NewClient(hub, nil, "s1", "alice", "192.0.2.1", "9q8yyk", 500, handler)
+
+			handler.handleChatMessage(client, &IncomingMessage{Type: "chat", Content: tt.content})
+
+			select {
+			case notice := <-client.send:
+				if tt.wantNotice == "" {
+					t.Errorf("sender got %+v, want nothing", notice)
+				} else if notice.Type != MessageTypeNotice || notice.ErrorCode != "PII_REDACTED" || notice.Content != tt.wantNotice {
+					t.Errorf("sender got %+v, want a PII_REDACTED notice %q", notice, tt.wantNotice)
+				}
+			default:
+				if tt.wantNotice != "" {
+					t.Errorf("sender got no notice, want %q", tt.wantNotice)
+				}
+			}
+
+			select {
+			case message := <-hub.broadcast:
+				if message.Content != tt.wantContent {
+					t.Errorf("broadcast %q, want %q", message.Content, tt.wantContent)
+				}
+			default:
+				t.Fatal("message not broadcast")
+			}
+
+			// What is stored is what was broadcast
+			stored, err := handler.GetRecentMessages(context.Background(), "s2", "9q8yyk", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != 1 || stored[0].Content != tt.wantContent {
+				t.Errorf("stored %+v, want one message %q", stored, tt.wantContent)
+			}
+		})
+	}
+}
+
+// rejectAll rejects every message as spam and has every sender meet the ban
+// rules.
+type rejectAll struct{ allowAll }
+
+func (rejectAll) EvaluateScaled(ctx context.Context, sessionID, geohash, content string, multiplier float64) (*spam.Decision, error) {
+	return &spam.Decision{
+		Action:   spam.ActionReject,
+		Verdicts: []spam.Verdict{{Action: spam.ActionReject, Reason: spam.ReasonDuplicate}},
+	}, nil
+}
+
+func (rejectAll) ShouldBan(ctx context.Context, sessionID string) (bool, string, error) {
+	return true, "spamming", nil
+}
+
+// banTest is a WebSocket server keeping its sessions, locations and bans
+// in memory.
+type banTest struct {
+	sessions  *session.Service
+	locations *location.Service
+	bans      *spam.BanManager
+	server    *httptest.Server
+}
+
+func newBanTest(t *testing.T, detector SpamDetector) *banTest {
+	t.Helper()
+
+	redisClient := storagetest.NewClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hub := NewHub(ctx, redisClient)
+	go hub.Run()
+
+	redactor, err := spam.NewRedactor("off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &banTest{
+		sessions:  session.NewService(redisClient, time.Hour, 3),
+		locations: location.NewService(redisClient, 6, 100, 2000),
+		bans:      spam.NewBanManager(redisClient, nil, time.Minute, time.Hour, 0),
+	}
+	handler := NewHandler(hub, redisClient, bt.sessions, bt.locations, detector, bt.bans, redactor, allowAll{}, allowAll{}, allowAll{}, time.Minute)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", handler.HandleWebSocket)
+	bt.server = httptest.NewServer(router)
+	t.Cleanup(bt.server.Close)
+
+	return bt
+}
+
+// newSession creates a located session and returns its ID.
+func (bt *banTest) newSession(t *testing.T) string {
+	t.Helper()
+
+	ctx := context.Background()
+	s, err := bt.sessions.Create(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.locations.UpdateLocation(ctx, s.ID, 37.7749, -122.4194, 500); err != nil {
+		t.Fatal(err)
+	}
+	return s.ID
+}
+
+// dial connects as sessionID. A refused connection returns the response's
+// status and body.
+func (bt *banTest) dial(t *testing.T, sessionID string) (*gorillaws.Conn, int, string) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(bt.server.URL, "http") + "/ws?session_id=" + sessionID
+	conn, resp, err := gorillaws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode, string(body)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.StatusCode, ""
+}
+
+func TestHandleWebSocketRefusesBanned(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("session", func(t *testing.T) {
+		bt := newBanTest(t, allowAll{})
+		banned, other := bt.newSession(t), bt.newSession(t)
+		if _, err := bt.bans.Ban(ctx, banned, "", "spamming"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, status, body := bt.dial(t, banned); status != http.StatusForbidden || !strings.Contains(body, `"code":"BANNED"`) {
+			t.Errorf("banned session got %d %s, want 403 BANNED", status, body)
+		}
+		if _, status, _ := bt.dial(t, other); status != http.StatusSwitchingProtocols {
+			t.Errorf("other session got %d, want it connected", status)
+		}
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		bt := newBanTest(t, allowAll{})
+		if _, err := bt.bans.Ban(ctx, "earlier", "127.0.0.1", "spamming"); err != nil {
+			t.Fatal(err)
+		}
+
+		// A fresh session from a banned IP is refused too
+		if _, status, body := bt.dial(t, bt.newSession(t)); status != http.StatusForbidden || !strings.Contains(body, "banned: spamming") {
+			t.Errorf("session from a banned IP got %d %s, want 403", status, body)
+		}
+	})
+}
+
+func TestHardBanDisconnects(t *testing.T) {
+	bt := newBanTest(t, rejectAll{})
+	sessionID := bt.newSession(t)
+
+	conn, status, _ := bt.dial(t, sessionID)
+	if conn == nil {
+		t.Fatalf("dial got %d, want it connected", status)
+	}
+	if err := conn.WriteJSON(IncomingMessage{Type: MessageTypeChat, Content: "buy now"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rejection arrives, then the connection is closed with the reason
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var closeErr *gorillaws.CloseError
+	for {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("read %v, want the connection closed", err)
+			}
+			break
+		}
+	}
+	if closeErr.Code != gorillaws.ClosePolicyViolation || closeErr.Text != "banned: spamming" {
+		t.Errorf("closed with %d %q, want %d %q", closeErr.Code, closeErr.Text, gorillaws.ClosePolicyViolation, "banned: spamming")
+	}
+
+	// And the session cannot reconnect
+	if _, status, _ := bt.dial(t, sessionID); status != http.StatusForbidden {
+		t.Errorf("reconnect got %d, want 403", status)
+	}
+}
@@ -3,7 +3,6 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"sync"
 
 	"github.com/askwhyharsh/neartalk/internal/storage"
@@ -36,13 +35,10 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
-			fmt.Println("reg client")
 			h.registerClient(client)
 		case client := <-h.unregister:
-			fmt.Println("un reg client ")
 			h.unregisterClient(client)
 		case message := <-h.broadcast:
-			fmt.Println("hereeeeee")
 			h.broadcastMessage(message)
 		case <-h.ctx.Done():
 			h.shutdown()
@@ -56,7 +52,7 @@ func (h *Hub) registerClient(client *Client) {
 	h.clients[client.sessionID] = client
 
 	// Index by geohash
-	geohashPrefix := client.geohash[:4] // Use first 4 chars for proximity
+	geohashPrefix := roomPrefix(client.geohash)
 	if h.clientsByGeohash[geohashPrefix] == nil {
 		h.clientsByGeohash[geohashPrefix] = make(map[string]*Client)
 	}
@@ -65,7 +61,7 @@ func (h *Hub) registerClient(client *Client) {
 	h.mu.Unlock()
 	
 	// Store in Redis for distributed tracking
-	key := "ws:active"
+	key := h.redis.Keys().Key("ws", "active")
 	h.redis.SAdd(h.ctx, key, client.sessionID)
 
 	// Notify others about new user
@@ -81,7 +77,7 @@ func (h *Hub) unregisterClient(client *Client) {
 		close(client.send)
 
 		// Remove from Redis
-		key := "ws:active"
+		key := h.redis.Keys().Key("ws", "active")
 		h.redis.SRem(h.ctx, key, client.sessionID)
 
 		// Notify others about user leaving
@@ -90,42 +86,33 @@ func (h *Hub) unregisterClient(client *Client) {
 }
 
 func (h *Hub) broadcastMessage(message *Message) {
-	fmt.Println("in broadcast message")
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	// Only iterate through clients in the same geohash area
-	geohashPrefix := message.Geohash[:4]
+	geohashPrefix := roomPrefix(message.Geohash)
 	targetClients := h.clientsByGeohash[geohashPrefix]
-	fmt.Printf("Broadcasting to %d clients in geohash %s (total clients: %d)\n", 
-		len(targetClients), geohashPrefix, len(h.clients))
 	// Publish to Redis for multi-server support
-	channel := "chat:" + message.Geohash
+	channel := h.redis.Keys().Key("chat", message.Geohash)
 	data, _ := json.Marshal(message)
 	h.redis.Publish(h.ctx, channel, data)
 
 	// Broadcast to local clients
-	sentCount := 0
 	for _, client := range targetClients {
 		// Only send to clients in the same geohash or nearby
 		if client.shouldReceiveMessage(message) {
 			select {
 			case client.send <- message:
-				sentCount++
-				fmt.Printf("Sent message to client %s\n", client.sessionID)
 			default:
 				// Client's send channel is full, close it
-				fmt.Printf("Client %s send channel full, closing\n", client.sessionID)
 				close(client.send)
 				delete(h.clients, client.sessionID)
 			}
 		}
 	}
-	fmt.Printf("Message sent to %d clients\n", sentCount)
 }
 
 func (h *Hub) broadcastUserJoined(client *Client) {
-	println("broadcast user joined")
 	message := &Message{
 		Type:      MessageTypeUserJoined,
 		Username:  client.username,
@@ -194,11 +181,20 @@ func (h *Hub) BroadcastToGeohash(geohash string, message *Message) {
 	}
 }
 
+// roomPrefix returns the chat room a geohash is in: its first 4
+// characters, or all of it when GEOHASH_PRECISION is below 4.
+func roomPrefix(geohash string) string {
+	if len(geohash) < 4 {
+		return geohash
+	}
+	return geohash[:4]
+}
+
 // Helper function to check if two geohashes are neighbors
 func isNeighborGeohash(gh1, gh2 string) bool {
-	if len(gh1) < 4 || len(gh2) < 4 {
+	if gh1 == "" || gh2 == "" {
 		return false
 	}
-	// Simple check: first 4 characters should be similar for proximity
-	return gh1[:4] == gh2[:4]
+	// Simple check: both should be in the same room
+	return roomPrefix(gh1) == roomPrefix(gh2)
 }
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +14,8 @@ const (
 	MessageTypePing       = "ping"
 	MessageTypePong       = "pong"
 	MessageTypeError      = "error"
+	MessageTypeNotice     = "notice"
+	MessageTypeSlowMode   = "slow_mode"
 )
 
 type Message struct {
@@ -26,6 +29,11 @@ type Message struct {
 	Geohash   string `json:"-"` // Not exposed to clients
 	UserCount int    `json:"user_count,omitempty"`
 	ErrorCode string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// RetryAfterMs tells a rate-limited client how long to wait
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+	// IntervalMs is the slow mode interval between a sender's messages
+	IntervalMs int64 `json:"interval_ms,omitempty"`
 }
 
 type IncomingMessage struct {
@@ -54,4 +62,38 @@ func NewErrorMessage(errMsg, code string) *Message {
 		ErrorCode: code,
 		Timestamp: time.Now().Unix(),
 	}
-}
\ No newline at end of file
+}
+
+// NewRateLimitMessage is a rate limit error saying how long until the client
+// may send again.
+func NewRateLimitMessage(errMsg, code string, retryAfter time.Duration) *Message {
+	msg := NewErrorMessage(errMsg, code)
+	msg.RetryAfterMs = retryAfter.Milliseconds()
+	return msg
+}
+
+// NewSlowModeMessage tells clients in an area that slow mode is on with the
+// given interval between each sender's messages, or off if it is zero.
+func NewSlowModeMessage(interval time.Duration) *Message {
+	content := "Slow mode is off"
+	if interval > 0 {
+		content = fmt.Sprintf("Slow mode is on: one message every %s", interval)
+	}
+	return &Message{
+		Type:       MessageTypeSlowMode,
+		Content:    content,
+		IntervalMs: interval.Milliseconds(),
+		Timestamp:  time.Now().Unix(),
+	}
+}
+
+// NewNoticeMessage tells a client about something that happened to its own
+// message without failing it, e.g. that parts of it were redacted.
+func NewNoticeMessage(text, code string) *Message {
+	return &Message{
+		Type:      MessageTypeNotice,
+		Content:   text,
+		ErrorCode: code,
+		Timestamp: time.Now().Unix(),
+	}
+}
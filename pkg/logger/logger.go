@@ -52,3 +52,13 @@ func (l *SlogLogger) Debug(msg string, args ...any) {
 func (l *SlogLogger) Warn(msg string, args ...any) {
 	l.logger.Warn(msg, args...)
 }
+
+// Discard is a Logger that drops every message.
+var Discard Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Info(msg string, args ...any)  {}
+func (discardLogger) Error(msg string, args ...any) {}
+func (discardLogger) Debug(msg string, args ...any) {}
+func (discardLogger) Warn(msg string, args ...any)  {}
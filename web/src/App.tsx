@@ -227,7 +227,14 @@ function App() {
             setNearbyCount(msg.user_count);
           }
         } else if (msg.type === "error") {
-          setError(msg.content || "An error occurred");
+          if (msg.retry_after_ms) {
+            const seconds = Math.ceil(msg.retry_after_ms / 1000);
+            setError(`${msg.content}. Try again in ${seconds}s`);
+          } else {
+            setError(msg.content || "An error occurred");
+          }
+        } else if (msg.type === "notice" || msg.type === "slow_mode") {
+          setError(msg.content);
         }
       } catch (err) {
         console.error("Failed to parse message:", err);